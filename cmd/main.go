@@ -2,15 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 
 	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/config/database"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/outbox"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/service"
 	kafka "github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/middleware"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/metrics"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/idempotency"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/schema"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/tracing"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+	schemaregistry "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/schema-registry"
 	validation "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/validation-util"
 	"github.com/yoanesber/go-kafka-messaging-demo/routes"
 )
@@ -18,11 +36,26 @@ import (
 var (
 	kafkaInitialized     bool
 	validatorInitialized bool
+	boltInitialized      bool
+
+	messageRepository     repository.MessageRepository
+	idempotencyRepository repository.IdempotencyRepository
+	messageService        service.MessageService
+	outboxRelay           *outbox.Relay
+	workersWG             sync.WaitGroup // Tracks the Kafka consumer workers and the outbox relay until they have stopped
+)
+
+const (
+	messageStoreMemory = "memory"
+	messageStoreBolt   = "bolt"
+
+	defaultShutdownTimeout    = 30 * time.Second
+	defaultSchemaRegistryFile = "data/schema-registry.json"
 )
 
 func main() {
 	// Create base context with cancel for graceful shutdown
-	_, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Get environment variables
@@ -32,7 +65,7 @@ func main() {
 	apiVersion := os.Getenv("API_VERSION")
 
 	if env == "" || port == "" || isSSL == "" || apiVersion == "" {
-		fmt.Println("Environment variables ENV, PORT, IS_SSL, and API_VERSION must be set.")
+		logger.Error("Environment variables ENV, PORT, IS_SSL, and API_VERSION must be set", nil)
 		return
 	}
 
@@ -42,69 +75,359 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Setup router
-	r := routes.SetupRouter()
-	r.SetTrustedProxies(nil) // Set trusted proxies to nil to avoid issues with forwarded headers
+	// Init tracing before anything that publishes or consumes messages
+	shutdownTracing, err := tracing.Init(ctx)
+	if err != nil {
+		logger.Error("Failed to initialize tracing. Exiting...", logrus.Fields{logger.FieldError: err.Error()})
+		return
+	}
 
 	// Init all dependencies
-	initializeDependencies()
+	if !initializeDependencies(ctx) {
+		return
+	}
 
-	// Graceful shutdown
-	gracefulShutdown(cancel)
+	// Setup router
+	r := routes.SetupRouter(messageService, outboxRelay, idempotencyRepository)
+	r.SetTrustedProxies(nil) // Set trusted proxies to nil to avoid issues with forwarded headers
 
 	// Start the server
-	if err := r.Run(":" + port); err != nil {
-		fmt.Printf("Failed to start server on port %s: %v\n", port, err)
-		return
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
 	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to start server", logrus.Fields{"port": port, logger.FieldError: err.Error()})
+			serverErr <- err
+		}
+	}()
+
+	// Graceful shutdown
+	gracefulShutdown(cancel, srv, serverErr, shutdownTracing)
 }
 
-func initializeDependencies() {
+func initializeDependencies(ctx context.Context) bool {
 	if !validatorInitialized {
 		if !validation.Init() {
-			fmt.Println("Failed to initialize validator. Exiting...")
+			logger.Error("Failed to initialize validator. Exiting...", nil)
 		} else {
 			validatorInitialized = true
 		}
 	}
 
+	if messageRepository == nil {
+		repo, err := initMessageRepository()
+		if err != nil {
+			logger.Error("Failed to initialize message repository. Exiting...", logrus.Fields{logger.FieldError: err.Error()})
+			return false
+		}
+		messageRepository = repo
+	}
+
+	if idempotencyRepository == nil {
+		repo, err := initIdempotencyRepository()
+		if err != nil {
+			logger.Error("Failed to initialize idempotency repository. Exiting...", logrus.Fields{logger.FieldError: err.Error()})
+			return false
+		}
+		idempotencyRepository = repo
+
+		// Remove the expired idempotency keys in the background
+		go cleanupExpired(ctx, "idempotency keys", max(idempotency.GetTTL()/4, time.Minute), idempotencyRepository.DeleteExpired)
+	}
+
+	// Get the publish mode from the environment variable, default to sync if not set
+	publishMode := os.Getenv("MESSAGE_PUBLISH_MODE")
+	if publishMode == "" {
+		publishMode = service.PublishModeSync
+	}
+	if publishMode != service.PublishModeSync && publishMode != service.PublishModeOutbox && publishMode != service.PublishModeAsync {
+		logger.Error("Unknown MESSAGE_PUBLISH_MODE value. Exiting...", logrus.Fields{"value": publishMode})
+		return false
+	}
+	messageService = service.NewMessageService(messageRepository, publishMode)
+
+	// Update the status of the messages once the asynchronous writer reports their delivery
+	if publishMode == service.PublishModeAsync {
+		kafkautil.OnDelivery(messageService.HandleDelivery)
+	}
+
 	if !kafkaInitialized {
 		if !async.InitKafka() {
-			fmt.Println("Failed to initialize Kafka. Exiting...")
+			logger.Error("Failed to initialize Kafka. Exiting...", nil)
 		} else {
 			kafkaInitialized = true
 
+			// Register the Avro schemas if the messages are encoded with Avro
+			if async.GetKafkaCodec(service.TopicMessage) == async.CodecAvro && !initSchemaRegistry(ctx) {
+				return false
+			}
+
+			// Drop the events that were already processed
+			if !useDedup(ctx) {
+				return false
+			}
+
 			// Start consuming messages from Kafka
-			fmt.Println("Starting Kafka message consumption...")
-			kafka.StartConsumer()
-			fmt.Println("Kafka message consumption started.")
+			logger.Info("Starting Kafka message consumption...", nil)
+			kafka.StartConsumer(ctx, &workersWG, messageService)
+			logger.Info("Kafka message consumption started", nil)
+
+			// Start publishing the outbox entries to Kafka
+			if publishMode == service.PublishModeOutbox && !startOutboxRelay(ctx) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func initMessageRepository() (repository.MessageRepository, error) {
+	switch store := getMessageStore(); store {
+	case messageStoreMemory:
+		logger.Info("Using in-memory message store", nil)
+		return repository.NewMemoryMessageRepository(), nil
+	case messageStoreBolt:
+		if !database.InitBolt() {
+			return nil, fmt.Errorf("failed to initialize bolt database")
 		}
+		boltInitialized = true
+
+		db, err := database.GetBoltDB()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewBoltMessageRepository(db)
+	default:
+		return nil, fmt.Errorf("unknown MESSAGE_STORE value: %s", store)
 	}
 }
 
-func gracefulShutdown(cancel context.CancelFunc) {
+func initIdempotencyRepository() (repository.IdempotencyRepository, error) {
+	// Idempotency keys are kept in the same store as the messages, so they survive a restart with it
+	switch store := getMessageStore(); store {
+	case messageStoreMemory:
+		return repository.NewMemoryIdempotencyRepository(), nil
+	case messageStoreBolt:
+		db, err := database.GetBoltDB()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewBoltIdempotencyRepository(db)
+	default:
+		return nil, fmt.Errorf("unknown MESSAGE_STORE value: %s", store)
+	}
+}
+
+func getMessageStore() string {
+	// Get the message store from the environment variable, default to in-memory if not set
+	store := os.Getenv("MESSAGE_STORE")
+	if store == "" {
+		return messageStoreMemory
+	}
+
+	return store
+}
+
+func initSchemaRegistry(ctx context.Context) bool {
+	compatibility, err := schemaregistry.ParseCompatibility(os.Getenv("SCHEMA_REGISTRY_COMPATIBILITY"))
+	if err != nil {
+		logger.Error("Invalid SCHEMA_REGISTRY_COMPATIBILITY value. Exiting...", logrus.Fields{logger.FieldError: err.Error()})
+		return false
+	}
+
+	// Use the registry at SCHEMA_REGISTRY_URL if set, otherwise a local file registry
+	var registry schemaregistry.SchemaRegistry
+	if registryURL := os.Getenv("SCHEMA_REGISTRY_URL"); registryURL != "" {
+		// The registry keeps its own level unless SCHEMA_REGISTRY_COMPATIBILITY is set
+		if os.Getenv("SCHEMA_REGISTRY_COMPATIBILITY") == "" {
+			compatibility = ""
+		}
+		registry = schemaregistry.NewHTTPSchemaRegistry(registryURL, compatibility)
+		logger.Info("Using schema registry", logrus.Fields{"url": registryURL, "compatibility": compatibility})
+	} else {
+		path := os.Getenv("SCHEMA_REGISTRY_FILE")
+		if path == "" {
+			path = defaultSchemaRegistryFile
+		}
+
+		registry, err = schemaregistry.NewFileSchemaRegistry(path, compatibility)
+		if err != nil {
+			logger.Error("Failed to initialize schema registry. Exiting...", logrus.Fields{logger.FieldError: err.Error()})
+			return false
+		}
+		logger.Info("Using local schema registry", logrus.Fields{"path": path})
+	}
+
+	// Register the schema of the messages, this fails if it is not compatible with the registered one
+	subject := kafkautil.AvroSubject(service.TopicMessage)
+	id, err := registry.Register(ctx, subject, schema.MessageAvro)
+	if err != nil {
+		logger.Error("Failed to register schema. Exiting...", logrus.Fields{"subject": subject, logger.FieldError: err.Error()})
+		return false
+	}
+	logger.Info("Schema registered", logrus.Fields{"subject": subject, "schema_id": id})
+
+	kafkautil.SetSchemaRegistry(registry)
+	return true
+}
+
+func initProcessedEventRepository() (repository.ProcessedEventRepository, error) {
+	// Processed events are kept in the same store as the messages, so duplicates are still dropped after a restart with it
+	switch store := getMessageStore(); store {
+	case messageStoreMemory:
+		_, size := async.GetKafkaDedupConfig()
+		return repository.NewMemoryProcessedEventRepository(size), nil
+	case messageStoreBolt:
+		db, err := database.GetBoltDB()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewBoltProcessedEventRepository(db)
+	default:
+		return nil, fmt.Errorf("unknown MESSAGE_STORE value: %s", store)
+	}
+}
+
+func useDedup(ctx context.Context) bool {
+	processedEventRepository, err := initProcessedEventRepository()
+	if err != nil {
+		logger.Error("Failed to initialize processed event repository. Exiting...", logrus.Fields{logger.FieldError: err.Error()})
+		return false
+	}
+
+	ttl, _ := async.GetKafkaDedupConfig()
+	kafka.Use(middleware.Dedup(processedEventRepository, ttl))
+	metrics.DuplicatesDropped(middleware.DuplicatesDropped)
+
+	// Remove the expired events in the background
+	go cleanupExpired(ctx, "processed events", max(ttl/4, time.Minute), processedEventRepository.DeleteExpired)
+	return true
+}
+
+// cleanupExpired calls deleteExpired every interval until ctx is cancelled.
+func cleanupExpired(ctx context.Context, name string, interval time.Duration, deleteExpired func(ctx context.Context) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := deleteExpired(ctx)
+			if err != nil {
+				logger.Error("Failed to delete expired "+name, logrus.Fields{logger.FieldError: err.Error()})
+			} else if deleted > 0 {
+				logger.Info("Deleted expired "+name, logrus.Fields{"count": deleted})
+			}
+		}
+	}
+}
+
+func startOutboxRelay(ctx context.Context) bool {
+	outboxRepository, err := repository.NewOutboxRepository(messageRepository)
+	if err != nil {
+		logger.Error("Failed to initialize outbox. Exiting...", logrus.Fields{logger.FieldError: err.Error()})
+		return false
+	}
+
+	getWriter := func(topic string) (outbox.Writer, error) {
+		return async.GetKafkaWriter(topic)
+	}
+	outboxRelay = outbox.NewRelay(outboxRepository, messageRepository, getWriter, outbox.LoadRelayConfig())
+
+	workersWG.Add(1)
+	go func() {
+		defer workersWG.Done()
+		outboxRelay.Run(ctx)
+	}()
+
+	// Remove the published entries once they are older than the retention
+	go cleanupExpired(ctx, "outbox entries", max(outboxRelay.Config.DoneRetention/4, time.Minute), outboxRelay.DeleteExpired)
+
+	logger.Info("Outbox relay started", nil)
+	return true
+}
+
+func gracefulShutdown(cancel context.CancelFunc, srv *http.Server, serverErr <-chan error, shutdownTracing func(ctx context.Context) error) {
 	// Handle graceful shutdown signals
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		sig := <-quit
-		fmt.Printf("Received signal: %s. Initiating graceful shutdown...\n", sig)
+	// Wait for a signal, or for the server to fail
+	select {
+	case sig := <-quit:
+		logger.Info("Received signal. Initiating graceful shutdown...", logrus.Fields{"signal": sig.String()})
+	case <-serverErr:
+		logger.Warn("Server stopped unexpectedly. Initiating graceful shutdown...", nil)
+	}
 
-		// Cancel context
-		cancel()
+	// Everything below has to be done within the drain timeout
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), getShutdownTimeout())
+	defer shutdownCancel()
 
-		// Clean up resources
-		if kafkaInitialized {
-			fmt.Println("Closing Kafka connections...")
-			async.CloseKafka()
-		}
+	// Cancel context, so the consumer workers stop fetching new messages
+	cancel()
+
+	// Stop accepting requests and wait for the in-flight ones
+	logger.Info("Shutting down HTTP server...", nil)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shut down HTTP server", logrus.Fields{logger.FieldError: err.Error()})
+	}
+
+	// Wait for the consumer workers to finish their in-flight message and commit,
+	// and for the outbox relay to finish its batch
+	if kafkaInitialized {
+		logger.Info("Waiting for Kafka workers to finish...", nil)
+		done := make(chan struct{})
+		go func() {
+			workersWG.Wait()
+			close(done)
+		}()
 
-		if validatorInitialized {
-			fmt.Println("Clearing validator...")
-			validation.ClearValidator()
+		select {
+		case <-done:
+			logger.Info("Kafka workers stopped", logrus.Fields{"duplicates_dropped": middleware.DuplicatesDropped()})
+		case <-shutdownCtx.Done():
+			logger.Warn("Timed out waiting for Kafka workers to finish", nil)
 		}
+	}
 
-		os.Exit(0)
-	}()
+	// Clean up resources
+	if kafkaInitialized {
+		logger.Info("Closing Kafka connections...", nil)
+		async.CloseKafka()
+	}
+
+	if boltInitialized {
+		logger.Info("Closing bolt database...", nil)
+		database.CloseBolt()
+	}
+
+	if validatorInitialized {
+		logger.Info("Clearing validator...", nil)
+		validation.ClearValidator()
+	}
+
+	// Export the spans that were not exported yet
+	logger.Info("Flushing traces...", nil)
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush traces", logrus.Fields{logger.FieldError: err.Error()})
+	}
+}
+
+func getShutdownTimeout() time.Duration {
+	// Get the drain timeout from the environment variable, default to 30 seconds if not set
+	timeoutStr := os.Getenv("SHUTDOWN_TIMEOUT_MS")
+	ms, err := strconv.Atoi(timeoutStr)
+	if err != nil || ms <= 0 {
+		return defaultShutdownTimeout
+	}
+
+	return time.Duration(ms) * time.Millisecond
 }
@@ -1,21 +1,65 @@
 package async
 
 import (
+	"cmp"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
 )
 
 type KafkaClient struct {
 	Writers map[string]*kafka.Writer
 	Readers map[string]*kafka.Reader
+
+	// Asynchronous writers of the topics, created on first use by GetKafkaAsyncWriter
+	AsyncWriters   map[string]*kafka.Writer
+	asyncWritersMu sync.Mutex
+}
+
+// CompletionFunc is called by an asynchronous writer with each batch of messages it wrote, or failed to write.
+type CompletionFunc func(messages []kafka.Message, err error)
+
+// RetryTier is a retry topic whose messages are re-delivered only after Delay has passed.
+type RetryTier struct {
+	Topic string
+	Delay time.Duration
 }
 
+const (
+	// CommitModeAuto lets the reader commit offsets in the background as soon as a message is read
+	CommitModeAuto = "auto"
+	// CommitModeManual commits offsets only after the message has been handled (at-least-once)
+	CommitModeManual = "manual"
+)
+
+const (
+	// EventFormatLegacy publishes events in the {event_type, version, payload} envelope
+	EventFormatLegacy = "legacy"
+	// EventFormatBinary publishes events as CloudEvents in binary mode, with the attributes in ce_ headers
+	EventFormatBinary = "binary"
+	// EventFormatStructured publishes events as CloudEvents in structured mode, with the whole event as JSON value
+	EventFormatStructured = "structured"
+)
+
+const (
+	// CodecJSON encodes the values of a topic as JSON
+	CodecJSON = "json"
+	// CodecProtobuf encodes the values of a topic with protobuf
+	CodecProtobuf = "protobuf"
+	// CodecAvro encodes the values of a topic with Avro, in the Confluent wire format
+	CodecAvro = "avro"
+)
+
 var (
 	kafkaClient *KafkaClient
 	once        sync.Once
@@ -23,12 +67,42 @@ var (
 	kafkaBrokers      []string
 	kafkaTopics       []string
 	kafkaGroupID      string
+	kafkaDLQTopic     string
+	kafkaRetryDelays  []string
+	kafkaCommitMode   string
+	kafkaCommitModes  map[string]string
+	kafkaCommitBatch  int
+	kafkaCommitFlush  time.Duration
 	kafkaReadTimeout  time.Duration
 	kafkaWriteTimeout time.Duration
+	kafkaDedupTTL     time.Duration
+	kafkaDedupSize    int
+	kafkaProducer     string
+	kafkaEventFormat  string
+	kafkaCodec        string
+	kafkaCodecs       map[string]string
+	kafkaCEPrefix     string
+
+	// Batching of the writers, zero values use the kafka-go defaults
+	kafkaWriterBatchSize    int
+	kafkaWriterBatchBytes   int64
+	kafkaWriterBatchTimeout time.Duration
+	kafkaWriterRequiredAcks kafka.RequiredAcks
 )
 
 const (
 	defaultKafkaGroupID        = "default-group"
+	defaultKafkaDLQTopic       = "messaging.dlq"
+	defaultKafkaRetryDelays    = "5s,1m,10m"
+	defaultKafkaCommitMode     = CommitModeAuto
+	defaultKafkaCommitBatch    = 100
+	defaultKafkaCommitInterval = time.Second
+	defaultKafkaProducer       = "go-kafka-messaging-demo"
+	defaultKafkaEventFormat    = EventFormatLegacy
+	defaultKafkaCodec          = CodecJSON
+	defaultKafkaCEPrefix       = "com.github.yoanesber.messaging."
+	defaultKafkaDedupTTL       = time.Hour
+	defaultKafkaDedupSize      = 10000
 	defaultKafkaReadTimeout    = 10 * time.Second
 	defaultKafkaWriteTimeout   = 10 * time.Second
 	defaultKafkaReaderMinBytes = int(10e3) // 10KB
@@ -44,13 +118,14 @@ func InitKafka() bool {
 		}
 
 		client := &KafkaClient{
-			Writers: make(map[string]*kafka.Writer),
-			Readers: make(map[string]*kafka.Reader),
+			Writers:      make(map[string]*kafka.Writer),
+			Readers:      make(map[string]*kafka.Reader),
+			AsyncWriters: make(map[string]*kafka.Writer),
 		}
 
 		for _, topic := range kafkaTopics {
 			if topic == "" {
-				fmt.Printf("Kafka topic cannot be empty\n")
+				logger.Warn("Kafka topic cannot be empty", nil)
 				continue
 			}
 
@@ -61,10 +136,22 @@ func InitKafka() bool {
 			// Initialize reader
 			reader := initKafkaReader(topic)
 			client.Readers[topic] = reader
+
+			// Initialize writer and reader for each retry tier of the topic
+			for _, tier := range GetKafkaRetryTiers(topic) {
+				client.Writers[tier.Topic] = initKafkaWriter(tier.Topic)
+				client.Readers[tier.Topic] = initKafkaReader(tier.Topic)
+			}
+		}
+
+		// Initialize writer for the dead-letter topic
+		// Nothing consumes from it in this service, so it does not need a reader
+		if _, exists := client.Writers[kafkaDLQTopic]; !exists {
+			client.Writers[kafkaDLQTopic] = initKafkaWriter(kafkaDLQTopic)
 		}
 
 		kafkaClient = client
-		fmt.Printf("Kafka client initialized with topics: %v\n", kafkaTopics)
+		logger.Info("Kafka client initialized", logrus.Fields{"topics": kafkaTopics})
 	})
 
 	return isSuccess
@@ -83,6 +170,34 @@ func GetKafkaWriter(topic string) (*kafka.Writer, error) {
 	return writer, nil
 }
 
+// GetKafkaAsyncWriter returns the asynchronous writer for the topic, creating it on first use.
+// WriteMessages returns as soon as the messages are queued, and completion is called once they are written.
+// Only the configured topics have one, the retry and dead-letter topics are always written synchronously.
+// The completion of the first call for the topic is used for all later calls.
+func GetKafkaAsyncWriter(topic string, completion CompletionFunc) (*kafka.Writer, error) {
+	if kafkaClient == nil {
+		return nil, fmt.Errorf("kafka client is not initialized")
+	}
+
+	kafkaClient.asyncWritersMu.Lock()
+	defer kafkaClient.asyncWritersMu.Unlock()
+
+	if writer, exists := kafkaClient.AsyncWriters[topic]; exists {
+		return writer, nil
+	}
+
+	if !slices.Contains(kafkaTopics, topic) {
+		return nil, fmt.Errorf("kafka writer for topic %s does not exist", topic)
+	}
+
+	writer := initKafkaWriter(topic)
+	writer.Async = true
+	writer.Completion = completion
+	kafkaClient.AsyncWriters[topic] = writer
+
+	return writer, nil
+}
+
 func GetKafkaReader(topic string) (*kafka.Reader, error) {
 	if kafkaClient == nil {
 		return nil, fmt.Errorf("kafka client is not initialized")
@@ -96,29 +211,156 @@ func GetKafkaReader(topic string) (*kafka.Reader, error) {
 	return reader, nil
 }
 
+// GetKafkaReaders returns the readers of all topics, to collect their stats.
+func GetKafkaReaders() map[string]*kafka.Reader {
+	if kafkaClient == nil {
+		return nil
+	}
+
+	return maps.Clone(kafkaClient.Readers)
+}
+
+// GetKafkaWriters returns the synchronous and asynchronous writers of all topics, to collect their stats.
+// Only the asynchronous writers created so far are returned.
+func GetKafkaWriters() (map[string]*kafka.Writer, map[string]*kafka.Writer) {
+	if kafkaClient == nil {
+		return nil, nil
+	}
+
+	kafkaClient.asyncWritersMu.Lock()
+	defer kafkaClient.asyncWritersMu.Unlock()
+
+	return maps.Clone(kafkaClient.Writers), maps.Clone(kafkaClient.AsyncWriters)
+}
+
+// GetKafkaDLQTopic returns the topic that messages are sent to when they cannot be handled.
+func GetKafkaDLQTopic() string {
+	return kafkaDLQTopic
+}
+
+// GetKafkaRetryTiers returns the retry tiers of a topic, ordered from the shortest delay whatever the order of KAFKA_RETRY_DELAYS.
+// Retry topics are named after the topic and the delay, e.g., "messaging.retry.5s".
+func GetKafkaRetryTiers(topic string) []RetryTier {
+	tiers := make([]RetryTier, 0, len(kafkaRetryDelays))
+	for _, delay := range kafkaRetryDelays {
+		d, err := time.ParseDuration(delay)
+		if err != nil {
+			continue
+		}
+
+		tiers = append(tiers, RetryTier{
+			Topic: fmt.Sprintf("%s.retry.%s", topic, delay),
+			Delay: d,
+		})
+	}
+	slices.SortStableFunc(tiers, func(a, b RetryTier) int {
+		return cmp.Compare(a.Delay, b.Delay)
+	})
+
+	return tiers
+}
+
+// GetKafkaCommitMode returns how offsets are committed for the topic, either CommitModeAuto or CommitModeManual.
+// Retry topics are always committed manually: their consumer waits for each message to be due before handling it,
+// and an offset committed before the wait would lose the message if the process stopped during it.
+func GetKafkaCommitMode(topic string) string {
+	if isRetryTopic(topic) {
+		return CommitModeManual
+	}
+
+	if mode, exists := kafkaCommitModes[topic]; exists {
+		return mode
+	}
+
+	return kafkaCommitMode
+}
+
+// GetKafkaCommitBatch returns how many handled messages are committed at once in manual commit mode,
+// and how long to wait at most before committing a smaller batch.
+func GetKafkaCommitBatch() (int, time.Duration) {
+	return kafkaCommitBatch, kafkaCommitFlush
+}
+
+// GetKafkaProducerName returns the name of this service, put in the producer header of the published messages.
+func GetKafkaProducerName() string {
+	if kafkaProducer == "" {
+		return defaultKafkaProducer
+	}
+
+	return kafkaProducer
+}
+
+// GetKafkaEventFormat returns the format events are published in, one of the EventFormat constants.
+func GetKafkaEventFormat() string {
+	if kafkaEventFormat == "" {
+		return defaultKafkaEventFormat
+	}
+
+	return kafkaEventFormat
+}
+
+// GetKafkaCodec returns the codec of the topic, the KAFKA_TOPIC_CODECS entry if set,
+// otherwise the KAFKA_CODEC one.
+func GetKafkaCodec(topic string) string {
+	if codec, exists := kafkaCodecs[topic]; exists {
+		return codec
+	}
+
+	if kafkaCodec == "" {
+		return defaultKafkaCodec
+	}
+
+	return kafkaCodec
+}
+
+// GetKafkaCloudEventsTypePrefix returns the reverse-DNS prefix of the CloudEvents type names.
+func GetKafkaCloudEventsTypePrefix() string {
+	if kafkaCEPrefix == "" {
+		return defaultKafkaCEPrefix
+	}
+
+	return kafkaCEPrefix
+}
+
+// GetKafkaDedupConfig returns how long a processed event is remembered to drop its duplicates,
+// and how many events the in-memory store remembers at most.
+func GetKafkaDedupConfig() (time.Duration, int) {
+	return kafkaDedupTTL, kafkaDedupSize
+}
+
 func CloseKafka() {
 	if kafkaClient != nil {
+		// Close the asynchronous writers first, this flushes the messages they still have queued
+		kafkaClient.asyncWritersMu.Lock()
+		for topic, writer := range kafkaClient.AsyncWriters {
+			if err := writer.Close(); err != nil {
+				logger.Error("Failed to close async Kafka writer", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
+				continue
+			}
+
+			logger.Info("Async Kafka writer closed successfully", logrus.Fields{logger.FieldTopic: topic})
+		}
+		kafkaClient.asyncWritersMu.Unlock()
+
 		for topic, writer := range kafkaClient.Writers {
 			if err := writer.Close(); err != nil {
-				fmt.Printf("Failed to close Kafka writer for topic %s: %v\n", topic, err)
+				logger.Error("Failed to close Kafka writer", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
 				continue
 			}
 
-			fmt.Printf("Kafka writer for topic %s closed successfully\n", topic)
+			logger.Info("Kafka writer closed successfully", logrus.Fields{logger.FieldTopic: topic})
 		}
 
 		for topic, reader := range kafkaClient.Readers {
 			if err := reader.Close(); err != nil {
-				fmt.Printf("Failed to close Kafka reader for topic %s: %v\n", topic, err)
+				logger.Error("Failed to close Kafka reader", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
 				continue
 			}
 
-			fmt.Printf("Kafka reader for topic %s closed successfully\n", topic)
+			logger.Info("Kafka reader closed successfully", logrus.Fields{logger.FieldTopic: topic})
 		}
 
-		kafkaClient = nil
-		fmt.Println("Kafka client closed successfully")
-		return
+		logger.Info("Kafka client closed successfully", nil)
 	}
 
 	once = sync.Once{} // Reset the once to allow re-initialization
@@ -128,14 +370,14 @@ func CloseKafka() {
 func loadKafkaEnv() bool {
 	brokers := os.Getenv("KAFKA_BROKERS")
 	if brokers == "" {
-		fmt.Println("KAFKA_BROKERS must be set")
+		logger.Error("KAFKA_BROKERS must be set", nil)
 		return false
 	}
 	kafkaBrokers = strings.Split(brokers, ",")
 
 	topics := os.Getenv("KAFKA_TOPICS")
 	if topics == "" {
-		fmt.Println("KAFKA_TOPICS must be set")
+		logger.Error("KAFKA_TOPICS must be set", nil)
 		return false
 	}
 	kafkaTopics = strings.Split(topics, ",")
@@ -145,13 +387,183 @@ func loadKafkaEnv() bool {
 		kafkaGroupID = defaultKafkaGroupID
 	}
 
+	kafkaDLQTopic = os.Getenv("KAFKA_DLQ_TOPIC")
+	if kafkaDLQTopic == "" {
+		kafkaDLQTopic = defaultKafkaDLQTopic
+	}
+
+	// KAFKA_RETRY_DELAYS can be set to "none" to disable retry topics
+	delays := os.Getenv("KAFKA_RETRY_DELAYS")
+	if delays == "" {
+		delays = defaultKafkaRetryDelays
+	}
+	kafkaRetryDelays = nil
+	if delays != "none" {
+		for _, delay := range strings.Split(delays, ",") {
+			delay = strings.TrimSpace(delay)
+			if _, err := time.ParseDuration(delay); err != nil {
+				logger.Error("Invalid KAFKA_RETRY_DELAYS value", logrus.Fields{"value": delay})
+				return false
+			}
+			kafkaRetryDelays = append(kafkaRetryDelays, delay)
+		}
+	}
+
+	kafkaCommitMode = os.Getenv("KAFKA_COMMIT_MODE")
+	if kafkaCommitMode == "" {
+		kafkaCommitMode = defaultKafkaCommitMode
+	}
+	if !isValidCommitMode(kafkaCommitMode) {
+		logger.Error("Invalid KAFKA_COMMIT_MODE value", logrus.Fields{"value": kafkaCommitMode})
+		return false
+	}
+
+	// KAFKA_TOPIC_COMMIT_MODES overrides the commit mode per topic, e.g., "messaging=manual,messaging.retry.5s=auto"
+	kafkaCommitModes = make(map[string]string)
+	if modes := os.Getenv("KAFKA_TOPIC_COMMIT_MODES"); modes != "" {
+		for _, pair := range strings.Split(modes, ",") {
+			topic, mode, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if !found || topic == "" || !isValidCommitMode(mode) {
+				logger.Error("Invalid KAFKA_TOPIC_COMMIT_MODES value", logrus.Fields{"value": pair})
+				return false
+			}
+			kafkaCommitModes[topic] = mode
+		}
+	}
+
+	batchStr := os.Getenv("KAFKA_COMMIT_BATCH_SIZE")
+	if batchStr == "" {
+		kafkaCommitBatch = defaultKafkaCommitBatch
+	} else {
+		size, err := strconv.Atoi(batchStr)
+		if err != nil || size <= 0 {
+			logger.Error("Invalid KAFKA_COMMIT_BATCH_SIZE value", logrus.Fields{"value": batchStr})
+			return false
+		}
+		kafkaCommitBatch = size
+	}
+
+	intervalStr := os.Getenv("KAFKA_COMMIT_INTERVAL_MS")
+	if intervalStr == "" {
+		kafkaCommitFlush = defaultKafkaCommitInterval
+	} else {
+		ms, err := strconv.Atoi(intervalStr)
+		if err != nil || ms <= 0 {
+			logger.Error("Invalid KAFKA_COMMIT_INTERVAL_MS value", logrus.Fields{"value": intervalStr})
+			return false
+		}
+		kafkaCommitFlush = time.Duration(ms) * time.Millisecond
+	}
+
+	kafkaProducer = os.Getenv("KAFKA_PRODUCER_NAME")
+	if kafkaProducer == "" {
+		kafkaProducer = defaultKafkaProducer
+	}
+
+	kafkaEventFormat = os.Getenv("KAFKA_EVENT_FORMAT")
+	switch kafkaEventFormat {
+	case "":
+		kafkaEventFormat = defaultKafkaEventFormat
+	case EventFormatLegacy, EventFormatBinary, EventFormatStructured:
+	default:
+		logger.Error("Invalid KAFKA_EVENT_FORMAT value", logrus.Fields{"value": kafkaEventFormat})
+		return false
+	}
+
+	kafkaCodec = os.Getenv("KAFKA_CODEC")
+	if kafkaCodec == "" {
+		kafkaCodec = defaultKafkaCodec
+	}
+	if !isValidCodec(kafkaCodec) {
+		logger.Error("Invalid KAFKA_CODEC value", logrus.Fields{"value": kafkaCodec})
+		return false
+	}
+
+	// KAFKA_TOPIC_CODECS overrides the codec per topic, e.g., "messaging=protobuf"
+	kafkaCodecs = make(map[string]string)
+	if codecs := os.Getenv("KAFKA_TOPIC_CODECS"); codecs != "" {
+		for _, pair := range strings.Split(codecs, ",") {
+			topic, codec, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if !found || topic == "" || !isValidCodec(codec) {
+				logger.Error("Invalid KAFKA_TOPIC_CODECS value", logrus.Fields{"value": pair})
+				return false
+			}
+			kafkaCodecs[topic] = codec
+		}
+	}
+
+	kafkaCEPrefix = os.Getenv("KAFKA_CLOUDEVENTS_TYPE_PREFIX")
+	if kafkaCEPrefix == "" {
+		kafkaCEPrefix = defaultKafkaCEPrefix
+	}
+
+	ttlStr := os.Getenv("KAFKA_DEDUP_TTL_MS")
+	if ttlStr == "" {
+		kafkaDedupTTL = defaultKafkaDedupTTL
+	} else {
+		ms, err := strconv.Atoi(ttlStr)
+		if err != nil || ms <= 0 {
+			logger.Error("Invalid KAFKA_DEDUP_TTL_MS value", logrus.Fields{"value": ttlStr})
+			return false
+		}
+		kafkaDedupTTL = time.Duration(ms) * time.Millisecond
+	}
+
+	sizeStr := os.Getenv("KAFKA_DEDUP_CACHE_SIZE")
+	if sizeStr == "" {
+		kafkaDedupSize = defaultKafkaDedupSize
+	} else {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 {
+			logger.Error("Invalid KAFKA_DEDUP_CACHE_SIZE value", logrus.Fields{"value": sizeStr})
+			return false
+		}
+		kafkaDedupSize = size
+	}
+
+	if batchStr := os.Getenv("KAFKA_WRITER_BATCH_SIZE"); batchStr != "" {
+		size, err := strconv.Atoi(batchStr)
+		if err != nil || size <= 0 {
+			logger.Error("Invalid KAFKA_WRITER_BATCH_SIZE value", logrus.Fields{"value": batchStr})
+			return false
+		}
+		kafkaWriterBatchSize = size
+	}
+
+	if bytesStr := os.Getenv("KAFKA_WRITER_BATCH_BYTES"); bytesStr != "" {
+		size, err := strconv.ParseInt(bytesStr, 10, 64)
+		if err != nil || size <= 0 {
+			logger.Error("Invalid KAFKA_WRITER_BATCH_BYTES value", logrus.Fields{"value": bytesStr})
+			return false
+		}
+		kafkaWriterBatchBytes = size
+	}
+
+	if timeoutStr := os.Getenv("KAFKA_WRITER_BATCH_TIMEOUT_MS"); timeoutStr != "" {
+		ms, err := strconv.Atoi(timeoutStr)
+		if err != nil || ms <= 0 {
+			logger.Error("Invalid KAFKA_WRITER_BATCH_TIMEOUT_MS value", logrus.Fields{"value": timeoutStr})
+			return false
+		}
+		kafkaWriterBatchTimeout = time.Duration(ms) * time.Millisecond
+	}
+
+	// Wait for all in-sync replicas by default, like kafka.NewWriter does
+	kafkaWriterRequiredAcks = kafka.RequireAll
+	if acksStr := os.Getenv("KAFKA_WRITER_REQUIRED_ACKS"); acksStr != "" {
+		if err := kafkaWriterRequiredAcks.UnmarshalText([]byte(acksStr)); err != nil {
+			logger.Error("Invalid KAFKA_WRITER_REQUIRED_ACKS value", logrus.Fields{"value": acksStr})
+			return false
+		}
+	}
+
 	timeoutStr := os.Getenv("KAFKA_READ_TIMEOUT_MS")
 	if timeoutStr == "" {
 		kafkaReadTimeout = defaultKafkaReadTimeout
 	} else {
 		ms, err := strconv.Atoi(timeoutStr)
 		if err != nil {
-			fmt.Printf("Invalid KAFKA_READ_TIMEOUT_MS value: %s\n", timeoutStr)
+			logger.Error("Invalid KAFKA_READ_TIMEOUT_MS value", logrus.Fields{"value": timeoutStr})
 			return false
 		}
 		kafkaReadTimeout = time.Duration(ms) * time.Millisecond
@@ -163,7 +575,7 @@ func loadKafkaEnv() bool {
 	} else {
 		ms, err := strconv.Atoi(timeoutStr)
 		if err != nil {
-			fmt.Printf("Invalid KAFKA_WRITE_TIMEOUT_MS value: %s\n", timeoutStr)
+			logger.Error("Invalid KAFKA_WRITE_TIMEOUT_MS value", logrus.Fields{"value": timeoutStr})
 			return false
 		}
 		kafkaWriteTimeout = time.Duration(ms) * time.Millisecond
@@ -173,15 +585,48 @@ func loadKafkaEnv() bool {
 }
 
 func initKafkaWriter(topic string) *kafka.Writer {
-	return kafka.NewWriter(kafka.WriterConfig{
-		Brokers:      kafkaBrokers,
-		Topic:        topic,
-		Balancer:     &kafka.LeastBytes{},
+	return &kafka.Writer{
+		Addr:  kafka.TCP(kafkaBrokers...),
+		Topic: topic,
+		// Messages with the same key go to the same partition, so they keep their order
+		Balancer:     &kafka.Hash{},
 		WriteTimeout: kafkaWriteTimeout,
-	})
+		BatchSize:    kafkaWriterBatchSize,
+		BatchBytes:   kafkaWriterBatchBytes,
+		BatchTimeout: kafkaWriterBatchTimeout,
+		RequiredAcks: kafkaWriterRequiredAcks,
+	}
+}
+
+// isRetryTopic reports whether the topic is a retry tier of one of the configured topics.
+func isRetryTopic(topic string) bool {
+	for _, t := range kafkaTopics {
+		for _, tier := range GetKafkaRetryTiers(t) {
+			if tier.Topic == topic {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isValidCodec(codec string) bool {
+	return codec == CodecJSON || codec == CodecProtobuf || codec == CodecAvro
+}
+
+func isValidCommitMode(mode string) bool {
+	return mode == CommitModeAuto || mode == CommitModeManual
 }
 
 func initKafkaReader(topic string) *kafka.Reader {
+	// In manual mode the consumer batches commits itself,
+	// so CommitMessages must commit synchronously instead of on an interval
+	commitInterval := time.Second // auto-commit offset tiap detik
+	if GetKafkaCommitMode(topic) == CommitModeManual {
+		commitInterval = 0
+	}
+
 	return kafka.NewReader(kafka.ReaderConfig{
 		Brokers:         kafkaBrokers,
 		Topic:           topic,
@@ -189,7 +634,7 @@ func initKafkaReader(topic string) *kafka.Reader {
 		MinBytes:        defaultKafkaReaderMinBytes,
 		MaxBytes:        defaultKafkaReaderMaxBytes,
 		MaxWait:         kafkaReadTimeout,
-		CommitInterval:  time.Second, // auto-commit offset tiap detik
+		CommitInterval:  commitInterval,
 		StartOffset:     kafka.FirstOffset,
 		GroupBalancers:  []kafka.GroupBalancer{kafka.RoundRobinGroupBalancer{}},
 		ReadLagInterval: -1,
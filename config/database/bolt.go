@@ -0,0 +1,80 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+)
+
+var (
+	boltDB *bolt.DB
+	once   sync.Once
+
+	boltPath string
+)
+
+const (
+	defaultBoltPath        = "data/messages.db"
+	defaultBoltOpenTimeout = 5 * time.Second
+)
+
+func InitBolt() bool {
+	isSuccess := true
+	once.Do(func() {
+		loadBoltEnv()
+
+		// Make sure the directory for the database file exists
+		if err := os.MkdirAll(filepath.Dir(boltPath), 0o755); err != nil {
+			logger.Error("Failed to create directory for bolt database", logrus.Fields{"path": boltPath, logger.FieldError: err.Error()})
+			isSuccess = false
+			return
+		}
+
+		db, err := bolt.Open(boltPath, 0o600, &bolt.Options{Timeout: defaultBoltOpenTimeout})
+		if err != nil {
+			logger.Error("Failed to open bolt database", logrus.Fields{"path": boltPath, logger.FieldError: err.Error()})
+			isSuccess = false
+			return
+		}
+
+		boltDB = db
+		logger.Info("Bolt database opened", logrus.Fields{"path": boltPath})
+	})
+
+	return isSuccess
+}
+
+func GetBoltDB() (*bolt.DB, error) {
+	if boltDB == nil {
+		return nil, fmt.Errorf("bolt database is not initialized")
+	}
+
+	return boltDB, nil
+}
+
+func CloseBolt() {
+	if boltDB != nil {
+		if err := boltDB.Close(); err != nil {
+			logger.Error("Failed to close bolt database", logrus.Fields{logger.FieldError: err.Error()})
+		} else {
+			logger.Info("Bolt database closed successfully", nil)
+		}
+	}
+
+	once = sync.Once{} // Reset the once to allow re-initialization
+	boltDB = nil       // Clear the boltDB variable to prevent further use
+}
+
+func loadBoltEnv() {
+	boltPath = os.Getenv("BOLT_DB_PATH")
+	if boltPath == "" {
+		boltPath = defaultBoltPath
+	}
+}
@@ -0,0 +1,27 @@
+package entity
+
+import (
+	"time"
+)
+
+type IdempotencyRecord struct {
+	Key            string    `json:"key"`                     // Idempotency-Key header sent by the client
+	Token          string    `json:"token"`                   // ID of the reservation, only the request holding it completes or releases the key
+	RequestHash    string    `json:"request_hash"`            // Hash of the request the key was first used with
+	StatusCode     int       `json:"status_code"`             // Status code of the stored response, 0 while the request is in progress
+	ResponseBody   []byte    `json:"response_body,omitempty"` // Body of the stored response
+	CreatedAt      time.Time `json:"created_at"`              // When the key was first used
+	ExpiresAt      time.Time `json:"expires_at"`              // When the key can be used again for another request
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`        // When a request still in progress is considered abandoned
+}
+
+// InProgress reports whether the request that first used the key has not completed yet.
+func (r *IdempotencyRecord) InProgress() bool {
+	return r.StatusCode == 0
+}
+
+// Reusable reports whether the key can be reserved again at now, because the record expired,
+// or because the request that reserved it never completed and its lease expired.
+func (r *IdempotencyRecord) Reusable(now time.Time) bool {
+	return !now.Before(r.ExpiresAt) || (r.InProgress() && !now.Before(r.LeaseExpiresAt))
+}
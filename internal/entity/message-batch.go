@@ -0,0 +1,13 @@
+package entity
+
+const (
+	// MessageStatusRejected indicates the Message in a batch failed validation and was not sent
+	MessageStatusRejected = "rejected"
+)
+
+type MessageBatchResult struct {
+	Index  int                 `json:"index"`            // Position of the Message in the batch
+	ID     string              `json:"id,omitempty"`     // UUID of the Message, empty if it was rejected
+	Status string              `json:"status"`           // Status of the Message (e.g., "sent", "failed", "rejected")
+	Errors []map[string]string `json:"errors,omitempty"` // Validation errors of a rejected Message
+}
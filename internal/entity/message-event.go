@@ -2,9 +2,30 @@ package entity
 
 const (
 	EventTypeSendingMessage = "sending-message" // Event type for sending messages
+
+	// Current version of the MessageEvent payload
+	// Version 1: the message without conversation ID and attachments
+	// Version 2: adds conversation_id and attachments
+	MessageEventVersion = 2
 )
 
 type MessageEvent struct {
 	EventType string  `json:"event_type"` // Type of the event, e.g., "sending-message"
+	Version   int     `json:"version"`    // Version of the payload, events without it are version 1
 	Payload   Message `json:"payload"`    // The message payload
 }
+
+// EventMetadata returns the event type and version, published in the message headers.
+func (e MessageEvent) EventMetadata() (string, int) {
+	return e.EventType, e.Version
+}
+
+// CloudEventID returns the ID of the message, used as the id of the CloudEvent.
+func (e MessageEvent) CloudEventID() string {
+	return e.Payload.ID
+}
+
+// CloudEventData returns the message, used as the data of the CloudEvent.
+func (e MessageEvent) CloudEventData() interface{} {
+	return e.Payload
+}
@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/pb"
+)
+
+// ToProto returns the protobuf form of the message, so it can be published with the protobuf codec.
+func (m Message) ToProto() proto.Message {
+	history := make([]*pb.MessageStatusHistory, 0, len(m.StatusHistory))
+	for _, h := range m.StatusHistory {
+		history = append(history, &pb.MessageStatusHistory{Status: h.Status, Timestamp: timestamppb.New(h.Timestamp)})
+	}
+	attachments := make([]*pb.MessageAttachment, 0, len(m.Attachments))
+	for _, a := range m.Attachments {
+		attachments = append(attachments, &pb.MessageAttachment{Name: a.Name, Url: a.URL, ContentType: a.ContentType, Size: a.Size})
+	}
+
+	return &pb.Message{
+		Id:            m.ID,
+		SenderId:      m.SenderID,
+		ReceiverId:    m.ReceiverID,
+		Message:       m.Message,
+		Timestamp:     timestamppb.New(m.Timestamp),
+		Status:        m.Status,
+		StatusHistory: history,
+
+		ConversationId: m.ConversationID,
+		Attachments:    attachments,
+	}
+}
+
+// NewProto returns an empty protobuf message to decode a message into.
+func (m *Message) NewProto() proto.Message {
+	return &pb.Message{}
+}
+
+// FromProto sets the message from its protobuf form.
+func (m *Message) FromProto(pm proto.Message) error {
+	p, ok := pm.(*pb.Message)
+	if !ok {
+		return fmt.Errorf("unexpected protobuf message %T", pm)
+	}
+
+	*m = Message{
+		ID:         p.GetId(),
+		SenderID:   p.GetSenderId(),
+		ReceiverID: p.GetReceiverId(),
+		Message:    p.GetMessage(),
+		Status:     p.GetStatus(),
+
+		ConversationID: p.GetConversationId(),
+	}
+	if p.GetTimestamp() != nil {
+		m.Timestamp = p.GetTimestamp().AsTime()
+	}
+	for _, h := range p.GetStatusHistory() {
+		history := MessageStatusHistory{Status: h.GetStatus()}
+		if h.GetTimestamp() != nil {
+			history.Timestamp = h.GetTimestamp().AsTime()
+		}
+		m.StatusHistory = append(m.StatusHistory, history)
+	}
+	for _, a := range p.GetAttachments() {
+		m.Attachments = append(m.Attachments, MessageAttachment{Name: a.GetName(), URL: a.GetUrl(), ContentType: a.GetContentType(), Size: a.GetSize()})
+	}
+
+	return nil
+}
@@ -2,6 +2,8 @@ package entity
 
 import (
 	"time"
+
+	"github.com/google/uuid"
 )
 
 const (
@@ -15,6 +17,42 @@ const (
 	MessageStatusFailed = "failed"
 )
 
+// statusRanks orders the statuses a message goes through: pending, then sent or failed, then delivered
+var statusRanks = map[string]int{
+	MessageStatusPending:   0,
+	MessageStatusSent:      1,
+	MessageStatusFailed:    1,
+	MessageStatusDelivered: 2,
+}
+
+// CanTransitionStatus reports whether a message can move from one status to the other.
+// Statuses only move forward, so a late update, e.g., the producer's sent after the consumer's delivered,
+// cannot move a message back. Setting the same status again is allowed, and so is any move from an unknown status.
+func CanTransitionStatus(from, to string) bool {
+	if from == to {
+		return true
+	}
+
+	fromRank, ok := statusRanks[from]
+	if !ok {
+		return true
+	}
+
+	return statusRanks[to] > fromRank
+}
+
+type MessageStatusHistory struct {
+	Status    string    `json:"status"`    // Status the message transitioned to
+	Timestamp time.Time `json:"timestamp"` // When the transition happened
+}
+
+type MessageAttachment struct {
+	Name        string `json:"name" validate:"required"`    // File name of the attachment
+	URL         string `json:"url" validate:"required,url"` // Where the attachment can be downloaded from
+	ContentType string `json:"content_type"`                // MIME type of the attachment, e.g., "image/png"
+	Size        int64  `json:"size" validate:"gte=0"`       // Size of the attachment in bytes
+}
+
 type Message struct {
 	ID         string    `json:"id"`                              // UUID, unique identifier for each Message
 	SenderID   string    `json:"sender_id" validate:"required"`   // ID of the sender (could be a user ID or system ID)
@@ -22,4 +60,19 @@ type Message struct {
 	Message    string    `json:"message" validate:"required"`     // Message content
 	Timestamp  time.Time `json:"timestamp"`                       // When it was created/sent
 	Status     string    `json:"status"`                          // Status of the message (e.g., "sent", "failed", "delivered")
+
+	ConversationID string              `json:"conversation_id,omitempty"`                       // ID of the conversation the message belongs to
+	Attachments    []MessageAttachment `json:"attachments,omitempty" validate:"omitempty,dive"` // Files attached to the message
+
+	StatusHistory []MessageStatusHistory `json:"status_history,omitempty"` // Status transitions recorded by the message store
+}
+
+// DefaultConversationID returns the ID of the conversation between the sender and the receiver,
+// for messages sent without one. It is the same in both directions.
+func DefaultConversationID(senderID, receiverID string) string {
+	if receiverID < senderID {
+		senderID, receiverID = receiverID, senderID
+	}
+
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(senderID+":"+receiverID)).String()
 }
@@ -0,0 +1,68 @@
+package entity
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+const (
+	// OutboxStatusPending indicates the outbox entry is waiting to be published
+	OutboxStatusPending = "pending"
+	// OutboxStatusDone indicates the outbox entry has been published
+	OutboxStatusDone = "done"
+	// OutboxStatusDead indicates the outbox entry was given up on, it will not be published
+	OutboxStatusDead = "dead"
+)
+
+type OutboxEntry struct {
+	ID            uint64        `json:"id"`                     // Sequence number, entries are published in this order
+	MessageID     string        `json:"message_id,omitempty"`   // ID of the message the entry was written with, if any
+	Topic         string        `json:"topic"`                  // Kafka topic to publish to
+	Key           string        `json:"key"`                    // Kafka message key
+	Value         []byte        `json:"value"`                  // Serialized event to publish
+	Headers       OutboxHeaders `json:"headers,omitempty"`      // Kafka message headers, in order
+	Status        string        `json:"status"`                 // Status of the entry (e.g., "pending", "done", "dead")
+	Attempts      int           `json:"attempts"`               // Number of failed publish attempts
+	LastError     string        `json:"last_error,omitempty"`   // Error of the last failed attempt
+	NextAttemptAt time.Time     `json:"next_attempt_at"`        // The entry is not published before this time
+	CreatedAt     time.Time     `json:"created_at"`             // When the entry was written
+	PublishedAt   *time.Time    `json:"published_at,omitempty"` // When the entry was published
+}
+
+// OutboxHeader is a Kafka message header of an outbox entry.
+type OutboxHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// OutboxHeaders keeps the headers in order, with repeated keys, as the message is published with them.
+type OutboxHeaders []OutboxHeader
+
+// UnmarshalJSON also reads the headers of entries stored before they were kept in order,
+// which were an object of key and value. Their keys are sorted, so they are published in the same order every time.
+func (h *OutboxHeaders) UnmarshalJSON(data []byte) error {
+	var headers []OutboxHeader
+	if err := json.Unmarshal(data, &headers); err == nil {
+		*h = headers
+		return nil
+	}
+
+	var legacy map[string]string
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(legacy))
+	for key := range legacy {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	*h = make(OutboxHeaders, 0, len(keys))
+	for _, key := range keys {
+		*h = append(*h, OutboxHeader{Key: key, Value: []byte(legacy[key])})
+	}
+
+	return nil
+}
@@ -2,16 +2,25 @@ package handler
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"gopkg.in/go-playground/validator.v9"
 
 	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
 	"github.com/yoanesber/go-kafka-messaging-demo/internal/service"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/logging"
+	httputil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/http-util"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
 	validation "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/validation-util"
 )
 
+const (
+	maxBatchSize = 1000 // Maximum number of messages in a batch
+)
+
 type MessageHandler struct {
 	MessageService service.MessageService
 }
@@ -27,25 +36,142 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 
 	// Bind JSON request to Message struct
 	if err := c.ShouldBindJSON(&message); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request format"})
+		respond(c, http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 		return
 	}
 
 	// Send the message using the MessageService
 	// This will validate the message struct and publish it to Kafka
-	if err := h.MessageService.SendMessage(c.Request.Context(), &message); err != nil {
+	err := h.MessageService.SendMessage(c.Request.Context(), &message)
+	if message.ID != "" {
+		logging.SetMessageIDs(c, message.ID)
+	}
+
+	if err != nil {
 		var ve validator.ValidationErrors
 		if errors.As(err, &ve) {
 			// If validation errors, return 422 Unprocessable Entity
-			c.JSON(http.StatusUnprocessableEntity, gin.H{
+			respond(c, http.StatusUnprocessableEntity, gin.H{
 				"error":   "Validation error",
 				"details": validation.FormatValidationErrors(err),
 			})
 			return
 		}
-		c.JSON(500, gin.H{"error": "Internal server error", "details": err.Error()})
+
+		var pe *kafkautil.PublishError
+		if errors.As(err, &pe) {
+			// If the broker is temporarily unavailable, return 503 Service Unavailable
+			// so the client knows it is safe to retry, otherwise 500 Internal Server Error
+			code, errMsg := http.StatusInternalServerError, "Failed to publish message"
+			if pe.Retryable {
+				code, errMsg = http.StatusServiceUnavailable, "Message broker unavailable"
+			}
+
+			respond(c, code, gin.H{
+				"error":   errMsg,
+				"details": err.Error(),
+				"id":      message.ID,
+				"status":  message.Status,
+			})
+			return
+		}
+
+		respond(c, http.StatusInternalServerError, gin.H{"error": "Internal server error", "details": err.Error()})
+		return
+	}
+
+	// A message that is still pending is published later, by the outbox relay or the asynchronous writer
+	if message.Status == entity.MessageStatusPending {
+		respond(c, http.StatusAccepted, gin.H{"message": "Message accepted for delivery", "id": message.ID, "status": message.Status})
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{"message": "Message sent successfully", "id": message.ID})
+}
+
+func (h *MessageHandler) SendMessages(c *gin.Context) {
+	var messages []entity.Message
+
+	// Bind JSON request to a slice of Message structs
+	if err := c.ShouldBindJSON(&messages); err != nil {
+		respond(c, http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if len(messages) == 0 || len(messages) > maxBatchSize {
+		respond(c, http.StatusBadRequest, gin.H{"error": "Invalid batch size", "details": fmt.Sprintf("A batch must contain between 1 and %d messages", maxBatchSize)})
+		return
+	}
+
+	// Send the messages using the MessageService
+	// This will validate each message and publish the valid ones to Kafka in one write
+	results, err := h.MessageService.SendMessages(c.Request.Context(), messages)
+
+	rejected, pending := 0, 0
+	ids := make([]string, 0, len(results))
+	for _, result := range results {
+		if result.ID != "" {
+			ids = append(ids, result.ID)
+		}
+
+		switch result.Status {
+		case entity.MessageStatusRejected:
+			rejected++
+		case entity.MessageStatusPending:
+			pending++
+		}
+	}
+	logging.SetMessageIDs(c, ids...)
+
+	if err != nil {
+		var pe *kafkautil.PublishError
+		if errors.As(err, &pe) {
+			// If the broker is temporarily unavailable, return 503 Service Unavailable
+			// so the client knows it is safe to retry, otherwise 500 Internal Server Error
+			code, errMsg := http.StatusInternalServerError, "Failed to publish messages"
+			if pe.Retryable {
+				code, errMsg = http.StatusServiceUnavailable, "Message broker unavailable"
+			}
+
+			respond(c, code, gin.H{"error": errMsg, "details": err.Error(), "rejected": rejected, "results": results})
+			return
+		}
+
+		respond(c, http.StatusInternalServerError, gin.H{"error": "Internal server error", "details": err.Error(), "rejected": rejected, "results": results})
+		return
+	}
+
+	// Messages that are still pending are published later, by the outbox relay or the asynchronous writer
+	code, msg := http.StatusOK, "Batch sent"
+	if pending > 0 {
+		code, msg = http.StatusAccepted, "Batch accepted for delivery"
+	}
+
+	respond(c, code, gin.H{"message": msg, "accepted": len(results) - rejected, "rejected": rejected, "results": results})
+}
+
+func (h *MessageHandler) GetMessage(c *gin.Context) {
+	id := c.Param("id")
+
+	// Get the message with its current status and status history
+	message, err := h.MessageService.GetMessage(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			httputil.NotFound(c, "Message not found", "No message found with ID "+id)
+			return
+		}
+		httputil.InternalServerError(c, "Failed to get message", err.Error())
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "Message sent successfully", "id": message.ID})
+	httputil.Success(c, "Message retrieved successfully", message)
+}
+
+// respond writes the body with the ID of the request, like the httputil responses,
+// so a response replayed for an Idempotency-Key carries the ID of the retry.
+func respond(c *gin.Context, code int, body gin.H) {
+	if requestID := httputil.RequestID(c); requestID != "" {
+		body["request_id"] = requestID
+	}
+	c.JSON(code, body)
 }
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/outbox"
+	httputil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/http-util"
+)
+
+type OutboxHandler struct {
+	Relay *outbox.Relay
+}
+
+func NewOutboxHandler(relay *outbox.Relay) *OutboxHandler {
+	return &OutboxHandler{
+		Relay: relay,
+	}
+}
+
+func (h *OutboxHandler) GetStatus(c *gin.Context) {
+	status, err := h.Relay.Status(c.Request.Context())
+	if err != nil {
+		httputil.InternalServerError(c, "Failed to get outbox status", err.Error())
+		return
+	}
+
+	httputil.Success(c, "Outbox status retrieved successfully", status)
+}
+
+func (h *OutboxHandler) Pause(c *gin.Context) {
+	h.Relay.Pause()
+	h.GetStatus(c)
+}
+
+func (h *OutboxHandler) Resume(c *gin.Context) {
+	h.Relay.Resume()
+	h.GetStatus(c)
+}
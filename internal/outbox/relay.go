@@ -0,0 +1,397 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/metrics"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/tracing"
+)
+
+/**
+ * Relay publishes the entries of the transactional outbox to Kafka.
+ * Entries are written together with their message in one transaction by MessageRepository.SaveWithOutbox,
+ * so a crash between saving and publishing no longer leaves the store and Kafka out of sync.
+ * The relay polls the pending entries and publishes them in order. A failed entry is retried with
+ * exponential backoff, and the entries behind it wait, so the order is kept.
+ * Transient errors, like an unavailable broker, are retried until they pass. An entry that keeps failing
+ * with an error Kafka reports as permanent, e.g. a message too large for the topic, is given up on after
+ * OUTBOX_MAX_ATTEMPTS attempts: it is marked dead, its message failed, and the entries behind it go on.
+ * Once an entry is published it is marked done, and the status of its message is set to sent.
+ * Done entries are kept for OUTBOX_DONE_RETENTION_MS to inspect them, then removed by DeleteExpired.
+ */
+
+const (
+	defaultPollInterval  = 500 * time.Millisecond
+	defaultBatchSize     = 100
+	defaultBaseBackoff   = time.Second
+	defaultMaxBackoff    = time.Minute
+	defaultDoneRetention = 24 * time.Hour
+	defaultMaxAttempts   = 5
+	deadEntriesShown     = 10 // Number of dead entries returned by Status
+	writeTimeout         = 10 * time.Second
+)
+
+// Writer writes messages to a Kafka topic, *kafka.Writer implements it.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// WriterProvider returns the writer for the topic.
+type WriterProvider func(topic string) (Writer, error)
+
+type RelayConfig struct {
+	PollInterval  time.Duration // How often the pending entries are polled
+	BatchSize     int           // Maximum number of entries published per poll
+	BaseBackoff   time.Duration // Wait before retrying an entry after its first failure, doubled after each failure
+	MaxBackoff    time.Duration // Maximum wait before retrying an entry
+	DoneRetention time.Duration // How long a published entry is kept before DeleteExpired removes it
+	MaxAttempts   int           // Attempts of an entry failing with an error that is not transient before it is marked dead, 0 never gives up
+}
+
+// RelayStatus is a snapshot of the relay, to inspect it.
+type RelayStatus struct {
+	Paused    bool      `json:"paused"`               // Whether publishing is paused
+	Pending   int       `json:"pending"`              // Number of entries waiting to be published
+	Published uint64    `json:"published"`            // Number of entries published since start
+	Failures  uint64    `json:"failures"`             // Number of failed publish attempts since start
+	LastError string    `json:"last_error,omitempty"` // Error of the last failed attempt
+	LastRunAt time.Time `json:"last_run_at"`          // When the pending entries were last polled
+
+	Stuck       *EntryStatus  `json:"stuck,omitempty"`        // The first pending entry, if it failed, the others wait for it
+	Dead        int           `json:"dead"`                   // Number of entries given up on
+	DeadEntries []EntryStatus `json:"dead_entries,omitempty"` // The last dead entries
+}
+
+// EntryStatus describes an outbox entry in RelayStatus, without its value.
+type EntryStatus struct {
+	ID            uint64    `json:"id"`
+	MessageID     string    `json:"message_id,omitempty"`
+	Topic         string    `json:"topic"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+func newEntryStatus(entry entity.OutboxEntry) EntryStatus {
+	return EntryStatus{
+		ID:            entry.ID,
+		MessageID:     entry.MessageID,
+		Topic:         entry.Topic,
+		Status:        entry.Status,
+		Attempts:      entry.Attempts,
+		LastError:     entry.LastError,
+		NextAttemptAt: entry.NextAttemptAt,
+	}
+}
+
+type Relay struct {
+	OutboxRepository  repository.OutboxRepository
+	MessageRepository repository.MessageRepository
+	GetWriter         WriterProvider
+	Config            RelayConfig
+
+	mu        sync.Mutex
+	paused    bool
+	published uint64
+	failures  uint64
+	lastError string
+	lastRunAt time.Time
+}
+
+func NewRelay(outboxRepository repository.OutboxRepository, messageRepository repository.MessageRepository, getWriter WriterProvider, config RelayConfig) *Relay {
+	return &Relay{
+		OutboxRepository:  outboxRepository,
+		MessageRepository: messageRepository,
+		GetWriter:         getWriter,
+		Config:            config,
+	}
+}
+
+// LoadRelayConfig reads the relay configuration from the environment variables, using defaults for the unset ones.
+func LoadRelayConfig() RelayConfig {
+	return RelayConfig{
+		PollInterval:  durationEnv("OUTBOX_POLL_INTERVAL_MS", defaultPollInterval),
+		BatchSize:     intEnv("OUTBOX_BATCH_SIZE", defaultBatchSize),
+		BaseBackoff:   durationEnv("OUTBOX_BASE_BACKOFF_MS", defaultBaseBackoff),
+		MaxBackoff:    durationEnv("OUTBOX_MAX_BACKOFF_MS", defaultMaxBackoff),
+		DoneRetention: durationEnv("OUTBOX_DONE_RETENTION_MS", defaultDoneRetention),
+		MaxAttempts:   intEnv("OUTBOX_MAX_ATTEMPTS", defaultMaxAttempts),
+	}
+}
+
+// DeleteExpired removes the published entries older than the retention and returns how many were removed.
+func (r *Relay) DeleteExpired(ctx context.Context) (int, error) {
+	return r.OutboxRepository.DeleteDone(ctx, time.Now().Add(-r.Config.DoneRetention))
+}
+
+// Run publishes the pending entries every poll interval until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Outbox relay stopped", nil)
+			return
+		case <-ticker.C:
+			if _, err := r.PublishPending(ctx); err != nil {
+				logger.Error("Failed to publish outbox entries", logrus.Fields{logger.FieldError: err.Error()})
+			}
+		}
+	}
+}
+
+// PublishPending publishes the pending entries that are due, in order, and returns how many were published.
+// It stops at the first entry that is not due yet or fails, so no entry overtakes an earlier one.
+func (r *Relay) PublishPending(ctx context.Context) (int, error) {
+	if r.IsPaused() {
+		return 0, nil
+	}
+
+	r.mu.Lock()
+	r.lastRunAt = time.Now()
+	r.mu.Unlock()
+
+	entries, err := r.OutboxRepository.FindPending(ctx, r.Config.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find pending outbox entries: %w", err)
+	}
+
+	published := 0
+	for _, entry := range entries {
+		if r.IsPaused() || time.Now().Before(entry.NextAttemptAt) {
+			break
+		}
+
+		if err := r.publish(ctx, entry); err != nil {
+			return published, r.fail(ctx, entry, err)
+		}
+
+		if err := r.OutboxRepository.MarkDone(ctx, entry.ID); err != nil {
+			return published, fmt.Errorf("failed to mark outbox entry %d as done: %w", entry.ID, err)
+		}
+
+		r.mu.Lock()
+		r.published++
+		r.mu.Unlock()
+		published++
+
+		// The message is in Kafka now, a failed status update does not undo that
+		if entry.MessageID != "" {
+			if err := r.MessageRepository.UpdateStatus(ctx, entry.MessageID, entity.MessageStatusSent); err != nil {
+				logger.ErrorContext(ctx, "Failed to update status of message", logrus.Fields{logger.FieldMessageID: entry.MessageID, logger.FieldError: err.Error()})
+			}
+		}
+	}
+
+	return published, nil
+}
+
+// Pause stops publishing until Resume is called. Entries keep being written to the outbox meanwhile.
+func (r *Relay) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = true
+}
+
+// Resume continues publishing after Pause.
+func (r *Relay) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = false
+}
+
+func (r *Relay) IsPaused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.paused
+}
+
+// Status returns a snapshot of the relay, the number of pending entries, the entry they wait for if it failed,
+// and the dead entries.
+func (r *Relay) Status(ctx context.Context) (RelayStatus, error) {
+	pending, err := r.OutboxRepository.CountPending(ctx)
+	if err != nil {
+		return RelayStatus{}, fmt.Errorf("failed to count pending outbox entries: %w", err)
+	}
+
+	first, err := r.OutboxRepository.FindPending(ctx, 1)
+	if err != nil {
+		return RelayStatus{}, fmt.Errorf("failed to find pending outbox entries: %w", err)
+	}
+
+	dead, err := r.OutboxRepository.CountDead(ctx)
+	if err != nil {
+		return RelayStatus{}, fmt.Errorf("failed to count dead outbox entries: %w", err)
+	}
+
+	deadEntries, err := r.OutboxRepository.FindDead(ctx, deadEntriesShown)
+	if err != nil {
+		return RelayStatus{}, fmt.Errorf("failed to find dead outbox entries: %w", err)
+	}
+
+	r.mu.Lock()
+	status := RelayStatus{
+		Paused:    r.paused,
+		Pending:   pending,
+		Published: r.published,
+		Failures:  r.failures,
+		LastError: r.lastError,
+		LastRunAt: r.lastRunAt,
+		Dead:      dead,
+	}
+	r.mu.Unlock()
+
+	if len(first) > 0 && first[0].Attempts > 0 {
+		stuck := newEntryStatus(first[0])
+		status.Stuck = &stuck
+	}
+	for _, entry := range deadEntries {
+		status.DeadEntries = append(status.DeadEntries, newEntryStatus(entry))
+	}
+
+	return status, nil
+}
+
+func (r *Relay) publish(ctx context.Context, entry entity.OutboxEntry) (err error) {
+	writer, err := r.GetWriter(entry.Topic)
+	if err != nil {
+		return err
+	}
+
+	headers := make([]kafka.Header, 0, len(entry.Headers))
+	for _, h := range entry.Headers {
+		headers = append(headers, kafka.Header{Key: h.Key, Value: h.Value})
+	}
+
+	// Continue the trace of the request that saved the entry, and pass the publish span on to the consumer
+	ctx, span := tracing.StartPublish(tracing.ExtractHeaders(ctx, headers), entry.Topic, 1)
+	defer func() { tracing.End(span, err) }()
+	headers = tracing.InjectHeaders(ctx, headers)
+
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	// An entry that failed before is retried with backoff, see fail
+	metrics.PublishAttempt(entry.Topic, entry.Attempts > 0)
+	err = writer.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(entry.Key),
+		Value:   entry.Value,
+		Headers: headers,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		metrics.PublishFailed(entry.Topic)
+	}
+
+	return err
+}
+
+// fail records the failed attempt and schedules the next one with exponential backoff.
+// An entry that failed MaxAttempts times with an error that is not transient is marked dead instead,
+// so it no longer holds back the entries behind it.
+func (r *Relay) fail(ctx context.Context, entry entity.OutboxEntry, publishErr error) error {
+	r.mu.Lock()
+	r.failures++
+	r.lastError = publishErr.Error()
+	r.mu.Unlock()
+
+	if r.Config.MaxAttempts > 0 && entry.Attempts+1 >= r.Config.MaxAttempts && isPermanent(publishErr) {
+		return r.markDead(ctx, entry, publishErr)
+	}
+
+	nextAttemptAt := time.Now().Add(r.backoff(entry.Attempts))
+	if err := r.OutboxRepository.MarkFailed(ctx, entry.ID, publishErr.Error(), nextAttemptAt); err != nil {
+		return fmt.Errorf("failed to mark outbox entry %d as failed: %w", entry.ID, err)
+	}
+
+	return fmt.Errorf("failed to publish outbox entry %d to topic %s: %w", entry.ID, entry.Topic, publishErr)
+}
+
+// isPermanent reports whether Kafka rejected the entry with an error that a new attempt cannot fix.
+// Errors that do not tell, e.g. a broker that cannot be reached, are not permanent.
+func isPermanent(err error) bool {
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, writeErr := range writeErrs {
+			if writeErr != nil && !isPermanent(writeErr) {
+				return false
+			}
+		}
+		return writeErrs.Count() > 0
+	}
+
+	var kafkaErr kafka.Error
+	return errors.As(err, &kafkaErr) && !kafkaErr.Temporary()
+}
+
+// markDead gives up on the entry and marks its message failed.
+func (r *Relay) markDead(ctx context.Context, entry entity.OutboxEntry, publishErr error) error {
+	if err := r.OutboxRepository.MarkDead(ctx, entry.ID, publishErr.Error()); err != nil {
+		return fmt.Errorf("failed to mark outbox entry %d as dead: %w", entry.ID, err)
+	}
+
+	logger.ErrorContext(ctx, "Outbox entry given up on", logrus.Fields{
+		"outbox_entry_id":     entry.ID,
+		logger.FieldMessageID: entry.MessageID,
+		logger.FieldTopic:     entry.Topic,
+		"attempts":            entry.Attempts + 1,
+		logger.FieldError:     publishErr.Error(),
+	})
+
+	if entry.MessageID != "" {
+		if err := r.MessageRepository.UpdateStatus(ctx, entry.MessageID, entity.MessageStatusFailed); err != nil {
+			logger.ErrorContext(ctx, "Failed to update status of message", logrus.Fields{logger.FieldMessageID: entry.MessageID, logger.FieldError: err.Error()})
+		}
+	}
+
+	return fmt.Errorf("gave up on outbox entry %d to topic %s: %w", entry.ID, entry.Topic, publishErr)
+}
+
+// backoff returns the wait before the next attempt, given the number of failed attempts so far.
+func (r *Relay) backoff(attempts int) time.Duration {
+	backoff := r.Config.BaseBackoff
+	for range attempts {
+		backoff *= 2
+		if backoff >= r.Config.MaxBackoff {
+			return r.Config.MaxBackoff
+		}
+	}
+
+	return min(backoff, r.Config.MaxBackoff)
+}
+
+func durationEnv(key string, defaultValue time.Duration) time.Duration {
+	ms, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || ms <= 0 {
+		return defaultValue
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func intEnv(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+
+	return value
+}
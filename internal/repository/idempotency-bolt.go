@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+)
+
+/**
+ * boltIdempotencyRepository persists idempotency records in the "idempotency" bucket, keyed by the idempotency key.
+ * Reserve runs in a single write transaction, which bbolt serializes,
+ * so two concurrent requests with the same key cannot both reserve it.
+ * Complete and Delete check the reservation token in the same transaction as they write.
+ */
+
+var (
+	idempotencyBucket = []byte("idempotency")
+)
+
+type boltIdempotencyRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltIdempotencyRepository(db *bolt.DB) (IdempotencyRepository, error) {
+	// Make sure the bucket exists before the repository is used
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(idempotencyBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create idempotency bucket: %w", err)
+	}
+
+	return &boltIdempotencyRepository{db: db}, nil
+}
+
+func (r *boltIdempotencyRepository) Reserve(ctx context.Context, record *entity.IdempotencyRecord) (*entity.IdempotencyRecord, error) {
+	var existing *entity.IdempotencyRecord
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+
+		stored, err := getIdempotencyRecord(bucket, record.Key)
+		if err == nil && !stored.Reusable(time.Now()) {
+			existing = stored
+			return nil
+		}
+
+		return putIdempotencyRecord(bucket, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+func (r *boltIdempotencyRepository) Complete(ctx context.Context, key string, token string, statusCode int, responseBody []byte) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+
+		record, err := getIdempotencyRecord(bucket, key)
+		if err != nil {
+			return err
+		}
+		if record.Token != token {
+			return ErrIdempotencyKeyNotHeld
+		}
+
+		record.StatusCode = statusCode
+		record.ResponseBody = responseBody
+		return putIdempotencyRecord(bucket, record)
+	})
+}
+
+func (r *boltIdempotencyRepository) Delete(ctx context.Context, key string, token string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+
+		// A record that cannot be read is released too, it could never be completed
+		record, err := getIdempotencyRecord(bucket, key)
+		if errors.Is(err, ErrIdempotencyKeyNotFound) || (err == nil && record.Token != token) {
+			return nil
+		}
+
+		return bucket.Delete([]byte(key))
+	})
+}
+
+func (r *boltIdempotencyRepository) DeleteExpired(ctx context.Context) (int, error) {
+	deleted := 0
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+
+		// Collect the keys first, deleting while iterating with ForEach is not allowed
+		var expired [][]byte
+		now := time.Now()
+		err := bucket.ForEach(func(k, v []byte) error {
+			var record entity.IdempotencyRecord
+			if err := json.Unmarshal(v, &record); err != nil || !now.Before(record.ExpiresAt) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+func getIdempotencyRecord(bucket *bolt.Bucket, key string) (*entity.IdempotencyRecord, error) {
+	data := bucket.Get([]byte(key))
+	if data == nil {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+
+	var record entity.IdempotencyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal idempotency record %s: %w", key, err)
+	}
+
+	return &record, nil
+}
+
+func putIdempotencyRecord(bucket *bolt.Bucket, record *entity.IdempotencyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency record %s: %w", record.Key, err)
+	}
+
+	return bucket.Put([]byte(record.Key), data)
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+)
+
+/**
+ * memoryIdempotencyRepository keeps idempotency records in a map guarded by a Mutex.
+ * Reserve checks and stores under the same lock, so two concurrent requests with the same key
+ * cannot both reserve it. Complete and Delete check the reservation token under the lock too.
+ */
+
+type memoryIdempotencyRepository struct {
+	mu      sync.Mutex
+	records map[string]entity.IdempotencyRecord
+}
+
+func NewMemoryIdempotencyRepository() IdempotencyRepository {
+	return &memoryIdempotencyRepository{
+		records: make(map[string]entity.IdempotencyRecord),
+	}
+}
+
+func (r *memoryIdempotencyRepository) Reserve(ctx context.Context, record *entity.IdempotencyRecord) (*entity.IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, exists := r.records[record.Key]; exists && !existing.Reusable(time.Now()) {
+		return &existing, nil
+	}
+
+	r.records[record.Key] = *record
+	return nil, nil
+}
+
+func (r *memoryIdempotencyRepository) Complete(ctx context.Context, key string, token string, statusCode int, responseBody []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, exists := r.records[key]
+	if !exists {
+		return ErrIdempotencyKeyNotFound
+	}
+	if record.Token != token {
+		return ErrIdempotencyKeyNotHeld
+	}
+
+	record.StatusCode = statusCode
+	record.ResponseBody = append([]byte(nil), responseBody...)
+	r.records[key] = record
+	return nil
+}
+
+func (r *memoryIdempotencyRepository) Delete(ctx context.Context, key string, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if record, exists := r.records[key]; exists && record.Token == token {
+		delete(r.records, key)
+	}
+	return nil
+}
+
+func (r *memoryIdempotencyRepository) DeleteExpired(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now, deleted := time.Now(), 0
+	for key, record := range r.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(r.records, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+)
+
+var (
+	// ErrIdempotencyKeyNotFound is returned when there is no record for the idempotency key
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+	// ErrIdempotencyKeyNotHeld is returned when the key was reserved again by another request, after the lease expired
+	ErrIdempotencyKeyNotHeld = errors.New("idempotency key reserved by another request")
+)
+
+type IdempotencyRepository interface {
+	// Reserve stores the record if its key is unused or reusable, see entity.IdempotencyRecord.Reusable, and returns nil.
+	// Otherwise it stores nothing and returns the existing record.
+	Reserve(ctx context.Context, record *entity.IdempotencyRecord) (*entity.IdempotencyRecord, error)
+	// Complete stores the response of the request that reserved the key with the token.
+	// If the key is now reserved with another token, it stores nothing and returns ErrIdempotencyKeyNotHeld.
+	Complete(ctx context.Context, key string, token string, statusCode int, responseBody []byte) error
+	// Delete releases the key reserved with the token, so the request can be retried with it.
+	// If the key is now reserved with another token, it is left as is.
+	Delete(ctx context.Context, key string, token string) error
+	// DeleteExpired removes the expired records and returns how many were removed.
+	DeleteExpired(ctx context.Context) (int, error)
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+)
+
+/**
+ * boltMessageRepository persists messages in an embedded bbolt database file.
+ * Each message is stored as JSON in the "messages" bucket, keyed by its ID.
+ * bbolt serializes write transactions, so status updates are read-modify-write safe.
+ * It also implements OutboxRepository, with outbox entries stored in the "outbox" bucket of the same database,
+ * so a message and its outbox entry are written in one transaction.
+ */
+
+var (
+	messagesBucket   = []byte("messages")
+	outboxBucket     = []byte("outbox")
+	outboxDoneBucket = []byte("outbox-done")
+	outboxDeadBucket = []byte("outbox-dead")
+)
+
+type boltMessageRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltMessageRepository(db *bolt.DB) (MessageRepository, error) {
+	// Make sure the buckets exist before the repository is used
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{messagesBucket, outboxBucket, outboxDoneBucket, outboxDeadBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+
+	return &boltMessageRepository{db: db}, nil
+}
+
+func (r *boltMessageRepository) Save(ctx context.Context, message *entity.Message) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return saveMessage(tx.Bucket(messagesBucket), message)
+	})
+}
+
+func (r *boltMessageRepository) SaveWithOutbox(ctx context.Context, message *entity.Message, entry *entity.OutboxEntry) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if err := saveMessage(tx.Bucket(messagesBucket), message); err != nil {
+			return err
+		}
+
+		bucket := tx.Bucket(outboxBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return fmt.Errorf("failed to get next outbox sequence: %w", err)
+		}
+
+		entry.ID = id
+		return putOutboxEntry(bucket, entry)
+	})
+}
+
+func (r *boltMessageRepository) UpdateStatus(ctx context.Context, id string, status string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(messagesBucket)
+
+		message, err := getMessage(bucket, id)
+		if err != nil {
+			return err
+		}
+
+		// A late update must not move the message back
+		if !entity.CanTransitionStatus(message.Status, status) {
+			return nil
+		}
+
+		message.Status = status
+		appendStatusHistory(message, status)
+
+		return putMessage(bucket, message)
+	})
+}
+
+func (r *boltMessageRepository) FindByID(ctx context.Context, id string) (*entity.Message, error) {
+	var message *entity.Message
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		message, err = getMessage(tx.Bucket(messagesBucket), id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// saveMessage stores the message, keeping the history of an already stored message.
+func saveMessage(bucket *bolt.Bucket, message *entity.Message) error {
+	stored := *message
+	existing, err := getMessage(bucket, message.ID)
+	if err != nil && !errors.Is(err, ErrMessageNotFound) {
+		return err
+	}
+	if existing != nil {
+		stored.StatusHistory = existing.StatusHistory
+	}
+	appendStatusHistory(&stored, stored.Status)
+
+	return putMessage(bucket, &stored)
+}
+
+func getMessage(bucket *bolt.Bucket, id string) (*entity.Message, error) {
+	data := bucket.Get([]byte(id))
+	if data == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	var message entity.Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message %s: %w", id, err)
+	}
+
+	return &message, nil
+}
+
+func putMessage(bucket *bolt.Bucket, message *entity.Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message %s: %w", message.ID, err)
+	}
+
+	return bucket.Put([]byte(message.ID), data)
+}
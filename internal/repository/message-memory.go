@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+)
+
+/**
+ * memoryMessageRepository keeps messages in a map guarded by a RWMutex.
+ * It is useful for local development and tests, but nothing survives a restart.
+ * Messages are copied on the way in and on the way out so callers cannot mutate the stored state.
+ * It also implements OutboxRepository, the same lock makes a message and its outbox entry one atomic write.
+ */
+
+type memoryMessageRepository struct {
+	mu       sync.RWMutex
+	messages map[string]entity.Message
+
+	outbox     map[uint64]entity.OutboxEntry // Pending outbox entries
+	outboxDone map[uint64]entity.OutboxEntry // Published outbox entries
+	outboxDead map[uint64]entity.OutboxEntry // Outbox entries the relay gave up on
+	outboxSeq  uint64
+}
+
+func NewMemoryMessageRepository() MessageRepository {
+	return &memoryMessageRepository{
+		messages:   make(map[string]entity.Message),
+		outbox:     make(map[uint64]entity.OutboxEntry),
+		outboxDone: make(map[uint64]entity.OutboxEntry),
+		outboxDead: make(map[uint64]entity.OutboxEntry),
+	}
+}
+
+func (r *memoryMessageRepository) Save(ctx context.Context, message *entity.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.saveMessage(message)
+	return nil
+}
+
+func (r *memoryMessageRepository) SaveWithOutbox(ctx context.Context, message *entity.Message, entry *entity.OutboxEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.saveMessage(message)
+
+	r.outboxSeq++
+	entry.ID = r.outboxSeq
+	r.outbox[entry.ID] = *entry
+	return nil
+}
+
+func (r *memoryMessageRepository) saveMessage(message *entity.Message) {
+	stored := *message
+	if existing, exists := r.messages[message.ID]; exists {
+		stored.StatusHistory = existing.StatusHistory
+	}
+	stored.StatusHistory = cloneStatusHistory(stored.StatusHistory)
+	appendStatusHistory(&stored, stored.Status)
+
+	r.messages[message.ID] = stored
+}
+
+func (r *memoryMessageRepository) UpdateStatus(ctx context.Context, id string, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, exists := r.messages[id]
+	if !exists {
+		return ErrMessageNotFound
+	}
+
+	// A late update must not move the message back
+	if !entity.CanTransitionStatus(message.Status, status) {
+		return nil
+	}
+
+	message.Status = status
+	message.StatusHistory = cloneStatusHistory(message.StatusHistory)
+	appendStatusHistory(&message, status)
+
+	r.messages[id] = message
+	return nil
+}
+
+func (r *memoryMessageRepository) FindByID(ctx context.Context, id string) (*entity.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	message, exists := r.messages[id]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+
+	message.StatusHistory = cloneStatusHistory(message.StatusHistory)
+	return &message, nil
+}
+
+func cloneStatusHistory(history []entity.MessageStatusHistory) []entity.MessageStatusHistory {
+	if history == nil {
+		return nil
+	}
+
+	return append([]entity.MessageStatusHistory(nil), history...)
+}
+
+func (r *memoryMessageRepository) FindPending(ctx context.Context, limit int) ([]entity.OutboxEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Entry IDs are a sequence, so sorting by ID keeps the entries in the order they were written
+	ids := make([]uint64, 0, len(r.outbox))
+	for id := range r.outbox {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	entries := make([]entity.OutboxEntry, 0, min(limit, len(ids)))
+	for _, id := range ids[:min(limit, len(ids))] {
+		entries = append(entries, r.outbox[id])
+	}
+
+	return entries, nil
+}
+
+func (r *memoryMessageRepository) CountPending(ctx context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.outbox), nil
+}
+
+func (r *memoryMessageRepository) MarkDone(ctx context.Context, id uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, exists := r.outbox[id]
+	if !exists {
+		return ErrOutboxEntryNotFound
+	}
+
+	now := time.Now()
+	entry.Status = entity.OutboxStatusDone
+	entry.PublishedAt = &now
+
+	delete(r.outbox, id)
+	r.outboxDone[id] = entry
+	return nil
+}
+
+func (r *memoryMessageRepository) MarkDead(ctx context.Context, id uint64, lastErr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, exists := r.outbox[id]
+	if !exists {
+		return ErrOutboxEntryNotFound
+	}
+
+	entry.Status = entity.OutboxStatusDead
+	entry.Attempts++
+	entry.LastError = lastErr
+
+	delete(r.outbox, id)
+	r.outboxDead[id] = entry
+	return nil
+}
+
+func (r *memoryMessageRepository) FindDead(ctx context.Context, limit int) ([]entity.OutboxEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// The most recent entries have the highest IDs
+	ids := make([]uint64, 0, len(r.outboxDead))
+	for id := range r.outboxDead {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	slices.Reverse(ids)
+
+	entries := make([]entity.OutboxEntry, 0, min(limit, len(ids)))
+	for _, id := range ids[:min(limit, len(ids))] {
+		entries = append(entries, r.outboxDead[id])
+	}
+
+	return entries, nil
+}
+
+func (r *memoryMessageRepository) CountDead(ctx context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.outboxDead), nil
+}
+
+func (r *memoryMessageRepository) DeleteDone(ctx context.Context, publishedBefore time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for id, entry := range r.outboxDone {
+		if entry.PublishedAt == nil || entry.PublishedAt.Before(publishedBefore) {
+			delete(r.outboxDone, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (r *memoryMessageRepository) MarkFailed(ctx context.Context, id uint64, lastErr string, nextAttemptAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, exists := r.outbox[id]
+	if !exists {
+		return ErrOutboxEntryNotFound
+	}
+
+	entry.Attempts++
+	entry.LastError = lastErr
+	entry.NextAttemptAt = nextAttemptAt
+	r.outbox[id] = entry
+	return nil
+}
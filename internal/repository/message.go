@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+)
+
+var (
+	// ErrMessageNotFound is returned when a message with the given ID does not exist in the store
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+type MessageRepository interface {
+	Save(ctx context.Context, message *entity.Message) error
+	SaveWithOutbox(ctx context.Context, message *entity.Message, entry *entity.OutboxEntry) error
+	// UpdateStatus moves the message to the status. An update that would move it back,
+	// e.g., sent after delivered, is ignored, see entity.CanTransitionStatus.
+	UpdateStatus(ctx context.Context, id string, status string) error
+	FindByID(ctx context.Context, id string) (*entity.Message, error)
+}
+
+// appendStatusHistory records a status transition on the stored message.
+// Repeated saves with the same status do not add a new entry.
+func appendStatusHistory(message *entity.Message, status string) {
+	history := message.StatusHistory
+	if len(history) > 0 && history[len(history)-1].Status == status {
+		return
+	}
+
+	message.StatusHistory = append(history, entity.MessageStatusHistory{
+		Status:    status,
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+)
+
+/**
+ * Outbox entries are keyed by their big-endian sequence number,
+ * so iterating the "outbox" bucket with a cursor returns them in the order they were written.
+ * Published entries are moved to the "outbox-done" bucket, which keeps the pending bucket small,
+ * and are removed from it by DeleteDone once they are older than the retention.
+ * Entries the relay gave up on are moved to the "outbox-dead" bucket, and kept there to be inspected.
+ */
+
+func (r *boltMessageRepository) FindPending(ctx context.Context, limit int) ([]entity.OutboxEntry, error) {
+	entries := make([]entity.OutboxEntry, 0, limit)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(outboxBucket).Cursor()
+		for k, v := cursor.First(); k != nil && len(entries) < limit; k, v = cursor.Next() {
+			var entry entity.OutboxEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("failed to unmarshal outbox entry %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (r *boltMessageRepository) CountPending(ctx context.Context) (int, error) {
+	count := 0
+	err := r.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(outboxBucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *boltMessageRepository) MarkDone(ctx context.Context, id uint64) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+
+		entry, err := getOutboxEntry(bucket, id)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		entry.Status = entity.OutboxStatusDone
+		entry.PublishedAt = &now
+
+		if err := bucket.Delete(outboxKey(id)); err != nil {
+			return err
+		}
+		return putOutboxEntry(tx.Bucket(outboxDoneBucket), entry)
+	})
+}
+
+func (r *boltMessageRepository) MarkFailed(ctx context.Context, id uint64, lastErr string, nextAttemptAt time.Time) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+
+		entry, err := getOutboxEntry(bucket, id)
+		if err != nil {
+			return err
+		}
+
+		entry.Attempts++
+		entry.LastError = lastErr
+		entry.NextAttemptAt = nextAttemptAt
+		return putOutboxEntry(bucket, entry)
+	})
+}
+
+func (r *boltMessageRepository) MarkDead(ctx context.Context, id uint64, lastErr string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+
+		entry, err := getOutboxEntry(bucket, id)
+		if err != nil {
+			return err
+		}
+
+		entry.Status = entity.OutboxStatusDead
+		entry.Attempts++
+		entry.LastError = lastErr
+
+		if err := bucket.Delete(outboxKey(id)); err != nil {
+			return err
+		}
+		return putOutboxEntry(tx.Bucket(outboxDeadBucket), entry)
+	})
+}
+
+func (r *boltMessageRepository) FindDead(ctx context.Context, limit int) ([]entity.OutboxEntry, error) {
+	entries := make([]entity.OutboxEntry, 0, limit)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(outboxDeadBucket).Cursor()
+		for k, v := cursor.Last(); k != nil && len(entries) < limit; k, v = cursor.Prev() {
+			var entry entity.OutboxEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("failed to unmarshal outbox entry %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (r *boltMessageRepository) CountDead(ctx context.Context) (int, error) {
+	count := 0
+	err := r.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(outboxDeadBucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *boltMessageRepository) DeleteDone(ctx context.Context, publishedBefore time.Time) (int, error) {
+	deleted := 0
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxDoneBucket)
+
+		// Collect the keys first, deleting while iterating with ForEach is not allowed
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var entry entity.OutboxEntry
+			if err := json.Unmarshal(v, &entry); err != nil || entry.PublishedAt == nil || entry.PublishedAt.Before(publishedBefore) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+func outboxKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func getOutboxEntry(bucket *bolt.Bucket, id uint64) (*entity.OutboxEntry, error) {
+	data := bucket.Get(outboxKey(id))
+	if data == nil {
+		return nil, ErrOutboxEntryNotFound
+	}
+
+	var entry entity.OutboxEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal outbox entry %d: %w", id, err)
+	}
+
+	return &entry, nil
+}
+
+func putOutboxEntry(bucket *bolt.Bucket, entry *entity.OutboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox entry %d: %w", entry.ID, err)
+	}
+
+	return bucket.Put(outboxKey(entry.ID), data)
+}
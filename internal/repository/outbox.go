@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+)
+
+var (
+	// ErrOutboxEntryNotFound is returned when an outbox entry with the given ID does not exist in the store
+	ErrOutboxEntryNotFound = errors.New("outbox entry not found")
+	// ErrOutboxNotSupported is returned when the message repository cannot store outbox entries
+	ErrOutboxNotSupported = errors.New("message repository does not support the outbox")
+)
+
+// OutboxRepository gives the outbox relay access to the entries written by MessageRepository.SaveWithOutbox.
+type OutboxRepository interface {
+	FindPending(ctx context.Context, limit int) ([]entity.OutboxEntry, error)
+	CountPending(ctx context.Context) (int, error)
+	MarkDone(ctx context.Context, id uint64) error
+	MarkFailed(ctx context.Context, id uint64, lastErr string, nextAttemptAt time.Time) error
+	// MarkDead moves the pending entry out of the way of the entries behind it, it is not published any more.
+	MarkDead(ctx context.Context, id uint64, lastErr string) error
+	// FindDead returns up to limit dead entries, the last written first.
+	FindDead(ctx context.Context, limit int) ([]entity.OutboxEntry, error)
+	CountDead(ctx context.Context) (int, error)
+	// DeleteDone removes the entries published before publishedBefore and returns how many were removed.
+	DeleteDone(ctx context.Context, publishedBefore time.Time) (int, error)
+}
+
+// NewOutboxRepository returns the outbox of the message repository.
+// The outbox has to live in the same store as the messages, so both can be written in one transaction.
+func NewOutboxRepository(messageRepository MessageRepository) (OutboxRepository, error) {
+	outboxRepository, ok := messageRepository.(OutboxRepository)
+	if !ok {
+		return nil, ErrOutboxNotSupported
+	}
+
+	return outboxRepository, nil
+}
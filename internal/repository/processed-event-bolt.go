@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/**
+ * boltProcessedEventRepository persists processed events in the "processed-events" bucket,
+ * keyed by the event ID with the expiry as value, so duplicates are still dropped after a restart.
+ */
+
+var (
+	processedEventsBucket = []byte("processed-events")
+)
+
+type boltProcessedEventRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltProcessedEventRepository(db *bolt.DB) (ProcessedEventRepository, error) {
+	// Make sure the bucket exists before the repository is used
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(processedEventsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create processed events bucket: %w", err)
+	}
+
+	return &boltProcessedEventRepository{db: db}, nil
+}
+
+func (r *boltProcessedEventRepository) IsProcessed(ctx context.Context, eventID string) (bool, error) {
+	processed := false
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(processedEventsBucket).Get([]byte(eventID))
+		if data == nil {
+			return nil
+		}
+
+		var expiresAt time.Time
+		if err := expiresAt.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("failed to unmarshal expiry of event %s: %w", eventID, err)
+		}
+
+		processed = time.Now().Before(expiresAt)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return processed, nil
+}
+
+func (r *boltProcessedEventRepository) MarkProcessed(ctx context.Context, eventID string, expiresAt time.Time) error {
+	data, err := expiresAt.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to marshal expiry of event %s: %w", eventID, err)
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(processedEventsBucket).Put([]byte(eventID), data)
+	})
+}
+
+func (r *boltProcessedEventRepository) DeleteExpired(ctx context.Context) (int, error) {
+	deleted := 0
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(processedEventsBucket)
+
+		// Collect the keys first, deleting while iterating with ForEach is not allowed
+		var expired [][]byte
+		now := time.Now()
+		err := bucket.ForEach(func(k, v []byte) error {
+			var expiresAt time.Time
+			if err := expiresAt.UnmarshalBinary(v); err != nil || !now.Before(expiresAt) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
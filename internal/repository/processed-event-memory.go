@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+/**
+ * memoryProcessedEventRepository remembers processed events in a bounded LRU.
+ * Once it holds capacity events, the least recently seen one is forgotten to make room,
+ * so a very late duplicate of it would be processed again.
+ */
+
+type processedEvent struct {
+	id        string
+	expiresAt time.Time
+}
+
+type memoryProcessedEventRepository struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List               // Most recently seen event at the front
+	events   map[string]*list.Element // Event ID to its element in order
+}
+
+func NewMemoryProcessedEventRepository(capacity int) ProcessedEventRepository {
+	return &memoryProcessedEventRepository{
+		capacity: capacity,
+		order:    list.New(),
+		events:   make(map[string]*list.Element),
+	}
+}
+
+func (r *memoryProcessedEventRepository) IsProcessed(ctx context.Context, eventID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elem, exists := r.events[eventID]
+	if !exists {
+		return false, nil
+	}
+
+	if !time.Now().Before(elem.Value.(*processedEvent).expiresAt) {
+		r.remove(elem)
+		return false, nil
+	}
+
+	r.order.MoveToFront(elem)
+	return true, nil
+}
+
+func (r *memoryProcessedEventRepository) MarkProcessed(ctx context.Context, eventID string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, exists := r.events[eventID]; exists {
+		elem.Value.(*processedEvent).expiresAt = expiresAt
+		r.order.MoveToFront(elem)
+		return nil
+	}
+
+	r.events[eventID] = r.order.PushFront(&processedEvent{id: eventID, expiresAt: expiresAt})
+
+	// Forget the least recently seen events once the capacity is exceeded
+	for r.order.Len() > r.capacity {
+		r.remove(r.order.Back())
+	}
+
+	return nil
+}
+
+func (r *memoryProcessedEventRepository) DeleteExpired(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now, deleted := time.Now(), 0
+	for elem := r.order.Front(); elem != nil; {
+		next := elem.Next()
+		if !now.Before(elem.Value.(*processedEvent).expiresAt) {
+			r.remove(elem)
+			deleted++
+		}
+		elem = next
+	}
+
+	return deleted, nil
+}
+
+// remove forgets the event, the lock must be held.
+func (r *memoryProcessedEventRepository) remove(elem *list.Element) {
+	r.order.Remove(elem)
+	delete(r.events, elem.Value.(*processedEvent).id)
+}
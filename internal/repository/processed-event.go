@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+type ProcessedEventRepository interface {
+	// IsProcessed reports whether the event was processed and has not expired yet.
+	IsProcessed(ctx context.Context, eventID string) (bool, error)
+	// MarkProcessed remembers the event as processed until expiresAt.
+	MarkProcessed(ctx context.Context, eventID string, expiresAt time.Time) error
+	// DeleteExpired removes the expired events and returns how many were removed.
+	DeleteExpired(ctx context.Context) (int, error)
+}
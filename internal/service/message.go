@@ -2,81 +2,409 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
 
 	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
 	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
 	validator "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/validation-util"
 )
 
 const (
 	TopicMessage = "messaging" // Kafka topic for messages
+
+	// PublishModeSync publishes the message to Kafka before SendMessage returns
+	PublishModeSync = "sync"
+	// PublishModeOutbox writes the message and its event to the outbox in one transaction,
+	// the outbox relay publishes the event afterwards
+	PublishModeOutbox = "outbox"
+	// PublishModeAsync queues the event on an asynchronous writer and returns straight away,
+	// the status of the message is updated once the writer reports the delivery
+	PublishModeAsync = "async"
 )
 
 type MessageService interface {
 	SendMessage(ctx context.Context, message *entity.Message) error
-	ReadMessage(worker string, message *entity.Message) error
+	SendMessages(ctx context.Context, messages []entity.Message) ([]entity.MessageBatchResult, error)
+	ReadMessage(ctx context.Context, worker string, message *entity.Message) error
+	GetMessage(ctx context.Context, id string) (*entity.Message, error)
+	// HandleDelivery saves the status of the messages the asynchronous writer wrote, or failed to write.
+	// In async mode it must be registered with kafkautil.OnDelivery.
+	HandleDelivery(msgs []kafka.Message, deliveryErr error)
 }
 
 type messageService struct {
-	// You can add fields here if needed, e.g., a repository or a logger
+	MessageRepository repository.MessageRepository
+	PublishMode       string
 }
 
-func NewMessageService() MessageService {
-	return &messageService{}
+func NewMessageService(messageRepository repository.MessageRepository, publishMode string) MessageService {
+	return &messageService{
+		MessageRepository: messageRepository,
+		PublishMode:       publishMode,
+	}
 }
 
 func (s *messageService) SendMessage(ctx context.Context, message *entity.Message) error {
-	// Default values
-	message.ID = uuid.New().String() // Generate a new UUID for the Message
-	message.Timestamp = time.Now()   // Set the current timestamp
-	message.Status = entity.MessageStatusPending
+	setDefaults(message)
 
 	// Validate the message struct
 	if err := validator.ValidateStruct(message); err != nil {
 		return err
 	}
 
-	// Create the event with the message as payload
-	messageEvent := entity.MessageEvent{
-		EventType: entity.EventTypeSendingMessage,
-		Payload:   *message, // Use the message struct as the payload
+	messageEvent := newMessageEvent(message)
+	ctx = logger.WithFields(ctx, logrus.Fields{logger.FieldMessageID: message.ID})
+
+	var err error
+	switch s.PublishMode {
+	case PublishModeOutbox:
+		err = s.saveToOutbox(ctx, message, messageEvent)
+	case PublishModeAsync:
+		err = s.publishAsync(ctx, []*entity.Message{message})
+	default:
+		err = s.publish(ctx, message, messageEvent)
+	}
+
+	// Log the Message sent
+	logger.InfoContext(ctx, "Sending message", logrus.Fields{
+		"sender_id":       message.SenderID,
+		"receiver_id":     message.ReceiverID,
+		"conversation_id": message.ConversationID,
+		"status":          message.Status,
+		"timestamp":       message.Timestamp.Format(time.RFC3339),
+	})
+
+	return err
+}
+
+// SendMessages validates each message and publishes the valid ones with a single write to Kafka.
+// The result of each message is returned in the order of the batch, invalid messages are rejected
+// with their validation errors. The returned error is set when the valid messages could not be published,
+// their results then have the failed status.
+func (s *messageService) SendMessages(ctx context.Context, messages []entity.Message) ([]entity.MessageBatchResult, error) {
+	results := make([]entity.MessageBatchResult, len(messages))
+	valid := make([]int, 0, len(messages)) // Positions of the valid messages
+
+	for i := range messages {
+		message := &messages[i]
+		setDefaults(message)
+
+		if err := validator.ValidateStruct(message); err != nil {
+			results[i] = entity.MessageBatchResult{Index: i, Status: entity.MessageStatusRejected, Errors: validator.FormatValidationErrors(err)}
+			continue
+		}
+
+		valid = append(valid, i)
+	}
+
+	var err error
+	switch s.PublishMode {
+	case PublishModeOutbox:
+		err = s.saveBatchToOutbox(ctx, messages, valid)
+	case PublishModeAsync:
+		batch := make([]*entity.Message, 0, len(valid))
+		for _, i := range valid {
+			batch = append(batch, &messages[i])
+		}
+		err = s.publishAsync(ctx, batch)
+	default:
+		err = s.publishBatch(ctx, messages, valid)
+	}
+
+	for _, i := range valid {
+		results[i] = entity.MessageBatchResult{Index: i, ID: messages[i].ID, Status: messages[i].Status}
+	}
+
+	// Log the batch sent
+	logger.InfoContext(ctx, "Sending batch of messages", logrus.Fields{
+		"count":    len(messages),
+		"valid":    len(valid),
+		"rejected": len(messages) - len(valid),
+	})
+
+	return results, err
+}
+
+// publish saves the message, publishes its event to Kafka and saves the resulting status.
+func (s *messageService) publish(ctx context.Context, message *entity.Message, messageEvent entity.MessageEvent) error {
+	// Save the message as pending before publishing it,
+	// so there is a record of it even if publishing fails
+	if err := s.MessageRepository.Save(ctx, message); err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
 	}
 
 	// Publish message to Kafka
-	if err := kafkautil.PublishMessage(TopicMessage, message.ID, messageEvent); err != nil {
+	// Messages of the same conversation share a key, so they keep their order
+	publishErr := kafkautil.PublishMessage(ctx, TopicMessage, conversationKey(message), messageEvent, kafkautil.EventIDHeader(message.ID))
+	if publishErr != nil {
 		message.Status = entity.MessageStatusFailed
 	} else {
 		message.Status = entity.MessageStatusSent
 	}
 
-	// Here you can add additional logic, such as saving the message to a database
-	// or performing other operations after sending the message.
+	// Save the status transition
+	if err := s.MessageRepository.UpdateStatus(ctx, message.ID, message.Status); err != nil {
+		return fmt.Errorf("failed to update message status: %w", err)
+	}
+
+	// Let the caller know the message could not be published
+	if publishErr != nil {
+		return fmt.Errorf("failed to publish message: %w", publishErr)
+	}
+
+	return nil
+}
+
+// publishBatch saves the valid messages, publishes their events to Kafka in one write and saves the resulting statuses.
+func (s *messageService) publishBatch(ctx context.Context, messages []entity.Message, valid []int) error {
+	if len(valid) == 0 {
+		return nil
+	}
+
+	batch := make([]*entity.Message, 0, len(valid))
+	for _, i := range valid {
+		batch = append(batch, &messages[i])
+	}
+
+	msgs := make([]kafka.Message, 0, len(batch))
+	for j, message := range batch {
+		// Save the message as pending before publishing it,
+		// so there is a record of it even if publishing fails
+		if err := s.MessageRepository.Save(ctx, message); err != nil {
+			s.markFailed(ctx, batch, j)
+			return fmt.Errorf("failed to save message: %w", err)
+		}
+
+		msg, err := kafkautil.NewMessage(ctx, TopicMessage, conversationKey(message), newMessageEvent(message), kafkautil.EventIDHeader(message.ID))
+		if err != nil {
+			s.markFailed(ctx, batch, j+1)
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	// Publish all the messages to Kafka in one write
+	publishErr := kafkautil.PublishMessages(TopicMessage, msgs...)
+
+	// The write errors tell which messages failed, after all the attempts
+	var writeErrs kafka.WriteErrors
+	errors.As(publishErr, &writeErrs)
+
+	for j, message := range batch {
+		message.Status = entity.MessageStatusSent
+		if publishErr != nil && (len(writeErrs) != len(batch) || writeErrs[j] != nil) {
+			message.Status = entity.MessageStatusFailed
+		}
+
+		// Save the status transition
+		if err := s.MessageRepository.UpdateStatus(ctx, message.ID, message.Status); err != nil {
+			return fmt.Errorf("failed to update message status: %w", err)
+		}
+	}
+
+	// Let the caller know the messages could not be published
+	if publishErr != nil {
+		return fmt.Errorf("failed to publish messages: %w", publishErr)
+	}
 
-	// Log the Message sent
-	fmt.Println()
-	fmt.Printf("Sending message:\n"+
-		"ID: %s\nSenderID: %s\nReceiverID: %s\nMessage: %s\n"+
-		"Status: %s\nTimestamp: %s\n",
-		message.ID, message.SenderID, message.ReceiverID, message.Message,
-		message.Status, message.Timestamp.Format(time.RFC3339))
+	return nil
+}
+
+// markFailed sets the status of the messages of a batch that stopped before they were published to failed.
+// Only the first saved messages are in the repository, the status of the others is only set on them.
+func (s *messageService) markFailed(ctx context.Context, batch []*entity.Message, saved int) {
+	for j, message := range batch {
+		message.Status = entity.MessageStatusFailed
+		if j >= saved {
+			continue
+		}
+
+		if err := s.MessageRepository.UpdateStatus(ctx, message.ID, message.Status); err != nil {
+			logger.ErrorContext(ctx, "Failed to update status of message", logrus.Fields{logger.FieldMessageID: message.ID, logger.FieldError: err.Error()})
+		}
+	}
+}
+
+// publishAsync saves the messages as pending and queues their events on the asynchronous writer.
+// They stay pending until HandleDelivery is called with the delivery result.
+func (s *messageService) publishAsync(ctx context.Context, messages []*entity.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	msgs := make([]kafka.Message, 0, len(messages))
+	for j, message := range messages {
+		// Save the message as pending before publishing it,
+		// so the delivery result always has a message to update
+		if err := s.MessageRepository.Save(ctx, message); err != nil {
+			s.markFailed(ctx, messages, j)
+			return fmt.Errorf("failed to save message: %w", err)
+		}
+
+		msg, err := kafkautil.NewMessage(ctx, TopicMessage, conversationKey(message), newMessageEvent(message), kafkautil.EventIDHeader(message.ID))
+		if err != nil {
+			s.markFailed(ctx, messages, j+1)
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	publishErr := kafkautil.PublishMessagesAsync(TopicMessage, msgs...)
+	if publishErr == nil {
+		return nil
+	}
+
+	// The messages could not even be queued, so they will not be delivered
+	for _, message := range messages {
+		message.Status = entity.MessageStatusFailed
+		if err := s.MessageRepository.UpdateStatus(ctx, message.ID, message.Status); err != nil {
+			return fmt.Errorf("failed to update message status: %w", err)
+		}
+	}
+
+	return fmt.Errorf("failed to publish message: %w", publishErr)
+}
+
+func (s *messageService) HandleDelivery(msgs []kafka.Message, deliveryErr error) {
+	status := entity.MessageStatusSent
+	if deliveryErr != nil {
+		status = entity.MessageStatusFailed
+		logger.Error("Failed to deliver messages", logrus.Fields{
+			logger.FieldTopic: TopicMessage,
+			"count":           len(msgs),
+			logger.FieldError: deliveryErr.Error(),
+		})
+	}
+
+	// The request that sent the messages is already done, so there is no request context to use
+	ctx := context.Background()
+	for _, msg := range msgs {
+		id, ok := kafkautil.EventID(msg)
+		if !ok {
+			continue
+		}
+
+		if err := s.MessageRepository.UpdateStatus(ctx, id, status); err != nil {
+			logger.Error("Failed to update status of message", logrus.Fields{logger.FieldMessageID: id, logger.FieldError: err.Error()})
+		}
+	}
+}
+
+// saveBatchToOutbox saves each valid message together with its event in the outbox.
+func (s *messageService) saveBatchToOutbox(ctx context.Context, messages []entity.Message, valid []int) error {
+	for j, i := range valid {
+		message := &messages[i]
+		if err := s.saveToOutbox(ctx, message, newMessageEvent(message)); err != nil {
+			// This message and the ones after it were not saved, so they will not be sent
+			for _, k := range valid[j:] {
+				messages[k].Status = entity.MessageStatusFailed
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveToOutbox saves the pending message together with its event in the outbox.
+// The message stays pending until the outbox relay has published the event.
+func (s *messageService) saveToOutbox(ctx context.Context, message *entity.Message, messageEvent entity.MessageEvent) error {
+	msg, err := kafkautil.NewMessage(ctx, TopicMessage, conversationKey(message), messageEvent, kafkautil.EventIDHeader(message.ID))
+	if err != nil {
+		return err
+	}
+
+	headers := make(entity.OutboxHeaders, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, entity.OutboxHeader{Key: h.Key, Value: h.Value})
+	}
+
+	now := time.Now()
+	entry := entity.OutboxEntry{
+		MessageID:     message.ID,
+		Topic:         TopicMessage,
+		Key:           string(msg.Key),
+		Value:         msg.Value,
+		Headers:       headers,
+		Status:        entity.OutboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+
+	if err := s.MessageRepository.SaveWithOutbox(ctx, message, &entry); err != nil {
+		return fmt.Errorf("failed to save message to outbox: %w", err)
+	}
 
 	return nil
 }
 
-func (s *messageService) ReadMessage(worker string, message *entity.Message) error {
-	fmt.Println()
-	fmt.Printf("Reading message by %s:\n"+
-		"ID: %s\nSenderID: %s\nReceiverID: %s\nMessage: %s\n",
-		worker, message.ID, message.SenderID, message.ReceiverID, message.Message)
+func (s *messageService) ReadMessage(ctx context.Context, worker string, message *entity.Message) error {
+	logger.InfoContext(ctx, "Reading message", logrus.Fields{
+		logger.FieldWorker:    worker,
+		logger.FieldMessageID: message.ID,
+		"sender_id":           message.SenderID,
+		"receiver_id":         message.ReceiverID,
+		"conversation_id":     message.ConversationID,
+	})
 
-	// Here you can add logic to process the message, such as updating its status
+	// Mark the message as delivered
 	message.Status = entity.MessageStatusDelivered
 
-	// then you can save the updated message to a database or perform other actions
+	// Save the delivered transition. If the message was produced by another instance
+	// with its own store, there is nothing to update, so save the whole message instead.
+	err := s.MessageRepository.UpdateStatus(ctx, message.ID, message.Status)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		err = s.MessageRepository.Save(ctx, message)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save delivered status: %w", err)
+	}
 
 	return nil
 }
+
+func (s *messageService) GetMessage(ctx context.Context, id string) (*entity.Message, error) {
+	message, err := s.MessageRepository.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// setDefaults sets the fields of a new message that are not set by the client.
+func setDefaults(message *entity.Message) {
+	message.ID = uuid.New().String() // Generate a new UUID for the Message
+	message.Timestamp = time.Now()   // Set the current timestamp
+	message.Status = entity.MessageStatusPending
+	if message.ConversationID == "" {
+		message.ConversationID = entity.DefaultConversationID(message.SenderID, message.ReceiverID)
+	}
+}
+
+// newMessageEvent creates the event with the message as payload.
+func newMessageEvent(message *entity.Message) entity.MessageEvent {
+	return entity.MessageEvent{
+		EventType: entity.EventTypeSendingMessage,
+		Version:   entity.MessageEventVersion,
+		Payload:   *message, // Use the message struct as the payload
+	}
+}
+
+// conversationKey returns the Kafka message key for the conversation of the message.
+// All the messages of a conversation share it, in both directions, so a reply is ordered after the message it replies to.
+func conversationKey(message *entity.Message) string {
+	if message.ConversationID == "" {
+		return entity.DefaultConversationID(message.SenderID, message.ReceiverID)
+	}
+
+	return message.ConversationID
+}
@@ -1,18 +1,63 @@
 package kafka
 
 import (
+	"context"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/service"
 	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/handler"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/middleware"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/metrics"
 	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
 )
 
 const (
 	topicMessage = "messaging"
+
+	// consumerModeGroup starts KAFKA_CONSUMER_WORKERS readers in the same consumer group
+	consumerModeGroup = "group"
+	// consumerModeKeyed starts one reader that fans messages out to KAFKA_CONSUMER_WORKERS workers by key
+	consumerModeKeyed = "keyed"
+
+	defaultHandlerTimeout = 30 * time.Second
 )
 
-func StartConsumer() {
+var (
+	middlewares []kafkautil.Middleware
+)
+
+// Use adds middlewares to the chain around the consumer handlers, like gin's Use.
+// They run after the built-in middlewares of Handler, in the order they were added.
+// It must be called before StartConsumer.
+func Use(mw ...kafkautil.Middleware) {
+	middlewares = append(middlewares, mw...)
+}
+
+// Handler wraps h with the built-in middlewares, then the ones added with Use.
+// Tracing and Timing are the outermost ones, so they include the errors of recovered panics and timeouts.
+func Handler(h kafkautil.HandlerFunc, timeout time.Duration) kafkautil.HandlerFunc {
+	chain := append([]kafkautil.Middleware{
+		middleware.Tracing(),
+		middleware.Timing(metrics.ObserveHandled),
+		middleware.Correlation(),
+		middleware.Logging(),
+		middleware.Recovery(),
+		middleware.Timeout(timeout),
+	}, middlewares...)
+
+	return kafkautil.Chain(h, chain...)
+}
+
+// StartConsumer starts the consumer workers. They stop once ctx is cancelled,
+// after finishing their in-flight message, and are tracked by wg until then.
+func StartConsumer(ctx context.Context, wg *sync.WaitGroup, messageService service.MessageService) {
 	// Get the number of workers from the environment variable, default to 1 if not set
 	numWorkersStr := os.Getenv("KAFKA_CONSUMER_WORKERS")
 	numWorkers, err := strconv.Atoi(numWorkersStr)
@@ -20,7 +65,53 @@ func StartConsumer() {
 		numWorkers = 1
 	}
 
-	for i := 0; i < numWorkers; i++ {
-		go kafkautil.ConsumeMessages(i, topicMessage, handler.HandleMessaging)
+	// Get the policy for events without a handler from the environment variable, default to dlq if not set
+	fallback, err := handler.ParseFallbackPolicy(os.Getenv("KAFKA_UNKNOWN_EVENT_POLICY"))
+	if err != nil {
+		logger.Warn("Invalid KAFKA_UNKNOWN_EVENT_POLICY value, using the default", logrus.Fields{"default": handler.FallbackDLQ, logger.FieldError: err.Error()})
+		fallback = handler.FallbackDLQ
+	}
+	registry := handler.NewRegistry(fallback)
+
+	// Register the handlers for the messaging events
+	handler.NewMessagingHandler(messageService).Register(registry)
+
+	// Get the per-message handler timeout from the environment variable, default to 30 seconds if not set
+	timeout := defaultHandlerTimeout
+	if ms, err := strconv.Atoi(os.Getenv("KAFKA_HANDLER_TIMEOUT_MS")); err == nil && ms > 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	h := Handler(registry.Dispatch, timeout)
+
+	startWorker := func(workerID int, topic string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			kafkautil.ConsumeMessages(ctx, workerID, topic, h)
+		}()
+	}
+
+	// Get the consumer mode from the environment variable, default to group if not set
+	switch mode := os.Getenv("KAFKA_CONSUMER_MODE"); mode {
+	case consumerModeKeyed:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			kafkautil.ConsumeMessagesByKey(ctx, topicMessage, numWorkers, h)
+		}()
+	default:
+		if mode != "" && mode != consumerModeGroup {
+			logger.Warn("Invalid KAFKA_CONSUMER_MODE value, using the default", logrus.Fields{"default": consumerModeGroup, "value": mode})
+		}
+
+		for i := 0; i < numWorkers; i++ {
+			startWorker(i, topicMessage)
+		}
+	}
+
+	// Start one worker per retry tier, these wait for each message's delay before handling it
+	for i, tier := range async.GetKafkaRetryTiers(topicMessage) {
+		startWorker(numWorkers+i, tier.Topic)
 	}
 }
@@ -1,32 +1,83 @@
 package handler
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-
-	"github.com/segmentio/kafka-go"
+	"strings"
 
 	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
 	"github.com/yoanesber/go-kafka-messaging-demo/internal/service"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
 )
 
-func HandleMessaging(worker string, msg kafka.Message) error {
-	// Unmarshal the message value into a MessageEvent struct
-	var msgEvent entity.MessageEvent
-	if err := json.Unmarshal(msg.Value, &msgEvent); err != nil {
-		return fmt.Errorf("failed to unmarshal message value: %w", err)
+type MessagingHandler struct {
+	MessageService service.MessageService
+}
+
+func NewMessagingHandler(messageService service.MessageService) *MessagingHandler {
+	return &MessagingHandler{
+		MessageService: messageService,
 	}
+}
+
+// Register registers the handlers for the messaging events.
+func (h *MessagingHandler) Register(r *Registry) {
+	r.Register(AnyTopic, entity.EventTypeSendingMessage, AnyVersion, h.handleSendingMessageEvent)
+	RegisterMessageUpcasters(r)
+}
 
-	// Map the event type to transaction handler
-	eventType := msgEvent.EventType
-	switch eventType {
-	case entity.EventTypeSendingMessage:
-		s := service.NewMessageService()
-		if err := s.ReadMessage(worker, &msgEvent.Payload); err != nil {
-			return fmt.Errorf("failed to read message: %w", err)
+// handleSendingMessageEvent decodes the message of the event and sets the fields its version did not have.
+// The upcasters only migrate JSON payloads, protobuf and Avro payloads are decoded with the current schema
+// and keep the version they were published with, so their missing fields are set here.
+func (h *MessagingHandler) handleSendingMessageEvent(ctx context.Context, worker string, event Event) error {
+	return TypedHandler(func(ctx context.Context, worker string, message *entity.Message) error {
+		if event.Version < 2 {
+			defaultMessageV1(message)
 		}
-	default:
-		return fmt.Errorf("unknown event type: %s", eventType)
+		return h.HandleSendingMessage(ctx, worker, message)
+	})(ctx, worker, event)
+}
+
+// RegisterMessageUpcasters registers the upcasters of the sending-message payload,
+// one for every version before entity.MessageEventVersion.
+func RegisterMessageUpcasters(r *Registry) {
+	r.RegisterUpcaster(entity.EventTypeSendingMessage, 1, upcastMessageV1)
+}
+
+// upcastMessageV1 adds the conversation ID and the attachments of version 2.
+// Version 1 messages had no conversation ID, so it is the default one of the sender and the receiver.
+func upcastMessageV1(payload map[string]interface{}) (map[string]interface{}, error) {
+	senderID, _ := payload["sender_id"].(string)
+	receiverID, _ := payload["receiver_id"].(string)
+	if strings.TrimSpace(senderID) == "" || strings.TrimSpace(receiverID) == "" {
+		return nil, fmt.Errorf("version 1 message has no sender or receiver")
+	}
+
+	if conversationID, _ := payload["conversation_id"].(string); conversationID == "" {
+		payload["conversation_id"] = entity.DefaultConversationID(senderID, receiverID)
+	}
+	if _, exists := payload["attachments"]; !exists {
+		payload["attachments"] = []interface{}{}
+	}
+
+	return payload, nil
+}
+
+// defaultMessageV1 sets the conversation ID and the attachments of a version 1 message, as upcastMessageV1 does.
+func defaultMessageV1(message *entity.Message) {
+	if message.ConversationID == "" {
+		message.ConversationID = entity.DefaultConversationID(message.SenderID, message.ReceiverID)
+	}
+	if message.Attachments == nil {
+		message.Attachments = []entity.MessageAttachment{}
+	}
+}
+
+func (h *MessagingHandler) HandleSendingMessage(ctx context.Context, worker string, message *entity.Message) error {
+	// Reading the message only fails when the message store is unavailable,
+	// so let the consumer retry it later
+	if err := h.MessageService.ReadMessage(ctx, worker, message); err != nil {
+		return kafkautil.NewRetryableError(fmt.Errorf("failed to read message: %w", err))
 	}
 
 	return nil
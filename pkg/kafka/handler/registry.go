@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * Registry dispatches consumed events to the handler registered for their event type.
+ * Handlers can be registered for a specific topic and event version, or for any of them,
+ * and the most specific match wins. Events without a handler are treated according to the fallback policy.
+ * New event kinds only need a Register call, the dispatcher itself does not change.
+ */
+
+const (
+	AnyTopic   = "" // Register the handler for every topic
+	AnyVersion = 0  // Register the handler for every event version
+	minVersion = 1  // Version of events published before the version field existed
+)
+
+// FallbackPolicy decides what happens to an event that has no registered handler.
+type FallbackPolicy string
+
+const (
+	// FallbackSkip drops the event and moves on
+	FallbackSkip FallbackPolicy = "skip"
+	// FallbackDLQ sends the event to the dead-letter topic right away
+	FallbackDLQ FallbackPolicy = "dlq"
+	// FallbackFail fails the event as retryable, so it goes through the retry topics
+	// (in case a handler gets deployed meanwhile) before it ends up in the dead-letter topic
+	FallbackFail FallbackPolicy = "fail"
+)
+
+var (
+	// ErrUnknownEventType is returned when no handler is registered for the event
+	ErrUnknownEventType = errors.New("unknown event type")
+)
+
+// Event is a consumed event whose payload has not been decoded yet.
+// The handler chosen for it decodes the payload into the type it expects.
+type Event struct {
+	Topic       string        // Topic the event was originally published to
+	EventType   string        // Type of the event, e.g., "sending-message"
+	Version     int           // Version of the event payload
+	Payload     []byte        // The encoded event payload
+	ContentType string        // Content type of the payload, e.g., "application/json"
+	Message     kafka.Message // The Kafka message the event was read from
+}
+
+// EventHandler handles a single event.
+type EventHandler func(ctx context.Context, worker string, event Event) error
+
+// TypedHandler adapts a handler of a concrete payload type to an EventHandler.
+// The payload is decoded into T with the codec of its content type before the handler is called,
+// a payload that cannot be decoded is not retried.
+func TypedHandler[T any](handle func(ctx context.Context, worker string, payload *T) error) EventHandler {
+	return func(ctx context.Context, worker string, event Event) error {
+		codec, err := kafkautil.CodecForContentType(event.ContentType)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s payload: %w", event.EventType, err)
+		}
+
+		var payload T
+		if err := codec.Unmarshal(event.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to unmarshal %s payload: %w", event.EventType, err)
+		}
+
+		return handle(ctx, worker, &payload)
+	}
+}
+
+type registryKey struct {
+	topic     string
+	eventType string
+	version   int
+}
+
+type Registry struct {
+	mu        sync.RWMutex
+	handlers  map[registryKey]EventHandler
+	upcasters map[upcasterKey]Upcaster
+	fallback  FallbackPolicy
+}
+
+func NewRegistry(fallback FallbackPolicy) *Registry {
+	return &Registry{
+		handlers:  make(map[registryKey]EventHandler),
+		upcasters: make(map[upcasterKey]Upcaster),
+		fallback:  fallback,
+	}
+}
+
+// ParseFallbackPolicy parses the fallback policy name, defaulting to FallbackDLQ if it is empty.
+func ParseFallbackPolicy(policy string) (FallbackPolicy, error) {
+	switch FallbackPolicy(policy) {
+	case "":
+		return FallbackDLQ, nil
+	case FallbackSkip, FallbackDLQ, FallbackFail:
+		return FallbackPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown fallback policy: %s", policy)
+	}
+}
+
+// Register sets the handler for the event type on the topic and version.
+// Use AnyTopic and AnyVersion to match every topic or version.
+func (r *Registry) Register(topic string, eventType string, version int, handler EventHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[registryKey{topic: topic, eventType: eventType, version: version}] = handler
+}
+
+// Dispatch routes the message on its event type header and calls the matching handler.
+// The value is only decoded once a handler was found. Messages published without the header
+// are routed on the event type in their value instead, a structured CloudEvent or the legacy envelope.
+// Payloads of older versions are upcasted to the current version first.
+// Its signature matches kafka_util.HandlerFunc, so it can be passed to kafka_util.ConsumeMessages.
+func (r *Registry) Dispatch(ctx context.Context, worker string, msg kafka.Message) error {
+	event := Event{
+		Topic:   kafkautil.OriginalTopic(msg),
+		Message: msg,
+	}
+
+	eventType, version, fromHeaders := kafkautil.EventType(msg)
+	decoded := !fromHeaders
+	if decoded {
+		decodedEvent, err := kafkautil.DecodeEvent(msg)
+		if err != nil {
+			return err
+		}
+		eventType, version, event.Payload, event.ContentType = decodedEvent.EventType, decodedEvent.Version, decodedEvent.Payload, decodedEvent.ContentType
+	}
+	event.EventType, event.Version = eventType, max(version, minVersion)
+
+	if _, exists := r.upcasterFor(event.EventType, event.Version); exists {
+		if !decoded {
+			if err := decodePayload(&event, msg); err != nil {
+				return err
+			}
+			decoded = true
+		}
+
+		// A payload that cannot be upcasted will not get better with a retry
+		if err := r.upcast(&event); err != nil {
+			return err
+		}
+	}
+
+	handler, ok := r.lookup(event)
+	if !ok {
+		return r.handleUnknown(ctx, event)
+	}
+
+	if !decoded {
+		if err := decodePayload(&event, msg); err != nil {
+			return err
+		}
+	}
+
+	return handler(ctx, worker, event)
+}
+
+// decodePayload sets the payload of the event from the value of the message.
+func decodePayload(event *Event, msg kafka.Message) error {
+	payload, contentType, err := kafkautil.EventPayload(msg)
+	if err != nil {
+		return err
+	}
+	event.Payload, event.ContentType = payload, contentType
+
+	return nil
+}
+
+// lookup finds the most specific handler for the event.
+func (r *Registry) lookup(event Event) (EventHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []registryKey{
+		{topic: event.Topic, eventType: event.EventType, version: event.Version},
+		{topic: event.Topic, eventType: event.EventType, version: AnyVersion},
+		{topic: AnyTopic, eventType: event.EventType, version: event.Version},
+		{topic: AnyTopic, eventType: event.EventType, version: AnyVersion},
+	}
+	for _, key := range keys {
+		if handler, exists := r.handlers[key]; exists {
+			return handler, true
+		}
+	}
+
+	return nil, false
+}
+
+func (r *Registry) handleUnknown(ctx context.Context, event Event) error {
+	err := fmt.Errorf("%w: %s (version %d) on topic %s", ErrUnknownEventType, event.EventType, event.Version, event.Topic)
+
+	switch r.fallback {
+	case FallbackSkip:
+		logger.WarnContext(ctx, "Skipping event", logrus.Fields{logger.FieldError: err.Error()})
+		return nil
+	case FallbackFail:
+		return kafkautil.NewRetryableError(err)
+	default:
+		return err
+	}
+}
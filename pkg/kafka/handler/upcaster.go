@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * Upcasters migrate the payload of older event versions to the current one before the event is dispatched,
+ * so events that were published before a payload change and are still in the topic keep being handled,
+ * and handlers only deal with the current version.
+ * An upcaster migrates a payload from one version to the next, and the registry chains them
+ * until no upcaster is registered for the version reached.
+ * Upcasters work on JSON payloads. Protobuf and Avro payloads rely on the schema evolution of their codec,
+ * so they are dispatched with the version they were published with, and the handler sets the fields it did not have.
+ */
+
+// Upcaster migrates a JSON payload from its version to the next one.
+// Numbers in the payload are json.Number, so large integers keep their precision.
+type Upcaster func(payload map[string]interface{}) (map[string]interface{}, error)
+
+type upcasterKey struct {
+	eventType string
+	version   int
+}
+
+// RegisterUpcaster sets the upcaster that migrates payloads of the event type from the version to the next one.
+func (r *Registry) RegisterUpcaster(eventType string, version int, upcaster Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.upcasters[upcasterKey{eventType: eventType, version: version}] = upcaster
+}
+
+func (r *Registry) upcasterFor(eventType string, version int) (Upcaster, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	upcaster, exists := r.upcasters[upcasterKey{eventType: eventType, version: version}]
+	return upcaster, exists
+}
+
+// upcast migrates the payload of the event through the chain of upcasters, and sets the version it reached.
+func (r *Registry) upcast(event *Event) error {
+	codec, err := kafkautil.CodecForContentType(event.ContentType)
+	if err != nil || codec.ContentType() != kafkautil.ContentTypeJSON {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(event.Payload))
+	decoder.UseNumber()
+
+	var payload map[string]interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return fmt.Errorf("failed to decode %s (version %d) payload to upcast it: %w", event.EventType, event.Version, err)
+	}
+
+	version := event.Version
+	for {
+		upcaster, exists := r.upcasterFor(event.EventType, version)
+		if !exists {
+			break
+		}
+
+		if payload, err = upcaster(payload); err != nil {
+			return fmt.Errorf("failed to upcast %s from version %d: %w", event.EventType, version, err)
+		}
+		version++
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode upcasted %s payload: %w", event.EventType, err)
+	}
+
+	event.Payload, event.Version = data, version
+	return nil
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * Correlation is a consumer middleware that restores the correlation ID of the message in the handler context.
+ * The ID comes from the x-correlation-id header, which carries the X-Request-ID of the HTTP request that published it.
+ * It is added to the log fields as request_id, so the consumer output can be matched with the request,
+ * and the events published by the handler carry the same correlation ID.
+ */
+
+func Correlation() kafkautil.Middleware {
+	return func(next kafkautil.HandlerFunc) kafkautil.HandlerFunc {
+		return func(ctx context.Context, worker string, msg kafka.Message) error {
+			if correlationID, ok := kafkautil.MessageCorrelationID(msg); ok {
+				ctx = kafkautil.WithCorrelationID(ctx, correlationID)
+				ctx = logger.WithFields(ctx, logrus.Fields{logger.FieldRequestID: correlationID})
+			}
+
+			return next(ctx, worker, msg)
+		}
+	}
+}
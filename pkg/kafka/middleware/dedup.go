@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * Dedup is a consumer middleware that drops events which were already processed.
+ * Kafka delivers at least once, so an event can be delivered again after a rebalance or a retry.
+ * Events are identified by the x-event-id header, or by the ID of the message in the payload
+ * for events published without it. An event is remembered once its handler succeeded, for ttl,
+ * so a failed event is still retried. Duplicates within that window are dropped without calling the handler,
+ * and counted by DuplicatesDropped. If the store fails, the event is handled anyway.
+ */
+
+var (
+	duplicatesDropped atomic.Uint64
+)
+
+func Dedup(processedEventRepository repository.ProcessedEventRepository, ttl time.Duration) kafkautil.Middleware {
+	return func(next kafkautil.HandlerFunc) kafkautil.HandlerFunc {
+		return func(ctx context.Context, worker string, msg kafka.Message) error {
+			eventID := eventIDOf(msg)
+			if eventID == "" {
+				return next(ctx, worker, msg)
+			}
+
+			fields := logrus.Fields{
+				logger.FieldWorker:    worker,
+				logger.FieldTopic:     msg.Topic,
+				logger.FieldPartition: msg.Partition,
+				logger.FieldOffset:    msg.Offset,
+				logger.FieldMessageID: eventID,
+			}
+
+			processed, err := processedEventRepository.IsProcessed(ctx, eventID)
+			if err != nil {
+				fields[logger.FieldError] = err.Error()
+				logger.ErrorContext(ctx, "Failed to check whether event was processed", fields)
+			}
+			if processed {
+				duplicatesDropped.Add(1)
+				logger.InfoContext(ctx, "Duplicate event dropped", fields)
+				return nil
+			}
+
+			if err := next(ctx, worker, msg); err != nil {
+				return err
+			}
+
+			if err := processedEventRepository.MarkProcessed(ctx, eventID, time.Now().Add(ttl)); err != nil {
+				fields[logger.FieldError] = err.Error()
+				logger.ErrorContext(ctx, "Failed to mark event as processed", fields)
+			}
+
+			return nil
+		}
+	}
+}
+
+// DuplicatesDropped returns how many duplicate events were dropped since start.
+func DuplicatesDropped() uint64 {
+	return duplicatesDropped.Load()
+}
+
+// eventIDOf returns the ID of the event in the message, or an empty string if it has none.
+func eventIDOf(msg kafka.Message) string {
+	if eventID, ok := kafkautil.EventID(msg); ok {
+		return eventID
+	}
+
+	// Events published before the header was added carry the message ID in the payload
+	payload, contentType, err := kafkautil.EventPayload(msg)
+	if err != nil {
+		return ""
+	}
+
+	codec, err := kafkautil.CodecForContentType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	var message struct {
+		ID string `json:"id"`
+	}
+	if err := codec.Unmarshal(payload, &message); err != nil {
+		return ""
+	}
+
+	return message.ID
+}
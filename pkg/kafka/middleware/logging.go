@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * Logging is a consumer middleware that logs every handled message through pkg/logger.
+ * The worker, topic, partition, offset and message ID are added to the context passed to the handler,
+ * so every entry logged with it while handling the message carries them.
+ * Each entry also carries the key, retry attempt and handling duration,
+ * successful messages are logged at info level and failed ones at error level with the error.
+ */
+
+func Logging() kafkautil.Middleware {
+	return func(next kafkautil.HandlerFunc) kafkautil.HandlerFunc {
+		return func(ctx context.Context, worker string, msg kafka.Message) error {
+			messageFields := logrus.Fields{
+				logger.FieldWorker:    worker,
+				logger.FieldTopic:     msg.Topic,
+				logger.FieldPartition: msg.Partition,
+				logger.FieldOffset:    msg.Offset,
+			}
+			if eventID, ok := kafkautil.EventID(msg); ok {
+				messageFields[logger.FieldMessageID] = eventID
+			}
+			ctx = logger.WithFields(ctx, messageFields)
+
+			start := time.Now()
+			err := next(ctx, worker, msg)
+
+			fields := logrus.Fields{
+				"key":         string(msg.Key),
+				"attempt":     kafkautil.RetryAttempt(msg),
+				"duration_ms": time.Since(start).Milliseconds(),
+			}
+
+			if err != nil {
+				fields[logger.FieldError] = err.Error()
+				logger.ErrorContext(ctx, "Failed to handle message", fields)
+				return err
+			}
+
+			logger.InfoContext(ctx, "Message handled", fields)
+			return nil
+		}
+	}
+}
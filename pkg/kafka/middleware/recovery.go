@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * Recovery is a consumer middleware that recovers from panics in the handler.
+ * Without it, a panic in a handler crashes the worker goroutine and with it the whole process.
+ * The panic is logged with its stack trace and returned as an error,
+ * so the message is sent to the dead-letter topic like any other failure.
+ */
+
+func Recovery() kafkautil.Middleware {
+	return func(next kafkautil.HandlerFunc) kafkautil.HandlerFunc {
+		return func(ctx context.Context, worker string, msg kafka.Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.ErrorContext(ctx, "Recovered from panic in consumer handler", logrus.Fields{
+						logger.FieldWorker:    worker,
+						logger.FieldTopic:     msg.Topic,
+						logger.FieldPartition: msg.Partition,
+						logger.FieldOffset:    msg.Offset,
+						"panic":               fmt.Sprint(r),
+						"stack":               string(debug.Stack()),
+					})
+
+					err = fmt.Errorf("panic in consumer handler: %v", r)
+				}
+			}()
+
+			return next(ctx, worker, msg)
+		}
+	}
+}
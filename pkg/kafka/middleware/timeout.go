@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * Timeout is a consumer middleware that gives the handler a deadline for each message.
+ * The handler sees the deadline through its context, and is expected to stop when it expires.
+ * A message that timed out is failed as retryable, so it is re-delivered through the retry topics.
+ */
+
+func Timeout(timeout time.Duration) kafkautil.Middleware {
+	return func(next kafkautil.HandlerFunc) kafkautil.HandlerFunc {
+		return func(ctx context.Context, worker string, msg kafka.Message) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			err := next(ctx, worker, msg)
+			if err != nil && errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+				return kafkautil.NewRetryableError(fmt.Errorf("handler timed out after %s: %w", timeout, err))
+			}
+
+			return err
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * Timing is a consumer middleware that measures how long the handler takes for each message.
+ * The measurement is passed to the observer together with the result,
+ * which makes it the hook for exporting handling durations and error counts as metrics.
+ */
+
+// TimingObserver receives the duration and result of each handled message.
+type TimingObserver func(worker string, msg kafka.Message, duration time.Duration, err error)
+
+func Timing(observe TimingObserver) kafkautil.Middleware {
+	return func(next kafkautil.HandlerFunc) kafkautil.HandlerFunc {
+		return func(ctx context.Context, worker string, msg kafka.Message) error {
+			start := time.Now()
+			err := next(ctx, worker, msg)
+
+			observe(worker, msg, time.Since(start), err)
+			return err
+		}
+	}
+}
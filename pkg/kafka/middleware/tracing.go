@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/tracing"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * Tracing is a consumer middleware that wraps the handling of each message in a span.
+ * The span continues the trace in the traceparent header of the message, so it is part of the same trace
+ * as the HTTP request that published it. The span context is passed to the handler through ctx,
+ * and a failed handler marks the span as failed.
+ */
+
+func Tracing() kafkautil.Middleware {
+	return func(next kafkautil.HandlerFunc) kafkautil.HandlerFunc {
+		return func(ctx context.Context, worker string, msg kafka.Message) error {
+			ctx, span := tracing.StartProcess(ctx, worker, msg)
+			span.SetAttributes(attribute.Int("messaging.retry.attempt", kafkautil.RetryAttempt(msg)))
+			if eventID, ok := kafkautil.EventID(msg); ok {
+				span.SetAttributes(semconv.MessagingMessageID(eventID))
+			}
+
+			err := next(ctx, worker, msg)
+
+			tracing.End(span, err)
+			return err
+		}
+	}
+}
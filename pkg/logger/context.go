@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Standard field names, so the same value has the same name in every log line
+const (
+	FieldRequestID = "request_id"
+	FieldMessageID = "message_id"
+	FieldTopic     = "topic"
+	FieldPartition = "partition"
+	FieldOffset    = "offset"
+	FieldWorker    = "worker"
+	FieldError     = "error"
+	FieldTraceID   = "trace_id"
+	FieldSpanID    = "span_id"
+)
+
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx carrying the fields, on top of the ones ctx already carries.
+// Entries logged with the returned context get them, e.g., the worker and offset of the message being handled.
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := make(logrus.Fields, len(fields)+len(FieldsFromContext(ctx)))
+	for key, value := range FieldsFromContext(ctx) {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FieldsFromContext returns the fields carried by ctx. The returned map must not be modified.
+func FieldsFromContext(ctx context.Context) logrus.Fields {
+	fields, _ := ctx.Value(fieldsKey{}).(logrus.Fields)
+	return fields
+}
+
+// FromContext returns an entry of the application logger with the fields carried by ctx,
+// and the trace and span IDs of the span in ctx, if any.
+func FromContext(ctx context.Context) *logrus.Entry {
+	return entryFromContext(GetLogger(), ctx)
+}
+
+func entryFromContext(logger *logrus.Logger, ctx context.Context) *logrus.Entry {
+	entry := logger.WithContext(ctx).WithFields(FieldsFromContext(ctx))
+
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		entry = entry.WithFields(logrus.Fields{
+			FieldTraceID: span.TraceID().String(),
+			FieldSpanID:  span.SpanID().String(),
+		})
+	}
+
+	return entry
+}
+
+// Log functions for different log levels, with the fields carried by ctx
+func InfoContext(ctx context.Context, msg string, fields logrus.Fields) {
+	FromContext(ctx).WithFields(fields).Info(msg)
+}
+
+func WarnContext(ctx context.Context, msg string, fields logrus.Fields) {
+	FromContext(ctx).WithFields(fields).Warn(msg)
+}
+
+func ErrorContext(ctx context.Context, msg string, fields logrus.Fields) {
+	FromContext(ctx).WithFields(fields).Error(msg)
+}
+
+func DebugContext(ctx context.Context, msg string, fields logrus.Fields) {
+	FromContext(ctx).WithFields(fields).Debug(msg)
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	publishAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "producer",
+		Name:      "publish_attempts_total",
+		Help:      "Number of attempts to write messages to a topic, including retries.",
+	}, []string{"topic"})
+
+	publishRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "producer",
+		Name:      "publish_retries_total",
+		Help:      "Number of attempts to write messages to a topic that retried a failed one.",
+	}, []string{"topic"})
+
+	publishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "producer",
+		Name:      "publish_failures_total",
+		Help:      "Number of writes to a topic that failed after all their attempts.",
+	}, []string{"topic"})
+
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "messages_consumed_total",
+		Help:      "Number of messages handled by each worker.",
+	}, []string{"worker", "topic"})
+
+	handlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "handler_errors_total",
+		Help:      "Number of messages whose handler returned an error, by worker.",
+	}, []string{"worker", "topic"})
+
+	handlingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "handling_duration_seconds",
+		Help:      "Duration of the message handler, by worker.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"worker", "topic"})
+
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "lag",
+		Help:      "Number of messages behind the end of the partition, as of the last message read from it.",
+	}, []string{"topic", "partition"})
+)
+
+// PublishAttempt counts an attempt to write messages to the topic, retry is set if it retries a failed one.
+func PublishAttempt(topic string, retry bool) {
+	publishAttempts.WithLabelValues(topic).Inc()
+	if retry {
+		publishRetries.WithLabelValues(topic).Inc()
+	}
+}
+
+// PublishFailed counts a write to the topic that failed after all its attempts.
+func PublishFailed(topic string) {
+	publishFailures.WithLabelValues(topic).Inc()
+}
+
+// ObserveHandled records a handled message, its signature matches middleware.TimingObserver.
+func ObserveHandled(worker string, msg kafka.Message, duration time.Duration, err error) {
+	messagesConsumed.WithLabelValues(worker, msg.Topic).Inc()
+	handlingDuration.WithLabelValues(worker, msg.Topic).Observe(duration.Seconds())
+	if err != nil {
+		handlerErrors.WithLabelValues(worker, msg.Topic).Inc()
+	}
+}
+
+// ObserveLag sets the lag of the partition the message was read from,
+// from the high watermark the broker returned with it.
+func ObserveLag(msg kafka.Message) {
+	if msg.HighWaterMark <= 0 {
+		return
+	}
+
+	lag := max(msg.HighWaterMark-msg.Offset-1, 0)
+	consumerLag.WithLabelValues(msg.Topic, strconv.Itoa(msg.Partition)).Set(float64(lag))
+}
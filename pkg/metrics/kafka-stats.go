@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+)
+
+const (
+	writerModeSync  = "sync"
+	writerModeAsync = "async"
+)
+
+// statDesc describes a metric read from the reader or writer stats.
+type statDesc struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+func newStatDesc(subsystem, name, help string, valueType prometheus.ValueType, labels ...string) statDesc {
+	return statDesc{
+		desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, labels, nil),
+		valueType: valueType,
+	}
+}
+
+var (
+	readerStats = map[string]statDesc{
+		"messages":       newStatDesc("kafka_reader", "messages_total", "Number of messages read by the reader.", prometheus.CounterValue, "topic"),
+		"bytes":          newStatDesc("kafka_reader", "bytes_total", "Number of message bytes read by the reader.", prometheus.CounterValue, "topic"),
+		"fetches":        newStatDesc("kafka_reader", "fetches_total", "Number of fetch requests of the reader.", prometheus.CounterValue, "topic"),
+		"errors":         newStatDesc("kafka_reader", "errors_total", "Number of errors of the reader.", prometheus.CounterValue, "topic"),
+		"timeouts":       newStatDesc("kafka_reader", "timeouts_total", "Number of fetch timeouts of the reader.", prometheus.CounterValue, "topic"),
+		"rebalances":     newStatDesc("kafka_reader", "rebalances_total", "Number of consumer group rebalances of the reader.", prometheus.CounterValue, "topic"),
+		"lag":            newStatDesc("kafka_reader", "lag", "Lag of the reader, only known for readers of a single partition.", prometheus.GaugeValue, "topic"),
+		"offset":         newStatDesc("kafka_reader", "offset", "Offset of the reader, only known for readers of a single partition.", prometheus.GaugeValue, "topic"),
+		"queue_length":   newStatDesc("kafka_reader", "queue_length", "Number of fetched messages waiting to be read.", prometheus.GaugeValue, "topic"),
+		"queue_capacity": newStatDesc("kafka_reader", "queue_capacity", "Capacity of the queue of fetched messages.", prometheus.GaugeValue, "topic"),
+	}
+
+	writerStats = map[string]statDesc{
+		"writes":   newStatDesc("kafka_writer", "writes_total", "Number of batches written by the writer.", prometheus.CounterValue, "topic", "mode"),
+		"messages": newStatDesc("kafka_writer", "messages_total", "Number of messages written by the writer.", prometheus.CounterValue, "topic", "mode"),
+		"bytes":    newStatDesc("kafka_writer", "bytes_total", "Number of message bytes written by the writer.", prometheus.CounterValue, "topic", "mode"),
+		"errors":   newStatDesc("kafka_writer", "errors_total", "Number of errors of the writer.", prometheus.CounterValue, "topic", "mode"),
+		"retries":  newStatDesc("kafka_writer", "retries_total", "Number of batch writes the writer retried.", prometheus.CounterValue, "topic", "mode"),
+		"write_seconds": newStatDesc("kafka_writer", "write_seconds_avg",
+			"Average time to write a batch since the previous scrape.", prometheus.GaugeValue, "topic", "mode"),
+		"batch_size": newStatDesc("kafka_writer", "batch_size_avg",
+			"Average number of messages per batch since the previous scrape.", prometheus.GaugeValue, "topic", "mode"),
+	}
+)
+
+// kafkaStatsCollector collects the stats of the Kafka readers and writers on each scrape.
+// Stats() resets the counters of a reader or writer on each call, so they are added up here.
+type kafkaStatsCollector struct {
+	mu       sync.Mutex
+	counters map[string]float64 // Totals of the counters, by metric name and labels
+}
+
+func init() {
+	prometheus.MustRegister(&kafkaStatsCollector{counters: make(map[string]float64)})
+}
+
+func (c *kafkaStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, stat := range readerStats {
+		ch <- stat.desc
+	}
+	for _, stat := range writerStats {
+		ch <- stat.desc
+	}
+}
+
+func (c *kafkaStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for topic, reader := range async.GetKafkaReaders() {
+		c.collectReader(ch, topic, reader.Stats())
+	}
+
+	writers, asyncWriters := async.GetKafkaWriters()
+	for topic, writer := range writers {
+		c.collectWriter(ch, topic, writerModeSync, writer.Stats())
+	}
+	for topic, writer := range asyncWriters {
+		c.collectWriter(ch, topic, writerModeAsync, writer.Stats())
+	}
+}
+
+func (c *kafkaStatsCollector) collectReader(ch chan<- prometheus.Metric, topic string, stats kafka.ReaderStats) {
+	values := map[string]int64{
+		"messages":       stats.Messages,
+		"bytes":          stats.Bytes,
+		"fetches":        stats.Fetches,
+		"errors":         stats.Errors,
+		"timeouts":       stats.Timeouts,
+		"rebalances":     stats.Rebalances,
+		"lag":            stats.Lag,
+		"offset":         stats.Offset,
+		"queue_length":   stats.QueueLength,
+		"queue_capacity": stats.QueueCapacity,
+	}
+
+	for name, value := range values {
+		c.emit(ch, "reader/"+name, readerStats[name], float64(value), topic)
+	}
+}
+
+func (c *kafkaStatsCollector) collectWriter(ch chan<- prometheus.Metric, topic string, mode string, stats kafka.WriterStats) {
+	values := map[string]float64{
+		"writes":        float64(stats.Writes),
+		"messages":      float64(stats.Messages),
+		"bytes":         float64(stats.Bytes),
+		"errors":        float64(stats.Errors),
+		"retries":       float64(stats.Retries),
+		"write_seconds": stats.WriteTime.Avg.Seconds(),
+		"batch_size":    float64(stats.BatchSize.Avg),
+	}
+
+	for name, value := range values {
+		c.emit(ch, "writer/"+name, writerStats[name], value, topic, mode)
+	}
+}
+
+// emit sends the metric, adding counter values to their total first.
+func (c *kafkaStatsCollector) emit(ch chan<- prometheus.Metric, key string, stat statDesc, value float64, labels ...string) {
+	if stat.valueType == prometheus.CounterValue {
+		for _, label := range labels {
+			key += "/" + label
+		}
+
+		c.counters[key] += value
+		value = c.counters[key]
+	}
+
+	ch <- prometheus.MustNewConstMetric(stat.desc, stat.valueType, value, labels...)
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/**
+ * metrics package exposes Prometheus metrics for the HTTP, producer and consumer layers.
+ * The metrics are registered with the default Prometheus registry, next to the Go runtime and process metrics,
+ * and served by Handler. HTTP requests are measured by the Requests middleware,
+ * publishing by the kafka-util writers, and consuming by the consumer workers and the Timing middleware.
+ * The stats of the Kafka readers and writers are collected on each scrape.
+ */
+
+const (
+	namespace = "messaging"
+)
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// DuplicatesDropped exposes the number of duplicate events dropped by the consumer, read from count on each scrape.
+func DuplicatesDropped(count func() uint64) {
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "duplicates_dropped_total",
+		Help:      "Number of duplicate events dropped without calling the handler.",
+	}, func() float64 {
+		return float64(count())
+	}))
+}
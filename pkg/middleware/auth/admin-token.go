@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	httputil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/http-util"
+)
+
+/**
+ * AdminToken is a middleware that restricts the admin routes to the operators holding the ADMIN_TOKEN.
+ * The token is sent as a bearer token in the `Authorization` header, and compared in constant time.
+ * A request without it is rejected with 401 Unauthorized, and one with another token with 403 Forbidden.
+ * If ADMIN_TOKEN is not set every request is rejected, so the admin routes are never open by mistake.
+ */
+
+const (
+	bearerPrefix = "Bearer "
+)
+
+func AdminToken() gin.HandlerFunc {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if !strings.HasPrefix(header, bearerPrefix) {
+			httputil.Unauthorized(c, "Unauthorized", "An admin token is required")
+			c.Abort()
+			return
+		}
+
+		token := strings.TrimPrefix(header, bearerPrefix)
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			httputil.Forbidden(c, "Forbidden", "Invalid admin token")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
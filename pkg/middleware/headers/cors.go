@@ -59,8 +59,8 @@ func CorsHeaders() gin.HandlerFunc {
 				maxAge := 24 * time.Hour
 				c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
 				c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-				c.Writer.Header().Set("Access-Control-Allow-Headers", "X-Requested-With, Content-Type, Origin, Authorization, Accept, Client-Security-Token, Accept-Encoding, x-access-token")
-				c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
+				c.Writer.Header().Set("Access-Control-Allow-Headers", "X-Requested-With, Content-Type, Origin, Authorization, Accept, Client-Security-Token, Accept-Encoding, x-access-token, X-Request-ID")
+				c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, X-Request-ID")
 				c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 				c.Writer.Header().Set("Access-Control-Max-Age", maxAge.String())
 
@@ -0,0 +1,237 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	httputil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/http-util"
+)
+
+/**
+ * IdempotencyKey is a middleware that makes a request safe to retry when it carries an `Idempotency-Key` header.
+ * The first request with a key reserves it together with a hash of the request, and its response is stored.
+ * A retry with the same key and the same request gets the stored response back, with the same message ID,
+ * instead of sending the message again. Reusing the key for a different request returns 409 Conflict,
+ * and so does a retry while the first request is still in progress.
+ * A 5xx response is not stored, the key is released so the client can retry with it.
+ * The first request holds the key for IDEMPOTENCY_LEASE_MS, 5 minutes by default, so a key whose request
+ * never completed, e.g. because the process stopped, can be used again once the lease expires.
+ * The lease is well above the longest publish, the write timeout times the attempts, about 30 seconds.
+ * If a request still outlives it and a retry reserves the key again, the reservation token keeps
+ * the first request from storing its response over the retry's, or from releasing the retry's key.
+ * A replayed response carries the request ID of the retry, not the one of the first request.
+ * Keys expire after IDEMPOTENCY_TTL_MS, 24 hours by default. Requests without the header are not affected.
+ */
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	HeaderReplayed       = "Idempotent-Replayed"
+
+	defaultTTL    = 24 * time.Hour
+	defaultLease  = 5 * time.Minute
+	maxKeyLength  = 255
+	replayContent = "application/json; charset=utf-8"
+
+	// requestIDField is the field of the request ID in the response bodies
+	requestIDField = "request_id"
+)
+
+// Store keeps the idempotency records, repository.IdempotencyRepository implements it.
+type Store interface {
+	// Reserve stores the record if its key is unused or reusable, and returns nil.
+	// Otherwise it stores nothing and returns the existing record.
+	Reserve(ctx context.Context, record *entity.IdempotencyRecord) (*entity.IdempotencyRecord, error)
+	// Complete stores the response of the request that reserved the key with the token.
+	// It stores nothing and returns repository.ErrIdempotencyKeyNotHeld if the key is reserved with another token.
+	Complete(ctx context.Context, key string, token string, statusCode int, responseBody []byte) error
+	// Delete releases the key reserved with the token, so the request can be retried with it.
+	// It leaves the key as is if it is reserved with another token.
+	Delete(ctx context.Context, key string, token string) error
+}
+
+// responseRecorder keeps a copy of the response body, so it can be stored.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func IdempotencyKey(store Store) gin.HandlerFunc {
+	ttl, lease := GetTTL(), GetLease()
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderIdempotencyKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			httputil.BadRequest(c, "Invalid Idempotency-Key", fmt.Sprintf("The Idempotency-Key header must not be longer than %d characters", maxKeyLength))
+			c.Abort()
+			return
+		}
+
+		// Read the body to hash it, and put it back for the handler
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			httputil.BadRequest(c, "Invalid request body", "Failed to read the request body")
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		record := &entity.IdempotencyRecord{
+			Key:            key,
+			Token:          uuid.New().String(),
+			RequestHash:    hashRequest(c.Request.Method, c.Request.URL.Path, body),
+			CreatedAt:      now,
+			ExpiresAt:      now.Add(ttl),
+			LeaseExpiresAt: now.Add(lease),
+		}
+
+		ctx := c.Request.Context()
+		existing, err := store.Reserve(ctx, record)
+		if err != nil {
+			httputil.InternalServerError(c, "Failed to check Idempotency-Key", err.Error())
+			c.Abort()
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				httputil.Conflict(c, "Idempotency-Key already used", "The Idempotency-Key was already used for a different request")
+			case existing.InProgress():
+				httputil.Conflict(c, "Request in progress", "A request with the same Idempotency-Key is still in progress")
+			default:
+				// Same key and same request, return the stored response
+				c.Header(HeaderReplayed, "true")
+				c.Data(existing.StatusCode, replayContent, withRequestID(existing.ResponseBody, httputil.RequestID(c)))
+			}
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		// Release the key if the handler panics, otherwise it stays in progress until its lease expires
+		defer func() {
+			if r := recover(); r != nil {
+				release(ctx, store, record)
+				panic(r)
+			}
+		}()
+
+		c.Next()
+
+		// A request that failed on the server side, e.g. because the broker was unavailable, can be retried with the same key
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			release(ctx, store, record)
+			return
+		}
+
+		// Without the stored response a retry could not be answered, release the key instead of leaving it in progress
+		err = store.Complete(ctx, key, record.Token, c.Writer.Status(), recorder.body.Bytes())
+		switch {
+		case errors.Is(err, repository.ErrIdempotencyKeyNotHeld):
+			// The lease expired and a retry holds the key now, its response is the one stored
+			logger.WarnContext(ctx, "Idempotency-Key lease expired before the response was stored", logrus.Fields{"idempotency_key": key})
+		case err != nil:
+			logger.ErrorContext(ctx, "Failed to store response for Idempotency-Key", logrus.Fields{"idempotency_key": key, logger.FieldError: err.Error()})
+			release(ctx, store, record)
+		}
+	}
+}
+
+// release deletes the key reserved with the record, so the request can be retried with it.
+func release(ctx context.Context, store Store, record *entity.IdempotencyRecord) {
+	if err := store.Delete(ctx, record.Key, record.Token); err != nil {
+		logger.ErrorContext(ctx, "Failed to release Idempotency-Key", logrus.Fields{"idempotency_key": record.Key, logger.FieldError: err.Error()})
+	}
+}
+
+// withRequestID returns the stored response body with the request ID of the retry,
+// so the body agrees with the X-Request-ID header and the logs of the retry.
+// Bodies that are not JSON objects or have no request ID are returned as they are.
+func withRequestID(body []byte, requestID string) []byte {
+	var fields map[string]json.RawMessage
+	if requestID == "" || json.Unmarshal(body, &fields) != nil {
+		return body
+	}
+	if _, exists := fields[requestIDField]; !exists {
+		return body
+	}
+
+	id, err := json.Marshal(requestID)
+	if err != nil {
+		return body
+	}
+	fields[requestIDField] = id
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+
+	return data
+}
+
+// GetTTL returns how long an idempotency key is kept, from IDEMPOTENCY_TTL_MS.
+func GetTTL() time.Duration {
+	ms, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL_MS"))
+	if err != nil || ms <= 0 {
+		return defaultTTL
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// GetLease returns how long a request in progress holds its idempotency key, from IDEMPOTENCY_LEASE_MS.
+func GetLease() time.Duration {
+	ms, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_LEASE_MS"))
+	if err != nil || ms <= 0 {
+		return defaultLease
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// hashRequest hashes the method, the path and the body, so a key cannot be reused for a different request.
+func hashRequest(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
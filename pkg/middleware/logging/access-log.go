@@ -0,0 +1,206 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	httputil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/http-util"
+)
+
+/**
+ * AccessLog is a middleware that writes one entry per request to the access log, logger.RequestLogger.
+ * Each entry carries the method, path, route, status, latency, client IP and response size,
+ * the request ID set by the requestid middleware, and the IDs of the messages the request produced,
+ * which the handlers report with SetMessageIDs.
+ * With ACCESS_LOG_BODY the JSON request body is logged too, with the values of the ACCESS_LOG_REDACT_FIELDS
+ * masked, e.g., the content of the messages. Bodies that are not JSON are never logged, as they cannot be masked.
+ * High-volume routes can be sampled with ACCESS_LOG_SAMPLE_RATES, failed requests are always logged.
+ */
+
+const (
+	// messageIDsKey is the key of the produced message IDs in the gin context
+	messageIDsKey = "access_log_message_ids"
+
+	redactedValue       = "[REDACTED]"
+	defaultRedactFields = "message"
+	defaultMaxBodySize  = 4096
+)
+
+type Config struct {
+	LogBody      bool               // Whether the request body is logged
+	RedactFields []string           // JSON fields whose values are masked in the logged body, at any depth
+	MaxBodySize  int                // Maximum number of bytes of the logged body, the rest is cut off
+	SampleRates  map[string]float64 // Share of the successful requests logged per route pattern, 1 if not set
+}
+
+// LoadConfig reads the access log configuration from the environment variables, using defaults for the unset ones.
+func LoadConfig() Config {
+	config := Config{
+		LogBody:      strings.EqualFold(os.Getenv("ACCESS_LOG_BODY"), "true"),
+		RedactFields: splitList(defaultRedactFields),
+		MaxBodySize:  defaultMaxBodySize,
+		SampleRates:  map[string]float64{},
+	}
+
+	if fields, ok := os.LookupEnv("ACCESS_LOG_REDACT_FIELDS"); ok {
+		config.RedactFields = splitList(fields)
+	}
+
+	if size, err := strconv.Atoi(os.Getenv("ACCESS_LOG_MAX_BODY_SIZE")); err == nil && size > 0 {
+		config.MaxBodySize = size
+	}
+
+	// Sample rates are given as route=rate pairs, e.g., /api/send-message=0.1,/metrics=0
+	for _, pair := range splitList(os.Getenv("ACCESS_LOG_SAMPLE_RATES")) {
+		route, rateStr, found := strings.Cut(pair, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateStr), 64)
+		if !found || err != nil || rate < 0 || rate > 1 {
+			logger.Warn("Invalid ACCESS_LOG_SAMPLE_RATES value", logrus.Fields{"value": pair})
+			continue
+		}
+		config.SampleRates[strings.TrimSpace(route)] = rate
+	}
+
+	return config
+}
+
+// SetMessageIDs records the IDs of the messages produced by the request, so they are logged with it.
+func SetMessageIDs(c *gin.Context, ids ...string) {
+	c.Set(messageIDsKey, ids)
+}
+
+func AccessLog(config Config) gin.HandlerFunc {
+	redact := make(map[string]bool, len(config.RedactFields))
+	for _, field := range config.RedactFields {
+		redact[field] = true
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		// Read the body to log it, and put it back for the handler
+		var body []byte
+		if config.LogBody && c.Request.Body != nil {
+			body, _ = io.ReadAll(c.Request.Body)
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < http.StatusBadRequest && !sampled(config.SampleRates, c.FullPath()) {
+			return
+		}
+
+		fields := logrus.Fields{
+			"method":        c.Request.Method,
+			"path":          c.Request.URL.Path,
+			"route":         c.FullPath(),
+			"status":        status,
+			"latency_ms":    time.Since(start).Milliseconds(),
+			"client_ip":     c.ClientIP(),
+			"response_size": max(c.Writer.Size(), 0),
+		}
+
+		if requestID := httputil.RequestID(c); requestID != "" {
+			fields[logger.FieldRequestID] = requestID
+		}
+
+		if ids := c.GetStringSlice(messageIDsKey); len(ids) == 1 {
+			fields[logger.FieldMessageID] = ids[0]
+		} else if len(ids) > 1 {
+			fields["message_ids"] = ids
+		}
+
+		if len(body) > 0 {
+			if redacted, ok := redactBody(body, redact, config.MaxBodySize); ok {
+				fields["body"] = redacted
+			}
+		}
+
+		entry := logger.GetRequestLogger().WithFields(fields)
+		switch {
+		case status >= http.StatusInternalServerError:
+			entry.Error("Request handled")
+		case status >= http.StatusBadRequest:
+			entry.Warn("Request handled")
+		default:
+			entry.Info("Request handled")
+		}
+	}
+}
+
+// sampled reports whether a successful request to the route is logged.
+func sampled(rates map[string]float64, route string) bool {
+	rate, ok := rates[route]
+	if !ok || rate >= 1 {
+		return true
+	}
+
+	return rand.Float64() < rate
+}
+
+// redactBody returns the JSON body with the values of the redacted fields masked, cut off at maxSize bytes.
+// It returns false if the body is not JSON.
+func redactBody(body []byte, redact map[string]bool, maxSize int) (string, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", false
+	}
+
+	data, err := json.Marshal(redactValue(value, redact))
+	if err != nil {
+		return "", false
+	}
+
+	if len(data) > maxSize {
+		data = data[:maxSize]
+	}
+
+	return string(data), true
+}
+
+func redactValue(value interface{}, redact map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if redact[key] {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redactValue(field, redact)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item, redact)
+		}
+	}
+
+	return value
+}
+
+// splitList splits a comma-separated list, dropping the empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,62 @@
+package requestid
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	httputil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/http-util"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+/**
+ * RequestID is a middleware that gives every request an ID, so the client, the logs and the consumers agree on it.
+ * The ID is read from the `X-Request-ID` header, or generated if the header is missing or not a valid ID,
+ * and sent back in the `X-Request-ID` response header and in the body of every httputil response.
+ * It is stored on the gin context, and on the request context as the correlation ID of the published events
+ * and as the request_id log field, so the consumer that handles the events logs the same ID.
+ */
+
+const (
+	HeaderRequestID = "X-Request-ID"
+
+	maxRequestIDLength = 128
+)
+
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(HeaderRequestID)
+		if !isValid(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		c.Set(httputil.ContextKeyRequestID, requestID)
+		c.Header(HeaderRequestID, requestID)
+
+		ctx := kafkautil.WithCorrelationID(c.Request.Context(), requestID)
+		ctx = logger.WithFields(ctx, logrus.Fields{logger.FieldRequestID: requestID})
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// isValid reports whether the request ID sent by the client can be used as is.
+// Only short IDs of letters, digits and - _ . : are accepted, so the ID cannot forge log lines or headers.
+func isValid(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, r := range requestID {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
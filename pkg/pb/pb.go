@@ -0,0 +1,5 @@
+// Package pb holds the Go code generated from the protobuf definitions in /proto.
+// Run `make proto` after changing them.
+package pb
+
+//go:generate protoc --proto_path=../../proto --go_out=. --go_opt=paths=source_relative envelope.proto message.proto
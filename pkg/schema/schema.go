@@ -0,0 +1,11 @@
+// Package schema holds the Avro schemas of the event payloads, registered in the schema registry at startup.
+package schema
+
+import (
+	_ "embed"
+)
+
+// MessageAvro is the Avro schema of entity.Message, the payload of the sending-message event.
+//
+//go:embed message.avsc
+var MessageAvro string
@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HeaderCarrier adapts Kafka message headers to a propagation.TextMapCarrier.
+type HeaderCarrier struct {
+	Headers *[]kafka.Header
+}
+
+func (c HeaderCarrier) Get(key string) string {
+	for _, h := range *c.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+
+	return ""
+}
+
+// Set replaces the header if it is already set, so a re-published message carries only the latest trace context.
+func (c HeaderCarrier) Set(key string, value string) {
+	for i, h := range *c.Headers {
+		if h.Key == key {
+			(*c.Headers)[i].Value = []byte(value)
+			return
+		}
+	}
+
+	*c.Headers = append(*c.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.Headers))
+	for _, h := range *c.Headers {
+		keys = append(keys, h.Key)
+	}
+
+	return keys
+}
+
+// InjectHeaders returns the headers with the trace context of ctx added.
+func InjectHeaders(ctx context.Context, headers []kafka.Header) []kafka.Header {
+	otel.GetTextMapPropagator().Inject(ctx, HeaderCarrier{Headers: &headers})
+	return headers
+}
+
+// ExtractHeaders returns ctx with the trace context carried by the headers.
+func ExtractHeaders(ctx context.Context, headers []kafka.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, HeaderCarrier{Headers: &headers})
+}
+
+// StartPublish starts the span of publishing messages to the topic.
+func StartPublish(ctx context.Context, topic string, count int) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "publish "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingBatchMessageCount(count),
+		),
+	)
+}
+
+// StartProcess starts the span of handling a consumed message,
+// as a child of the span that published it if the message carries its trace context.
+func StartProcess(ctx context.Context, worker string, msg kafka.Message) (context.Context, trace.Span) {
+	ctx = ExtractHeaders(ctx, msg.Headers)
+
+	return Tracer().Start(ctx, "process "+msg.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(msg.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+			semconv.MessagingKafkaMessageKey(string(msg.Key)),
+			semconv.MessagingClientID(worker),
+		),
+	)
+}
+
+// End records the error on the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
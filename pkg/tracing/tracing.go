@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+)
+
+/**
+ * tracing package sets up OpenTelemetry tracing, so one trace covers a message from the HTTP request
+ * that sent it, through Kafka, to the consumer that handled it.
+ * The W3C trace context is propagated in the HTTP headers and in the Kafka message headers (traceparent, tracestate).
+ * Spans are exported with the exporter selected by OTEL_TRACES_EXPORTER: none (default), stdout or otlp.
+ * The OTLP exporter is configured with the standard OTEL_EXPORTER_OTLP_* variables, and sampling with OTEL_TRACES_SAMPLER.
+ * Without an exporter no spans are recorded, but the trace context of incoming requests and messages is still passed on.
+ */
+
+const (
+	ExporterNone   = "none"   // Do not export spans
+	ExporterStdout = "stdout" // Print spans to stdout as JSON
+	ExporterOTLP   = "otlp"   // Send spans to an OTLP collector over HTTP
+
+	tracerName = "github.com/yoanesber/go-kafka-messaging-demo"
+)
+
+func init() {
+	// Propagate the W3C trace context and baggage, whether spans are exported or not
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Init sets up the tracer provider with the exporter selected by OTEL_TRACES_EXPORTER.
+// The returned function flushes the pending spans and stops the exporter, it must be called on shutdown.
+func Init(ctx context.Context) (func(ctx context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", ExporterNone:
+		return func(ctx context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER value: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", os.Getenv("OTEL_TRACES_EXPORTER"), err)
+	}
+
+	return SetExporter(exporter, true), nil
+}
+
+// SetExporter sets up the tracer provider with the exporter, and returns the function that shuts it down.
+// Spans are exported in batches, or one by one as they end if batch is false,
+// e.g., with the in-memory exporter of go.opentelemetry.io/otel/sdk/trace/tracetest in tests.
+func SetExporter(exporter sdktrace.SpanExporter, batch bool) func(ctx context.Context) error {
+	export := sdktrace.WithSyncer(exporter)
+	if batch {
+		export = sdktrace.WithBatcher(exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(export, sdktrace.WithResource(newResource()))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown
+}
+
+// Tracer returns the tracer of the application.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// newResource describes this service, OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+func newResource() *resource.Resource {
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName(async.GetKafkaProducerName())),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		logger.Warn("Failed to detect trace resource attributes", logrus.Fields{logger.FieldError: err.Error()})
+	}
+
+	return res
+}
@@ -10,12 +10,21 @@ import (
 
 // ErrorResponse represents the structure of an error response.
 type HttpResponse struct {
-	Message   string    `json:"message"`   // A user-friendly error message
-	Error     any       `json:"error"`     // The actual error message (optional)
-	Path      string    `json:"path"`      // The request path that caused the error (optional)
-	Status    int       `json:"status"`    // HTTP status code (optional)
-	Data      any       `json:"data"`      // Additional data related to the error (optional)
-	Timestamp time.Time `json:"timestamp"` // The timestamp when the error occurred (optional)
+	Message   string    `json:"message"`              // A user-friendly error message
+	Error     any       `json:"error"`                // The actual error message (optional)
+	Path      string    `json:"path"`                 // The request path that caused the error (optional)
+	Status    int       `json:"status"`               // HTTP status code (optional)
+	Data      any       `json:"data"`                 // Additional data related to the error (optional)
+	Timestamp time.Time `json:"timestamp"`            // The timestamp when the error occurred (optional)
+	RequestID string    `json:"request_id,omitempty"` // The ID of the request, set by the requestid middleware
+}
+
+// ContextKeyRequestID is the key of the request ID in the gin context
+const ContextKeyRequestID = "request_id"
+
+// RequestID returns the ID of the request, or an empty string if the requestid middleware is not used.
+func RequestID(c *gin.Context) string {
+	return c.GetString(ContextKeyRequestID)
 }
 
 /***** Basic Responses *****/
@@ -29,6 +38,7 @@ func Created(c *gin.Context, message string, data interface{}) {
 		Status:    http.StatusCreated,
 		Data:      data,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
@@ -42,13 +52,14 @@ func Success(c *gin.Context, message string, data interface{}) {
 		Status:    http.StatusOK,
 		Data:      data,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // BadRequest sends a 400 Bad Request response.
 // It is typically used when the request cannot be processed due to client error.
 func BadRequest(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusBadRequest, HttpResponse{
 		Message:   message,
@@ -57,13 +68,14 @@ func BadRequest(c *gin.Context, message string, err string) {
 		Status:    http.StatusBadRequest,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // NotFound sends a 404 Not Found response.
 // It is typically used when the requested resource cannot be found.
 func NotFound(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusNotFound, HttpResponse{
 		Message:   message,
@@ -72,13 +84,14 @@ func NotFound(c *gin.Context, message string, err string) {
 		Status:    http.StatusNotFound,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // InternalServerError sends a 500 Internal Server Error response.
 // It is typically used when an unexpected error occurs on the server.
 func InternalServerError(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusInternalServerError, HttpResponse{
 		Message:   message,
@@ -87,13 +100,14 @@ func InternalServerError(c *gin.Context, message string, err string) {
 		Status:    http.StatusInternalServerError,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // Unauthorized sends a 401 Unauthorized response.
 // It is typically used when authentication is required but has failed or has not been provided.
 func Unauthorized(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusUnauthorized, HttpResponse{
 		Message:   message,
@@ -102,13 +116,14 @@ func Unauthorized(c *gin.Context, message string, err string) {
 		Status:    http.StatusUnauthorized,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // Forbidden sends a 403 Forbidden response.
 // It is typically used when the server understands the request but refuses to authorize it.
 func Forbidden(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusForbidden, HttpResponse{
 		Message:   message,
@@ -117,13 +132,14 @@ func Forbidden(c *gin.Context, message string, err string) {
 		Status:    http.StatusForbidden,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // UnsupportedMediaType sends a 415 Unsupported Media Type response.
 // It is typically used when the server refuses to accept the request because the payload format is invalid.
 func UnsupportedMediaType(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusUnsupportedMediaType, HttpResponse{
 		Message:   message,
@@ -132,13 +148,14 @@ func UnsupportedMediaType(c *gin.Context, message string, err string) {
 		Status:    http.StatusUnsupportedMediaType,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // MethodNotAllowed sends a 405 Method Not Allowed response.
 // It is typically used when the HTTP method used in the request is not allowed for the requested resource.
 func MethodNotAllowed(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusMethodNotAllowed, HttpResponse{
 		Message:   message,
@@ -147,13 +164,14 @@ func MethodNotAllowed(c *gin.Context, message string, err string) {
 		Status:    http.StatusMethodNotAllowed,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // Conflict sends a 409 Conflict response.
 // It is typically used when a request could not be completed due to a conflict with the current state of the resource.
 func Conflict(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusConflict, HttpResponse{
 		Message:   message,
@@ -162,13 +180,14 @@ func Conflict(c *gin.Context, message string, err string) {
 		Status:    http.StatusConflict,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // TooManyRequests sends a 429 Too Many Requests response.
 // It is typically used when the user has sent too many requests in a given amount of time.
 func TooManyRequests(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusTooManyRequests, HttpResponse{
 		Message:   message,
@@ -177,13 +196,14 @@ func TooManyRequests(c *gin.Context, message string, err string) {
 		Status:    http.StatusTooManyRequests,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 // NoContent sends a 204 No Content response.
 // It is typically used when the server successfully processes the request but does not need to return any content.
 func NoContent(c *gin.Context, message string, err string) {
-	logger.Error(err, nil)
+	logger.ErrorContext(c.Request.Context(), err, nil)
 
 	c.JSON(http.StatusNoContent, HttpResponse{
 		Message:   message,
@@ -192,12 +212,13 @@ func NoContent(c *gin.Context, message string, err string) {
 		Status:    http.StatusNoContent,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 /***** Map Responses *****/
 func BadRequestMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("Bad Request Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "Bad Request Map Error", nil)
 
 	c.JSON(http.StatusBadRequest, HttpResponse{
 		Message:   message,
@@ -206,11 +227,12 @@ func BadRequestMap(c *gin.Context, message string, err []map[string]string) {
 		Status:    http.StatusBadRequest,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 func NotFoundMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("Not Found Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "Not Found Map Error", nil)
 
 	c.JSON(http.StatusNotFound, HttpResponse{
 		Message:   message,
@@ -219,11 +241,12 @@ func NotFoundMap(c *gin.Context, message string, err []map[string]string) {
 		Status:    http.StatusNotFound,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 func InternalServerErrorMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("Internal Server Error Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "Internal Server Error Map Error", nil)
 
 	c.JSON(http.StatusInternalServerError, HttpResponse{
 		Message:   message,
@@ -232,11 +255,12 @@ func InternalServerErrorMap(c *gin.Context, message string, err []map[string]str
 		Status:    http.StatusInternalServerError,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 func UnauthorizedMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("Unauthorized Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "Unauthorized Map Error", nil)
 
 	c.JSON(http.StatusUnauthorized, HttpResponse{
 		Message:   message,
@@ -245,11 +269,12 @@ func UnauthorizedMap(c *gin.Context, message string, err []map[string]string) {
 		Status:    http.StatusUnauthorized,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 func ForbiddenMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("Forbidden Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "Forbidden Map Error", nil)
 
 	c.JSON(http.StatusForbidden, HttpResponse{
 		Message:   message,
@@ -258,11 +283,12 @@ func ForbiddenMap(c *gin.Context, message string, err []map[string]string) {
 		Status:    http.StatusForbidden,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 func UnsupportedMediaTypeMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("Unsupported Media Type Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "Unsupported Media Type Map Error", nil)
 
 	c.JSON(http.StatusUnsupportedMediaType, HttpResponse{
 		Message:   message,
@@ -271,11 +297,12 @@ func UnsupportedMediaTypeMap(c *gin.Context, message string, err []map[string]st
 		Status:    http.StatusUnsupportedMediaType,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 func MethodNotAllowedMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("Method Not Allowed Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "Method Not Allowed Map Error", nil)
 
 	c.JSON(http.StatusMethodNotAllowed, HttpResponse{
 		Message:   message,
@@ -284,11 +311,12 @@ func MethodNotAllowedMap(c *gin.Context, message string, err []map[string]string
 		Status:    http.StatusMethodNotAllowed,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 func ConflictMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("Conflict Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "Conflict Map Error", nil)
 
 	c.JSON(http.StatusConflict, HttpResponse{
 		Message:   message,
@@ -297,11 +325,12 @@ func ConflictMap(c *gin.Context, message string, err []map[string]string) {
 		Status:    http.StatusConflict,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 func TooManyRequestsMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("Too Many Requests Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "Too Many Requests Map Error", nil)
 
 	c.JSON(http.StatusTooManyRequests, HttpResponse{
 		Message:   message,
@@ -310,11 +339,12 @@ func TooManyRequestsMap(c *gin.Context, message string, err []map[string]string)
 		Status:    http.StatusTooManyRequests,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
 
 func NoContentMap(c *gin.Context, message string, err []map[string]string) {
-	logger.Error("No Content Map Error", nil)
+	logger.ErrorContext(c.Request.Context(), "No Content Map Error", nil)
 
 	c.JSON(http.StatusNoContent, HttpResponse{
 		Message:   message,
@@ -323,5 +353,6 @@ func NoContentMap(c *gin.Context, message string, err []map[string]string) {
 		Status:    http.StatusNoContent,
 		Data:      nil,
 		Timestamp: time.Now(),
+		RequestID: RequestID(c),
 	})
 }
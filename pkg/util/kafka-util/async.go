@@ -0,0 +1,92 @@
+package kafka_util
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/metrics"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/tracing"
+)
+
+// DeliveryFunc is called with each batch of messages an asynchronous writer wrote,
+// err is set if the batch could not be written.
+type DeliveryFunc func(msgs []kafka.Message, err error)
+
+var (
+	deliveryMu       sync.RWMutex
+	deliveryCallback DeliveryFunc
+)
+
+// OnDelivery sets the callback for the delivery results of PublishMessageAsync and PublishMessagesAsync.
+// It must be called before the first message is published.
+func OnDelivery(callback DeliveryFunc) {
+	deliveryMu.Lock()
+	defer deliveryMu.Unlock()
+
+	deliveryCallback = callback
+}
+
+// PublishMessageAsync queues the message for the topic and returns without waiting for it to be written.
+// The delivery result is passed to the OnDelivery callback.
+func PublishMessageAsync(ctx context.Context, topic string, key string, value interface{}, headers ...kafka.Header) (err error) {
+	// The span ends once the message is queued, the delivery is reported by the writer stats
+	ctx, span := tracing.StartPublish(ctx, topic, 1)
+	defer func() { tracing.End(span, err) }()
+
+	msg, err := NewMessage(ctx, topic, key, value, headers...)
+	if err != nil {
+		return err
+	}
+
+	return PublishMessagesAsync(topic, msg)
+}
+
+// PublishMessagesAsync queues the messages for the topic and returns without waiting for them to be written.
+// The writer batches them by KAFKA_WRITER_BATCH_SIZE, KAFKA_WRITER_BATCH_BYTES and KAFKA_WRITER_BATCH_TIMEOUT_MS,
+// and passes the delivery result of each batch to the OnDelivery callback.
+func PublishMessagesAsync(topic string, msgs ...kafka.Message) error {
+	writer, err := getAsyncWriter(topic, deliver)
+	if err != nil {
+		logger.Error("Failed to get async Kafka writer", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
+		return &PublishError{Topic: topic, Retryable: false, Err: fmt.Errorf("%w: %v", ErrWriterNotFound, err)}
+	}
+
+	// An asynchronous writer only fails here if it is closed or the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitTime)
+	defer cancel()
+
+	if err := writer.WriteMessages(ctx, msgs...); err != nil {
+		logger.Error("Failed to queue messages", logrus.Fields{logger.FieldTopic: topic, "count": len(msgs), logger.FieldError: err.Error()})
+		return &PublishError{Topic: topic, Retryable: isRetryableWriteError(err), Err: err}
+	}
+
+	return nil
+}
+
+// deliver passes the delivery result of an asynchronous writer to the OnDelivery callback.
+func deliver(msgs []kafka.Message, err error) {
+	if len(msgs) > 0 {
+		metrics.PublishAttempt(msgs[0].Topic, false)
+		if err != nil {
+			metrics.PublishFailed(msgs[0].Topic)
+		}
+	}
+
+	deliveryMu.RLock()
+	callback := deliveryCallback
+	deliveryMu.RUnlock()
+
+	if callback == nil {
+		if err != nil {
+			logger.Error("Failed to deliver messages", logrus.Fields{"count": len(msgs), logger.FieldError: err.Error()})
+		}
+		return
+	}
+
+	callback(msgs, err)
+}
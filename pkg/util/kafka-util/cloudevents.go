@@ -0,0 +1,195 @@
+package kafka_util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+)
+
+/**
+ * CloudEvents 1.0 support, following the Kafka protocol binding of the spec.
+ * In binary mode the event attributes go to ce_ headers and the value is the event data.
+ * In structured mode the value is the whole event as JSON, with content type application/cloudevents+json.
+ * The event type is the type prefix followed by our event type, e.g., com.github.yoanesber.messaging.sending-message,
+ * and the event id is the ID of the message. Messages in the legacy envelope are still read in both modes.
+ */
+
+const (
+	CloudEventsSpecVersion     = "1.0"
+	ContentTypeCloudEventsJSON = "application/cloudevents+json"
+
+	// Headers of a CloudEvent in binary mode
+	HeaderCloudEventsPrefix        = "ce_"
+	HeaderCloudEventsSpecVersion   = "ce_specversion"
+	HeaderCloudEventsID            = "ce_id"
+	HeaderCloudEventsSource        = "ce_source"
+	HeaderCloudEventsType          = "ce_type"
+	HeaderCloudEventsTime          = "ce_time"
+	HeaderCloudEventsSchemaVersion = "ce_schemaversion"
+)
+
+// CloudEventSource is implemented by events that can be published as CloudEvents.
+type CloudEventSource interface {
+	Event
+	CloudEventID() string
+	CloudEventData() interface{}
+}
+
+// CloudEvent is a CloudEvent in structured mode.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	SchemaVersion   int             `json:"schemaversion,omitempty"` // Extension attribute, the version of the data
+	Data            json.RawMessage `json:"data,omitempty"`          // Data encoded with JSON
+	DataBase64      []byte          `json:"data_base64,omitempty"`   // Data encoded with another codec
+}
+
+// DecodedEvent is a consumed event whose payload has not been decoded yet.
+type DecodedEvent struct {
+	EventType   string
+	Version     int
+	Payload     []byte
+	ContentType string // Content type of the payload, to pick the codec it is decoded with
+}
+
+// legacyEnvelope is the format events were published in before CloudEvents.
+type legacyEnvelope struct {
+	EventType string          `json:"event_type"`
+	Version   int             `json:"version"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// CloudEventType returns the CloudEvents type name of the event type.
+func CloudEventType(eventType string) string {
+	return async.GetKafkaCloudEventsTypePrefix() + eventType
+}
+
+// EventTypeFromCloudEventType returns the event type of the CloudEvents type name.
+// Types without our prefix, from other producers, are returned unchanged.
+func EventTypeFromCloudEventType(ceType string) string {
+	return strings.TrimPrefix(ceType, async.GetKafkaCloudEventsTypePrefix())
+}
+
+// encodeCloudEvent encodes the event in the given CloudEvents mode, with its data encoded by the codec.
+// It returns the value and the headers to add to the message.
+func encodeCloudEvent(codec Codec, event CloudEventSource, mode string, now time.Time) ([]byte, []kafka.Header, error) {
+	data, err := codec.Marshal(event.CloudEventData())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	eventType, version := event.EventMetadata()
+	ce := CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              event.CloudEventID(),
+		Source:          "/" + async.GetKafkaProducerName(),
+		Type:            CloudEventType(eventType),
+		Time:            now,
+		DataContentType: codec.ContentType(),
+		SchemaVersion:   version,
+	}
+
+	if mode == async.EventFormatStructured {
+		// The JSON event format carries data that is not JSON as base64
+		if codec.ContentType() == ContentTypeJSON {
+			ce.Data = data
+		} else {
+			ce.DataBase64 = data
+		}
+
+		value, err := json.Marshal(ce)
+		if err != nil {
+			return nil, nil, err
+		}
+		return value, []kafka.Header{{Key: HeaderContentType, Value: []byte(ContentTypeCloudEventsJSON)}}, nil
+	}
+
+	return data, []kafka.Header{
+		{Key: HeaderCloudEventsSpecVersion, Value: []byte(ce.SpecVersion)},
+		{Key: HeaderCloudEventsID, Value: []byte(ce.ID)},
+		{Key: HeaderCloudEventsSource, Value: []byte(ce.Source)},
+		{Key: HeaderCloudEventsType, Value: []byte(ce.Type)},
+		{Key: HeaderCloudEventsTime, Value: []byte(ce.Time.Format(time.RFC3339Nano))},
+		{Key: HeaderCloudEventsSchemaVersion, Value: []byte(strconv.Itoa(ce.SchemaVersion))},
+	}, nil
+}
+
+// isBinaryCloudEvent reports whether the message is a CloudEvent in binary mode.
+func isBinaryCloudEvent(msg kafka.Message) bool {
+	_, ok := headerValue(msg, HeaderCloudEventsSpecVersion)
+	return ok
+}
+
+// isStructuredCloudEvent reports whether the message is a CloudEvent in structured mode.
+func isStructuredCloudEvent(msg kafka.Message) bool {
+	contentType, _ := headerValue(msg, HeaderContentType)
+	return strings.HasPrefix(contentType, ContentTypeCloudEventsJSON)
+}
+
+// DecodeEvent decodes the event type, version and payload of the message,
+// whether it is a CloudEvent in binary or structured mode, or in the legacy envelope.
+func DecodeEvent(msg kafka.Message) (*DecodedEvent, error) {
+	contentType, _ := headerValue(msg, HeaderContentType)
+
+	switch {
+	case isBinaryCloudEvent(msg):
+		eventType, version, _ := EventType(msg)
+		return &DecodedEvent{EventType: eventType, Version: version, Payload: msg.Value, ContentType: contentType}, nil
+	case isStructuredCloudEvent(msg):
+		var ce CloudEvent
+		if err := json.Unmarshal(msg.Value, &ce); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cloud event: %w", err)
+		}
+
+		payload := []byte(ce.Data)
+		if ce.DataBase64 != nil {
+			payload = ce.DataBase64
+		}
+		return &DecodedEvent{EventType: EventTypeFromCloudEventType(ce.Type), Version: ce.SchemaVersion, Payload: payload, ContentType: ce.DataContentType}, nil
+	default:
+		codec, err := CodecForContentType(contentType)
+		if err != nil {
+			return nil, err
+		}
+
+		// Avro values are the payload alone, see encodeEnvelope
+		if codec.ContentType() == ContentTypeAvro {
+			eventType, version, ok := EventType(msg)
+			if !ok {
+				return nil, fmt.Errorf("avro message without %s header", HeaderEventType)
+			}
+			return &DecodedEvent{EventType: eventType, Version: version, Payload: msg.Value, ContentType: ContentTypeAvro}, nil
+		}
+
+		eventType, version, payload, err := decodeEnvelope(codec, msg.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &DecodedEvent{EventType: eventType, Version: version, Payload: payload, ContentType: codec.ContentType()}, nil
+	}
+}
+
+// EventPayload decodes only the payload of the message and its content type, see DecodeEvent.
+func EventPayload(msg kafka.Message) ([]byte, string, error) {
+	if isBinaryCloudEvent(msg) {
+		contentType, _ := headerValue(msg, HeaderContentType)
+		return msg.Value, contentType, nil
+	}
+
+	event, err := DecodeEvent(msg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return event.Payload, event.ContentType, nil
+}
@@ -0,0 +1,186 @@
+package kafka_util
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+
+	schemaregistry "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/schema-registry"
+)
+
+/**
+ * avroCodec encodes values with Avro in the Confluent wire format: a zero magic byte,
+ * the 4 byte big-endian ID of the schema in the registry, then the Avro binary data.
+ * Values are encoded with the latest schema of the subject, resolved once, and decoded with the schema
+ * of the ID they carry, so consumers read values written with any registered version.
+ * Struct fields are matched to the schema fields by their json tag, so entities need no extra tags.
+ * The value is the payload only, the event type and version are read from the headers.
+ */
+
+const (
+	ContentTypeAvro = "application/avro"
+
+	avroMagicByte  = 0
+	avroHeaderSize = 5 // Magic byte and schema ID
+)
+
+var (
+	// ErrNoSchemaRegistry is returned when the Avro codec is used before SetSchemaRegistry was called
+	ErrNoSchemaRegistry = errors.New("no schema registry configured")
+
+	avroAPI = avro.Config{TagKey: "json"}.Freeze()
+
+	schemaRegistryMu sync.RWMutex
+	schemaRegistry   schemaregistry.SchemaRegistry
+	avroCodecs       = make(map[string]*avroCodec) // Codec by subject
+)
+
+// SetSchemaRegistry sets the registry the Avro codecs resolve schemas with.
+// It must be called before the first message is published or consumed with Avro.
+func SetSchemaRegistry(registry schemaregistry.SchemaRegistry) {
+	schemaRegistryMu.Lock()
+	defer schemaRegistryMu.Unlock()
+
+	schemaRegistry = registry
+	avroCodecs = make(map[string]*avroCodec)
+}
+
+// AvroSubject returns the subject of the values of the topic, following the Confluent topic name strategy.
+func AvroSubject(topic string) string {
+	return topic + "-value"
+}
+
+type avroCodec struct {
+	registry schemaregistry.SchemaRegistry
+	subject  string // Subject whose latest schema values are encoded with, empty for a codec that only decodes
+
+	mu       sync.RWMutex
+	latestID int
+	latest   avro.Schema
+	schemas  map[int]avro.Schema // Schema by ID, to decode
+}
+
+// NewAvroCodec returns an Avro codec that encodes with the latest schema of the subject.
+func NewAvroCodec(registry schemaregistry.SchemaRegistry, subject string) Codec {
+	return &avroCodec{
+		registry: registry,
+		subject:  subject,
+		schemas:  make(map[int]avro.Schema),
+	}
+}
+
+// avroCodecFor returns the shared Avro codec of the subject.
+func avroCodecFor(subject string) Codec {
+	schemaRegistryMu.Lock()
+	defer schemaRegistryMu.Unlock()
+
+	if codec, exists := avroCodecs[subject]; exists {
+		return codec
+	}
+
+	codec := NewAvroCodec(schemaRegistry, subject).(*avroCodec)
+	avroCodecs[subject] = codec
+	return codec
+}
+
+func (c *avroCodec) ContentType() string {
+	return ContentTypeAvro
+}
+
+func (c *avroCodec) Marshal(v interface{}) ([]byte, error) {
+	id, schema, err := c.latestSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := avroAPI.Marshal(schema, v)
+	if err != nil {
+		return nil, err
+	}
+
+	value := make([]byte, avroHeaderSize, avroHeaderSize+len(data))
+	value[0] = avroMagicByte
+	binary.BigEndian.PutUint32(value[1:avroHeaderSize], uint32(id))
+	return append(value, data...), nil
+}
+
+func (c *avroCodec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) < avroHeaderSize || data[0] != avroMagicByte {
+		return fmt.Errorf("value is not in the Confluent wire format")
+	}
+
+	schema, err := c.schemaByID(int(binary.BigEndian.Uint32(data[1:avroHeaderSize])))
+	if err != nil {
+		return err
+	}
+
+	return avroAPI.Unmarshal(schema, data[avroHeaderSize:], v)
+}
+
+// latestSchema returns the latest schema of the subject, resolving it on first use.
+func (c *avroCodec) latestSchema() (int, avro.Schema, error) {
+	c.mu.RLock()
+	id, schema := c.latestID, c.latest
+	c.mu.RUnlock()
+	if schema != nil {
+		return id, schema, nil
+	}
+
+	if c.registry == nil {
+		return 0, nil, ErrNoSchemaRegistry
+	}
+	if c.subject == "" {
+		return 0, nil, fmt.Errorf("avro codec without subject cannot encode")
+	}
+
+	id, raw, err := c.registry.GetLatest(context.Background(), c.subject)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get latest schema of %s: %w", c.subject, err)
+	}
+
+	schema, err = schemaregistry.Parse(raw)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to parse schema %d: %w", id, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.latestID, c.latest = id, schema
+	c.schemas[id] = schema
+	return id, schema, nil
+}
+
+// schemaByID returns the schema with the ID, resolving it on first use.
+func (c *avroCodec) schemaByID(id int) (avro.Schema, error) {
+	c.mu.RLock()
+	schema, cached := c.schemas[id]
+	c.mu.RUnlock()
+	if cached {
+		return schema, nil
+	}
+
+	if c.registry == nil {
+		return nil, ErrNoSchemaRegistry
+	}
+
+	raw, err := c.registry.GetByID(context.Background(), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schema %d: %w", id, err)
+	}
+
+	schema, err = schemaregistry.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %d: %w", id, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.schemas[id] = schema
+	return schema, nil
+}
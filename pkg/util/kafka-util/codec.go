@@ -0,0 +1,159 @@
+package kafka_util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/pb"
+)
+
+/**
+ * Codec encodes the values of published messages and decodes the consumed ones.
+ * The codec is picked per topic by KAFKA_CODEC and KAFKA_TOPIC_CODECS, and the content-type header
+ * tells the consumer which codec to decode the message with, so topics can move from one codec to the other.
+ * JSON is the default. Protobuf encodes values generated from the definitions in /proto,
+ * and types that convert to and from one of them through ProtoMarshaler and ProtoUnmarshaler.
+ * Avro encodes values in the Confluent wire format with the schemas of the schema registry.
+ */
+
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+type Codec interface {
+	// ContentType returns the content type of the encoded values, set in the content-type header.
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// ProtoMarshaler is implemented by types that are not generated from a .proto file, but have a protobuf form.
+type ProtoMarshaler interface {
+	ToProto() proto.Message
+}
+
+// ProtoUnmarshaler is implemented by types that can be set from their protobuf form.
+type ProtoUnmarshaler interface {
+	NewProto() proto.Message
+	FromProto(m proto.Message) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Marshal(m)
+	case ProtoMarshaler:
+		return proto.Marshal(m.ToProto())
+	default:
+		return nil, fmt.Errorf("cannot encode %T with protobuf", v)
+	}
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	case ProtoUnmarshaler:
+		pm := m.NewProto()
+		if err := proto.Unmarshal(data, pm); err != nil {
+			return err
+		}
+		return m.FromProto(pm)
+	default:
+		return fmt.Errorf("cannot decode %T with protobuf", v)
+	}
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+// CodecForTopic returns the codec the values published to the topic are encoded with.
+func CodecForTopic(topic string) Codec {
+	switch async.GetKafkaCodec(topic) {
+	case async.CodecProtobuf:
+		return ProtobufCodec
+	case async.CodecAvro:
+		return avroCodecFor(AvroSubject(topic))
+	default:
+		return JSONCodec
+	}
+}
+
+// CodecForContentType returns the codec to decode values of the content type with.
+// Values without a content type are JSON, as they were published before the header existed.
+func CodecForContentType(contentType string) (Codec, error) {
+	// Ignore parameters like charset
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(mediaType) {
+	case "", ContentTypeJSON:
+		return JSONCodec, nil
+	case ContentTypeProtobuf:
+		return ProtobufCodec, nil
+	case ContentTypeAvro:
+		// The schema ID in the value tells which schema to decode with, the subject does not matter
+		return avroCodecFor(""), nil
+	default:
+		return nil, fmt.Errorf("unsupported content type: %s", contentType)
+	}
+}
+
+// encodeEnvelope encodes the event in the legacy envelope with the codec.
+// Avro values are the payload alone, as Confluent consumers expect, the event type and version are in the headers.
+func encodeEnvelope(codec Codec, eventType string, version int, data interface{}) ([]byte, error) {
+	payload, err := codec.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch codec.ContentType() {
+	case ContentTypeProtobuf:
+		return proto.Marshal(&pb.EventEnvelope{EventType: eventType, Version: int32(version), Payload: payload})
+	case ContentTypeAvro:
+		return payload, nil
+	default:
+		return json.Marshal(legacyEnvelope{EventType: eventType, Version: version, Payload: payload})
+	}
+}
+
+// decodeEnvelope decodes the legacy envelope encoded with the codec.
+func decodeEnvelope(codec Codec, data []byte) (string, int, []byte, error) {
+	if codec.ContentType() == ContentTypeProtobuf {
+		var envelope pb.EventEnvelope
+		if err := proto.Unmarshal(data, &envelope); err != nil {
+			return "", 0, nil, fmt.Errorf("failed to unmarshal message value: %w", err)
+		}
+		return envelope.EventType, int(envelope.Version), envelope.Payload, nil
+	}
+
+	var envelope legacyEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return "", 0, nil, fmt.Errorf("failed to unmarshal message value: %w", err)
+	}
+	return envelope.EventType, envelope.Version, envelope.Payload, nil
+}
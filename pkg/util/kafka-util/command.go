@@ -2,87 +2,262 @@ package kafka_util
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
 
 	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/metrics"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/tracing"
 )
 
 const (
 	retries      = 3
 	maxWaitTime  = 10 * time.Second       // Maximum time to wait for the message to be written
 	maxSleepTime = 250 * time.Millisecond // Maximum time to wait before retrying
+
+	forwardRetryBackoff = time.Second // Time to wait before forwarding a failed message again
 )
 
-func PublishMessage(topic string, key string, value interface{}) error {
-	// Get the Kafka writer for the specified topic
-	writer, err := async.GetKafkaWriter(topic)
+func PublishMessage(ctx context.Context, topic string, key string, value interface{}, headers ...kafka.Header) (err error) {
+	// The message carries the context of the publish span, so its consumer joins the trace
+	ctx, span := tracing.StartPublish(ctx, topic, 1)
+	defer func() { tracing.End(span, err) }()
+
+	// Create a new message
+	msg, err := NewMessage(ctx, topic, key, value, headers...)
 	if err != nil {
-		fmt.Printf("failed to get Kafka writer for topic %s: %v\n", topic, err)
 		return err
 	}
 
-	// Marshal the value to JSON
-	valueBytes, err := json.Marshal(value)
+	return PublishMessages(topic, msg)
+}
+
+// PublishMessages writes the messages to the topic with a single WriteMessages call.
+// If some of them could not be written, the error wraps a kafka.WriteErrors with the error of each message,
+// in the order of msgs and nil for the written ones.
+func PublishMessages(topic string, msgs ...kafka.Message) error {
+	// Get the Kafka writer for the specified topic
+	writer, err := getWriter(topic)
 	if err != nil {
-		return fmt.Errorf("failed to marshal value: %w", err)
+		logger.Error("Failed to get Kafka writer", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
+		return &PublishError{Topic: topic, Retryable: false, Err: fmt.Errorf("%w: %v", ErrWriterNotFound, err)}
 	}
 
-	// Create a new message
-	msg := kafka.Message{
-		Key:   []byte(key),
-		Value: valueBytes,
-		Time:  time.Now(),
+	return writeMessages(writer, topic, msgs...)
+}
+
+// NewMessage builds the Kafka message that PublishMessage would write to the topic,
+// for callers that store it to publish later, like the outbox.
+// The value is encoded with the codec of the topic.
+// The message gets the standard headers: content type, producer, correlation ID and timestamp,
+// and the event type and schema version if value is an Event. They are followed by the given headers,
+// and by the trace context of ctx (traceparent), so the consumer continues the trace.
+// Events that can be published as CloudEvents are, when KAFKA_EVENT_FORMAT is binary or structured.
+func NewMessage(ctx context.Context, topic string, key string, value interface{}, headers ...kafka.Header) (kafka.Message, error) {
+	now := time.Now()
+	codec := CodecForTopic(topic)
+	format := async.GetKafkaEventFormat()
+
+	var valueBytes []byte
+	var err error
+	event, isEvent := value.(CloudEventSource)
+	switch {
+	case isEvent && format != async.EventFormatLegacy:
+		var ceHeaders []kafka.Header
+		valueBytes, ceHeaders, err = encodeCloudEvent(codec, event, format, now)
+		headers = append(ceHeaders, headers...)
+	case isEvent:
+		eventType, version := event.EventMetadata()
+		valueBytes, err = encodeEnvelope(codec, eventType, version, event.CloudEventData())
+	default:
+		valueBytes, err = codec.Marshal(value)
+	}
+	if err != nil {
+		return kafka.Message{}, &PublishError{Topic: topic, Retryable: false, Err: fmt.Errorf("%w: %v", ErrMarshalFailed, err)}
+	}
+
+	return kafka.Message{
+		Key:     []byte(key),
+		Value:   valueBytes,
+		Headers: tracing.InjectHeaders(ctx, standardHeaders(ctx, value, codec.ContentType(), now, headers)),
+		Time:    now,
+	}, nil
+}
+
+// writeMessages writes the messages to the topic,
+// retrying a few times in case of transient errors.
+// Only the messages that failed with a transient error are written again, so the written ones are not duplicated.
+// If some messages could not be written, the error wraps a kafka.WriteErrors with the last error of each message.
+func writeMessages(writer Writer, topic string, msgs ...kafka.Message) error {
+	results := make(kafka.WriteErrors, len(msgs)) // Last error of each message, nil once it is written
+	pending := make([]int, len(msgs))             // Positions of the messages to write in the next attempt
+	for i := range pending {
+		pending[i] = i
 	}
 
-	// Try to write the message to the topic
-	// We will retry a few times in case of transient errors
-	for range retries {
+	for attempt := 0; attempt < retries && len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(maxSleepTime)
+		}
+		metrics.PublishAttempt(topic, attempt > 0)
+
+		batch := make([]kafka.Message, len(pending))
+		for j, i := range pending {
+			batch[j] = msgs[i]
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), maxWaitTime)
-		defer cancel()
+		err := writer.WriteMessages(ctx, batch...)
+		cancel()
 
-		// attempt to create topic prior to publishing the message
-		err = writer.WriteMessages(ctx, msg)
-		if err != nil {
-			if errors.Is(err, kafka.LeaderNotAvailable) || errors.Is(err, context.DeadlineExceeded) {
-				time.Sleep(maxSleepTime)
-				continue
+		// The writer reports an error per message when some partitions failed, or one error for the whole batch
+		var writeErrs kafka.WriteErrors
+		perMessage := errors.As(err, &writeErrs) && len(writeErrs) == len(pending)
+
+		retry := make([]int, 0, len(pending))
+		for j, i := range pending {
+			results[i] = err
+			if perMessage {
+				results[i] = writeErrs[j]
 			}
 
-			fmt.Printf("failed to write message to topic %s: %v\n", topic, err)
+			if results[i] != nil && isRetryableWriteError(results[i]) {
+				retry = append(retry, i)
+			}
 		}
-		break
+		pending = retry
+	}
+
+	if results.Count() > 0 {
+		metrics.PublishFailed(topic)
+		logger.Error("Failed to write messages", logrus.Fields{logger.FieldTopic: topic, "count": len(msgs), "failed": results.Count(), logger.FieldError: results.Error()})
+		return &PublishError{Topic: topic, Retryable: isRetryableWriteError(results), Err: results}
 	}
 
 	return nil
 }
 
-func ConsumeMessages(workerID int, topic string, handler func(string, kafka.Message) error) {
+// ConsumeMessages reads messages from the topic and calls the handler for each of them until ctx is cancelled.
+// A message that is already being handled is finished (and committed in manual mode) before it returns.
+func ConsumeMessages(ctx context.Context, workerID int, topic string, handler HandlerFunc) {
 	// Get the Kafka reader for the specified topic
 	reader, err := async.GetKafkaReader(topic)
 	if err != nil {
-		fmt.Printf("failed to get Kafka reader for topic %s: %v\n", topic, err)
+		logger.Error("Failed to get Kafka reader", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
 		return
 	}
 
-	for {
-		worker := fmt.Sprintf("Worker-%d", workerID)
+	worker := fmt.Sprintf("Worker-%d", workerID)
+	defer logger.Info("Worker stopped consuming", logrus.Fields{logger.FieldWorker: worker, logger.FieldTopic: topic})
+
+	// The in-flight message is finished on shutdown, so the handler must not see ctx being cancelled
+	handlerCtx := logger.WithFields(context.WithoutCancel(ctx), logrus.Fields{logger.FieldWorker: worker})
 
+	if async.GetKafkaCommitMode(topic) == async.CommitModeManual {
+		consumeWithManualCommit(ctx, handlerCtx, worker, topic, reader, handler)
+		return
+	}
+
+	for {
 		// Read messages from the topic
-		msg, err := reader.ReadMessage(context.Background())
+		// The reader commits the offset in the background, before the handler runs
+		msg, err := reader.ReadMessage(ctx)
 		if err != nil {
-			fmt.Printf("failed to read message from topic %s: %v\n", topic, err)
+			if ctx.Err() != nil {
+				return
+			}
+
+			logger.Error("Failed to read message", logrus.Fields{logger.FieldWorker: worker, logger.FieldTopic: topic, logger.FieldError: err.Error()})
 			continue
 		}
+		metrics.ObserveLag(msg)
 
 		// Call the handler function with the received message
-		if err := handler(worker, msg); err != nil {
-			fmt.Printf("failed to handle message from topic %s: %v\n", topic, err)
+		// Retry topics are always committed manually (see async.GetKafkaCommitMode), so no message here waits for a delay
+		// The offset is already committed, so a message that cannot be forwarded is not tried again
+		handleMessage(ctx, handlerCtx, worker, msg, handler, false)
+	}
+}
+
+// consumeWithManualCommit fetches messages without committing them,
+// and commits each offset only once the message has been handled or forwarded (at-least-once).
+func consumeWithManualCommit(ctx context.Context, handlerCtx context.Context, worker string, topic string, reader *kafka.Reader, handler HandlerFunc) {
+	batchSize, interval := async.GetKafkaCommitBatch()
+	committer := newOffsetCommitter(reader, batchSize, interval)
+
+	// Commit whatever was handled before stopping
+	defer func() {
+		if err := committer.Flush(); err != nil {
+			logger.Error("Failed to commit offsets", logrus.Fields{logger.FieldWorker: worker, logger.FieldTopic: topic, logger.FieldError: err.Error()})
+		}
+	}()
+
+	for {
+		// While offsets are pending, stop waiting for new messages when they are due
+		// so a quiet topic does not hold back the commit
+		fetchCtx, cancel := ctx, context.CancelFunc(func() {})
+		if committer.Pending() {
+			fetchCtx, cancel = context.WithTimeout(ctx, committer.Due())
+		}
+
+		msg, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if errors.Is(err, context.DeadlineExceeded) {
+				if err := committer.Flush(); err != nil {
+					logger.Error("Failed to commit offsets", logrus.Fields{logger.FieldWorker: worker, logger.FieldTopic: topic, logger.FieldError: err.Error()})
+				}
+				continue
+			}
+
+			logger.Error("Failed to fetch message", logrus.Fields{logger.FieldWorker: worker, logger.FieldTopic: topic, logger.FieldError: err.Error()})
 			continue
 		}
+		metrics.ObserveLag(msg)
+
+		// Messages from a retry topic are only re-delivered once their delay has passed
+		// The offset is not committed, so it is fetched again after a restart
+		if !waitForRetry(ctx, msg) {
+			return
+		}
+
+		// Call the handler function with the received message
+		// If it fails, keep trying to forward it, committing its offset before it got there would lose it
+		if !handleMessage(ctx, handlerCtx, worker, msg, handler, true) {
+			return
+		}
+
+		if err := committer.Add(msg); err != nil {
+			logger.Error("Failed to commit offsets", messageFields(msg, err))
+		}
 	}
 }
+
+// messageFields returns the log fields of the message, and of err if it is not nil.
+func messageFields(msg kafka.Message, err error) logrus.Fields {
+	fields := logrus.Fields{
+		logger.FieldTopic:     msg.Topic,
+		logger.FieldPartition: msg.Partition,
+		logger.FieldOffset:    msg.Offset,
+	}
+	if eventID, ok := EventID(msg); ok {
+		fields[logger.FieldMessageID] = eventID
+	}
+	if correlationID, ok := MessageCorrelationID(msg); ok {
+		fields[logger.FieldRequestID] = correlationID
+	}
+	if err != nil {
+		fields[logger.FieldError] = err.Error()
+	}
+
+	return fields
+}
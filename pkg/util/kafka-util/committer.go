@@ -0,0 +1,69 @@
+package kafka_util
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// offsetCommitter batches the offsets of handled messages and commits them together,
+// either when the batch is full or when the oldest pending offset has waited long enough.
+type offsetCommitter struct {
+	reader    *kafka.Reader
+	batchSize int
+	interval  time.Duration
+
+	pending []kafka.Message
+	since   time.Time // when the oldest pending message was added
+}
+
+func newOffsetCommitter(reader *kafka.Reader, batchSize int, interval time.Duration) *offsetCommitter {
+	return &offsetCommitter{
+		reader:    reader,
+		batchSize: batchSize,
+		interval:  interval,
+		pending:   make([]kafka.Message, 0, batchSize),
+	}
+}
+
+// Add marks the message as handled and commits the batch if it is due.
+func (c *offsetCommitter) Add(msg kafka.Message) error {
+	if len(c.pending) == 0 {
+		c.since = time.Now()
+	}
+	c.pending = append(c.pending, msg)
+
+	if len(c.pending) >= c.batchSize || time.Since(c.since) >= c.interval {
+		return c.Flush()
+	}
+
+	return nil
+}
+
+// Flush commits all pending offsets.
+func (c *offsetCommitter) Flush() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitTime)
+	defer cancel()
+
+	if err := c.reader.CommitMessages(ctx, c.pending...); err != nil {
+		return err
+	}
+
+	c.pending = c.pending[:0]
+	return nil
+}
+
+// Pending reports whether there are offsets waiting to be committed.
+func (c *offsetCommitter) Pending() bool {
+	return len(c.pending) > 0
+}
+
+// Due returns how long until the pending offsets should be committed.
+func (c *offsetCommitter) Due() time.Duration {
+	return max(c.interval-time.Since(c.since), 0)
+}
@@ -0,0 +1,63 @@
+package kafka_util
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+)
+
+const (
+	// Headers added to every message sent to the dead-letter topic,
+	// describing where the message came from and why it failed
+	HeaderDLQOriginalTopic     = "x-original-topic"
+	HeaderDLQOriginalPartition = "x-original-partition"
+	HeaderDLQOriginalOffset    = "x-original-offset"
+	HeaderDLQError             = "x-error"
+	HeaderDLQWorkerID          = "x-worker-id"
+	HeaderDLQFailedAt          = "x-failed-at"
+)
+
+// PublishDeadLetter sends a message that could not be handled to the dead-letter topic.
+// The original key, value and headers are kept as is, and the failure details are added as headers.
+func PublishDeadLetter(worker string, msg kafka.Message, handleErr error) error {
+	topic := async.GetKafkaDLQTopic()
+
+	// Get the Kafka writer for the dead-letter topic
+	writer, err := getWriter(topic)
+	if err != nil {
+		return &PublishError{Topic: topic, Retryable: false, Err: fmt.Errorf("%w: %v", ErrWriterNotFound, err)}
+	}
+
+	headers := make([]kafka.Header, 0, len(msg.Headers)+6)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: HeaderDLQOriginalTopic, Value: []byte(msg.Topic)},
+		kafka.Header{Key: HeaderDLQOriginalPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: HeaderDLQOriginalOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: HeaderDLQError, Value: []byte(handleErr.Error())},
+		kafka.Header{Key: HeaderDLQWorkerID, Value: []byte(worker)},
+		kafka.Header{Key: HeaderDLQFailedAt, Value: []byte(time.Now().Format(time.RFC3339Nano))},
+	)
+
+	dlqMsg := kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    time.Now(),
+	}
+
+	if err := writeMessages(writer, topic, dlqMsg); err != nil {
+		return err
+	}
+
+	fields := messageFields(msg, handleErr)
+	fields[logger.FieldWorker] = worker
+	fields["dead_letter_topic"] = topic
+	logger.Warn("Message sent to dead-letter topic", fields)
+	return nil
+}
@@ -0,0 +1,92 @@
+package kafka_util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	// ErrWriterNotFound is returned when there is no Kafka writer configured for the topic
+	ErrWriterNotFound = errors.New("kafka writer not found")
+	// ErrMarshalFailed is returned when the message value cannot be serialized
+	ErrMarshalFailed = errors.New("failed to marshal message value")
+)
+
+// PublishError describes why a message could not be published to a topic.
+// Retryable reports whether the failure is transient (e.g., leader election, timeout),
+// so the caller may try again later, or permanent and retrying will not help.
+type PublishError struct {
+	Topic     string
+	Retryable bool
+	Err       error
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("failed to publish message to topic %s: %v", e.Topic, e.Err)
+}
+
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// RetryableError marks a handler error as transient,
+// so the consumer re-delivers the message through the retry topics instead of dead-lettering it.
+type RetryableError struct {
+	Err error
+}
+
+func NewRetryableError(err error) error {
+	return &RetryableError{Err: err}
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is a transient failure worth retrying.
+func IsRetryable(err error) bool {
+	var re *RetryableError
+	if errors.As(err, &re) {
+		return true
+	}
+
+	var pe *PublishError
+	if errors.As(err, &pe) {
+		return pe.Retryable
+	}
+
+	return false
+}
+
+// isRetryableWriteError reports whether an error returned by kafka.Writer.WriteMessages is transient.
+func isRetryableWriteError(err error) bool {
+	if errors.Is(err, kafka.LeaderNotAvailable) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	// WriteMessages reports per-message errors when writing a batch
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, writeErr := range writeErrs {
+			if writeErr != nil && !isRetryableWriteError(writeErr) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Kafka protocol errors know whether they are temporary
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		return kafkaErr.Temporary()
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+package kafka_util
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+)
+
+const (
+	// HeaderEventID identifies the event, it is the same for every delivery of the event
+	// so the consumer can recognize a redelivered one
+	HeaderEventID = "x-event-id"
+
+	// Headers added to every published message, so consumers can route it without decoding the value
+	HeaderEventType     = "x-event-type"
+	HeaderSchemaVersion = "x-schema-version"
+	HeaderContentType   = "content-type"
+	HeaderProducer      = "x-producer"
+	HeaderCorrelationID = "x-correlation-id"
+	HeaderTimestamp     = "x-timestamp"
+
+	ContentTypeJSON = "application/json"
+)
+
+// Event is implemented by values published as events,
+// NewMessage puts their type and schema version in the headers.
+type Event interface {
+	EventMetadata() (eventType string, version int)
+}
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a copy of ctx carrying the correlation ID,
+// NewMessage puts it in the headers of the messages built with it.
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, correlationID)
+}
+
+// CorrelationID returns the correlation ID carried by ctx, or false if there is none.
+func CorrelationID(ctx context.Context) (string, bool) {
+	correlationID, ok := ctx.Value(correlationIDKey{}).(string)
+	return correlationID, ok && correlationID != ""
+}
+
+// MessageCorrelationID returns the correlation ID in the headers of the message, or false if there is none.
+// For the events published while handling an HTTP request, it is the ID of the request.
+func MessageCorrelationID(msg kafka.Message) (string, bool) {
+	correlationID, ok := headerValue(msg, HeaderCorrelationID)
+	return correlationID, ok && correlationID != ""
+}
+
+// EventIDHeader returns the header that identifies the event.
+func EventIDHeader(eventID string) kafka.Header {
+	return kafka.Header{Key: HeaderEventID, Value: []byte(eventID)}
+}
+
+// EventID returns the ID of the event, or false if the message was published without one.
+// The ce_id header of a CloudEvent in binary mode is used if there is no x-event-id header.
+func EventID(msg kafka.Message) (string, bool) {
+	if eventID, ok := headerValue(msg, HeaderEventID); ok {
+		return eventID, true
+	}
+
+	return headerValue(msg, HeaderCloudEventsID)
+}
+
+// EventType returns the event type and schema version from the headers,
+// or false if the message was published without them.
+// The ce_ headers of a CloudEvent in binary mode are used if there is no x-event-type header.
+func EventType(msg kafka.Message) (string, int, bool) {
+	// A missing or invalid version is left to the caller to default
+	if eventType, ok := headerValue(msg, HeaderEventType); ok && eventType != "" {
+		version, _ := strconv.Atoi(headerValueOrEmpty(msg, HeaderSchemaVersion))
+		return eventType, version, true
+	}
+
+	if ceType, ok := headerValue(msg, HeaderCloudEventsType); ok && ceType != "" {
+		version, _ := strconv.Atoi(headerValueOrEmpty(msg, HeaderCloudEventsSchemaVersion))
+		return EventTypeFromCloudEventType(ceType), version, true
+	}
+
+	return "", 0, false
+}
+
+// standardHeaders returns the headers added to every published message, followed by the given ones.
+// A given header replaces the standard header with the same key.
+func standardHeaders(ctx context.Context, value interface{}, contentType string, now time.Time, headers []kafka.Header) []kafka.Header {
+	given := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		given[h.Key] = true
+	}
+
+	correlationID, ok := CorrelationID(ctx)
+	if !ok {
+		// Without a correlation ID from the caller, the event starts its own chain
+		correlationID = uuid.New().String()
+	}
+
+	standard := []kafka.Header{
+		{Key: HeaderContentType, Value: []byte(contentType)},
+		{Key: HeaderProducer, Value: []byte(async.GetKafkaProducerName())},
+		{Key: HeaderCorrelationID, Value: []byte(correlationID)},
+		{Key: HeaderTimestamp, Value: []byte(now.Format(time.RFC3339Nano))},
+	}
+	if event, ok := value.(Event); ok {
+		eventType, version := event.EventMetadata()
+		standard = append(standard,
+			kafka.Header{Key: HeaderEventType, Value: []byte(eventType)},
+			kafka.Header{Key: HeaderSchemaVersion, Value: []byte(strconv.Itoa(version))},
+		)
+	}
+
+	result := make([]kafka.Header, 0, len(standard)+len(headers))
+	for _, h := range standard {
+		if !given[h.Key] {
+			result = append(result, h)
+		}
+	}
+
+	return append(result, headers...)
+}
+
+func headerValueOrEmpty(msg kafka.Message, key string) string {
+	value, _ := headerValue(msg, key)
+	return value
+}
@@ -0,0 +1,238 @@
+package kafka_util
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/metrics"
+)
+
+const (
+	keyedQueueSize = 64 // Messages buffered per worker before the reader waits
+)
+
+/**
+ * ConsumeMessagesByKey reads the topic with a single reader and fans the messages out to a pool of workers.
+ * Messages are sharded by key, so messages with the same key are always handled by the same worker, in order,
+ * while messages with different keys are handled in parallel by up to numWorkers workers.
+ * Because messages complete out of order, the offset of a partition is only committed
+ * up to the lowest message that is not handled yet (at-least-once).
+ */
+
+func ConsumeMessagesByKey(ctx context.Context, topic string, numWorkers int, handler HandlerFunc) {
+	// Get the Kafka reader for the specified topic
+	reader, err := async.GetKafkaReader(topic)
+	if err != nil {
+		logger.Error("Failed to get Kafka reader", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
+		return
+	}
+
+	// The in-flight messages are finished on shutdown, so the handler must not see ctx being cancelled
+	handlerCtx := context.WithoutCancel(ctx)
+
+	tracker := NewOffsetTracker()
+	completed := make(chan trackedMessage, numWorkers*keyedQueueSize)
+
+	// Commit the offsets of completed messages in the background
+	committerDone := make(chan struct{})
+	go func() {
+		defer close(committerDone)
+		commitCompleted(topic, reader, tracker, completed)
+	}()
+
+	// Start one worker per shard, each handling its messages one at a time
+	var workersWG sync.WaitGroup
+	queues := make([]chan trackedMessage, numWorkers)
+	for i := range queues {
+		queues[i] = make(chan trackedMessage, keyedQueueSize)
+
+		workersWG.Add(1)
+		go func(worker string, queue <-chan trackedMessage) {
+			defer workersWG.Done()
+			handleQueue(ctx, logger.WithFields(handlerCtx, logrus.Fields{logger.FieldWorker: worker}), worker, queue, handler, completed)
+		}(fmt.Sprintf("Worker-%d", i), queues[i])
+	}
+
+	// Fetch messages and dispatch them to the worker that owns their key
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			logger.Error("Failed to fetch message", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
+			continue
+		}
+		metrics.ObserveLag(msg)
+
+		generation := tracker.Track(msg)
+
+		select {
+		case queues[shardOf(msg.Key, numWorkers)] <- trackedMessage{Message: msg, generation: generation}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// Stop the workers after their in-flight message, then commit what they completed
+	for _, queue := range queues {
+		close(queue)
+	}
+	workersWG.Wait()
+	close(completed)
+	<-committerDone
+
+	logger.Info("Keyed consumer stopped consuming", logrus.Fields{logger.FieldTopic: topic})
+}
+
+// handleQueue handles the messages of one shard in order, until the queue is closed or ctx is cancelled.
+// Queued messages that were not started are left uncommitted, so they are fetched again after a restart.
+func handleQueue(ctx context.Context, handlerCtx context.Context, worker string, queue <-chan trackedMessage, handler HandlerFunc, completed chan<- trackedMessage) {
+	for tracked := range queue {
+		msg := tracked.Message
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Messages from a retry topic are only re-delivered once their delay has passed
+		if !waitForRetry(ctx, msg) {
+			return
+		}
+
+		// Call the handler function with the received message
+		// If it fails, keep trying to forward it, committing its offset before it got there would lose it
+		if !handleMessage(ctx, handlerCtx, worker, msg, handler, true) {
+			return
+		}
+
+		completed <- tracked
+	}
+}
+
+// commitCompleted marks messages as completed as they come in,
+// and commits the offsets that are no longer held back by an earlier message.
+func commitCompleted(topic string, reader *kafka.Reader, tracker *OffsetTracker, completed <-chan trackedMessage) {
+	batchSize, interval := async.GetKafkaCommitBatch()
+	committer := newOffsetCommitter(reader, batchSize, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-completed:
+			if !ok {
+				if err := committer.Flush(); err != nil {
+					logger.Error("Failed to commit offsets", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
+				}
+				return
+			}
+
+			if commit, ok := tracker.Done(msg.Message, msg.generation); ok {
+				if err := committer.Add(commit); err != nil {
+					logger.Error("Failed to commit offsets", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
+				}
+			}
+		case <-ticker.C:
+			if committer.Pending() && committer.Due() == 0 {
+				if err := committer.Flush(); err != nil {
+					logger.Error("Failed to commit offsets", logrus.Fields{logger.FieldTopic: topic, logger.FieldError: err.Error()})
+				}
+			}
+		}
+	}
+}
+
+// shardOf returns the worker that owns the key.
+func shardOf(key []byte, numWorkers int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(numWorkers))
+}
+
+// trackedMessage is a fetched message with the generation of its partition, see OffsetTracker.Track.
+type trackedMessage struct {
+	kafka.Message
+	generation int
+}
+
+// OffsetTracker keeps the fetched offsets of each partition in order,
+// to find the highest offset below which every message has completed.
+type OffsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	offsets    []int64        // Fetched offsets that are not committable yet, in fetch order
+	done       map[int64]bool // Offsets that completed out of order
+	last       int64          // Highest fetched offset
+	generation int            // Incremented each time the partition is fetched again from an earlier offset
+	topic      string
+}
+
+func NewOffsetTracker() *OffsetTracker {
+	return &OffsetTracker{
+		partitions: make(map[int]*partitionOffsets),
+	}
+}
+
+// Track records that the message was fetched and is being handled, and returns the generation to pass to Done.
+// After a rebalance the reader fetches a partition again from its committed offset. The offsets tracked before
+// are dropped then, and the messages fetched before complete in an older generation which commits nothing,
+// as they are handled again in the new one.
+func (t *OffsetTracker) Track(msg kafka.Message) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, exists := t.partitions[msg.Partition]
+	if !exists {
+		p = &partitionOffsets{done: make(map[int64]bool), topic: msg.Topic}
+		t.partitions[msg.Partition] = p
+	} else if msg.Offset <= p.last {
+		p.offsets = nil
+		p.done = make(map[int64]bool)
+		p.generation++
+	}
+	p.offsets = append(p.offsets, msg.Offset)
+	p.last = msg.Offset
+
+	return p.generation
+}
+
+// Done records that the message of the generation completed. If that makes a higher offset committable,
+// it returns a message carrying that offset, to be passed to kafka.Reader.CommitMessages.
+func (t *OffsetTracker) Done(msg kafka.Message, generation int) (kafka.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, exists := t.partitions[msg.Partition]
+	if !exists || generation != p.generation {
+		return kafka.Message{}, false
+	}
+	p.done[msg.Offset] = true
+
+	// Advance over every completed offset at the front
+	committable, advanced := int64(0), false
+	for len(p.offsets) > 0 && p.done[p.offsets[0]] {
+		committable, advanced = p.offsets[0], true
+		delete(p.done, p.offsets[0])
+		p.offsets = p.offsets[1:]
+	}
+	if !advanced {
+		return kafka.Message{}, false
+	}
+
+	return kafka.Message{Topic: p.topic, Partition: msg.Partition, Offset: committable}, true
+}
@@ -0,0 +1,23 @@
+package kafka_util
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// HandlerFunc handles a message read by a consumer worker.
+type HandlerFunc func(ctx context.Context, worker string, msg kafka.Message) error
+
+// Middleware wraps a HandlerFunc with cross-cutting behavior, such as logging or panic recovery.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain wraps the handler with the middlewares.
+// The first middleware is the outermost one, so it runs first and sees the final result, like gin's Use.
+func Chain(handler HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
@@ -0,0 +1,169 @@
+package kafka_util
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+)
+
+const (
+	// Headers added to messages re-published to a retry topic
+	HeaderRetryAttempt       = "x-retry-attempt"
+	HeaderRetryOriginalTopic = "x-retry-original-topic"
+	HeaderRetryNotBefore     = "x-retry-not-before"
+)
+
+// handleMessage calls the handler with the message. If it fails, the message is forwarded
+// to a retry topic or the dead-letter topic so it is not lost. The failure itself is logged
+// by the logging middleware the handler is wrapped in.
+// With untilForwarded, forwarding is tried again until it succeeds, for consumers that commit the offset
+// once handleMessage returns; it returns false if ctx is cancelled before the message got there.
+func handleMessage(ctx context.Context, handlerCtx context.Context, worker string, msg kafka.Message, handler HandlerFunc, untilForwarded bool) bool {
+	err := handler(handlerCtx, worker, msg)
+	if err == nil {
+		return true
+	}
+
+	for fwdErr := handleFailure(worker, msg, err); fwdErr != nil; fwdErr = handleFailure(worker, msg, err) {
+		logger.ErrorContext(handlerCtx, "Failed to forward message", messageFields(msg, fwdErr))
+		if !untilForwarded {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(forwardRetryBackoff):
+		}
+	}
+
+	return true
+}
+
+// handleFailure decides what happens to a message whose handler returned an error.
+// Retryable errors move the message to the next retry tier of its original topic,
+// anything else, or a message that already went through the last tier, goes to the dead-letter topic.
+func handleFailure(worker string, msg kafka.Message, handleErr error) error {
+	if !IsRetryable(handleErr) {
+		return PublishDeadLetter(worker, msg, handleErr)
+	}
+
+	attempt := RetryAttempt(msg)
+	tiers := async.GetKafkaRetryTiers(OriginalTopic(msg))
+	if attempt >= len(tiers) {
+		return PublishDeadLetter(worker, msg, fmt.Errorf("retries exhausted after %d attempts: %w", attempt, handleErr))
+	}
+
+	return publishRetry(msg, tiers[attempt], attempt+1)
+}
+
+// publishRetry re-publishes the message to the retry tier with an increased attempt counter.
+func publishRetry(msg kafka.Message, tier async.RetryTier, attempt int) error {
+	writer, err := getWriter(tier.Topic)
+	if err != nil {
+		return &PublishError{Topic: tier.Topic, Retryable: false, Err: fmt.Errorf("%w: %v", ErrWriterNotFound, err)}
+	}
+
+	// Keep the original headers, but replace the retry headers of a previous attempt
+	headers := make([]kafka.Header, 0, len(msg.Headers)+3)
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case HeaderRetryAttempt, HeaderRetryOriginalTopic, HeaderRetryNotBefore:
+			continue
+		}
+		headers = append(headers, h)
+	}
+	headers = append(headers,
+		kafka.Header{Key: HeaderRetryAttempt, Value: []byte(strconv.Itoa(attempt))},
+		kafka.Header{Key: HeaderRetryOriginalTopic, Value: []byte(OriginalTopic(msg))},
+		kafka.Header{Key: HeaderRetryNotBefore, Value: []byte(time.Now().Add(tier.Delay).Format(time.RFC3339Nano))},
+	)
+
+	retryMsg := kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    time.Now(),
+	}
+
+	if err := writeMessages(writer, tier.Topic, retryMsg); err != nil {
+		return err
+	}
+
+	fields := messageFields(msg, nil)
+	fields["attempt"] = attempt
+	fields["retry_topic"] = tier.Topic
+	logger.Warn("Message scheduled for retry", fields)
+	return nil
+}
+
+// waitForRetry blocks until the message is due, if it was re-published to a retry topic.
+// Messages in a retry topic share the same delay, so waiting on one never delays a message that is due earlier.
+// It returns false if ctx is cancelled before the message is due.
+func waitForRetry(ctx context.Context, msg kafka.Message) bool {
+	notBefore, ok := headerValue(msg, HeaderRetryNotBefore)
+	if !ok {
+		return true
+	}
+
+	due, err := time.Parse(time.RFC3339Nano, notBefore)
+	if err != nil {
+		return true
+	}
+
+	wait := time.Until(due)
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// RetryAttempt returns how many times the message has been retried, 0 for a first delivery.
+func RetryAttempt(msg kafka.Message) int {
+	value, ok := headerValue(msg, HeaderRetryAttempt)
+	if !ok {
+		return 0
+	}
+
+	attempt, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+
+	return attempt
+}
+
+// OriginalTopic returns the topic the message was first published to,
+// which differs from msg.Topic when it is consumed from a retry topic.
+func OriginalTopic(msg kafka.Message) string {
+	if topic, ok := headerValue(msg, HeaderRetryOriginalTopic); ok {
+		return topic
+	}
+
+	return msg.Topic
+}
+
+func headerValue(msg kafka.Message, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,77 @@
+package kafka_util
+
+import (
+	"context"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+)
+
+// Writer writes messages to a Kafka topic, *kafka.Writer implements it.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// WriterProvider returns the writer for the topic.
+type WriterProvider func(topic string) (Writer, error)
+
+// AsyncWriterProvider returns the asynchronous writer for the topic, which calls completion once messages are written.
+type AsyncWriterProvider func(topic string, completion async.CompletionFunc) (Writer, error)
+
+var (
+	writerProviderMu    sync.RWMutex
+	writerProvider      = defaultWriterProvider
+	asyncWriterProvider = defaultAsyncWriterProvider
+)
+
+// SetWriterProvider sets where PublishMessages and the retry and dead-letter forwarding get their writers,
+// nil restores the default, async.GetKafkaWriter. It must be called before the first message is published.
+func SetWriterProvider(provider WriterProvider) {
+	writerProviderMu.Lock()
+	defer writerProviderMu.Unlock()
+
+	if provider == nil {
+		provider = defaultWriterProvider
+	}
+	writerProvider = provider
+}
+
+func defaultWriterProvider(topic string) (Writer, error) {
+	return async.GetKafkaWriter(topic)
+}
+
+// getWriter returns the writer for the topic from the writer provider.
+func getWriter(topic string) (Writer, error) {
+	writerProviderMu.RLock()
+	provider := writerProvider
+	writerProviderMu.RUnlock()
+
+	return provider(topic)
+}
+
+// SetAsyncWriterProvider sets where PublishMessagesAsync gets its writers,
+// nil restores the default, async.GetKafkaAsyncWriter. It must be called before the first message is published.
+func SetAsyncWriterProvider(provider AsyncWriterProvider) {
+	writerProviderMu.Lock()
+	defer writerProviderMu.Unlock()
+
+	if provider == nil {
+		provider = defaultAsyncWriterProvider
+	}
+	asyncWriterProvider = provider
+}
+
+func defaultAsyncWriterProvider(topic string, completion async.CompletionFunc) (Writer, error) {
+	return async.GetKafkaAsyncWriter(topic, completion)
+}
+
+// getAsyncWriter returns the asynchronous writer for the topic from the async writer provider.
+func getAsyncWriter(topic string, completion async.CompletionFunc) (Writer, error) {
+	writerProviderMu.RLock()
+	provider := asyncWriterProvider
+	writerProviderMu.RUnlock()
+
+	return provider(topic, completion)
+}
@@ -0,0 +1,210 @@
+package schema_registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * httpSchemaRegistry is a client of a Confluent compatible schema registry.
+ * Before registering a new version it asks the registry whether the schema is compatible with the latest one,
+ * so an incompatible schema fails with ErrIncompatibleSchema whatever level the registry enforces itself.
+ * If a compatibility level is given, it is set on each subject with `PUT /config/{subject}` before the first
+ * registration, so the registry checks the level the service was configured with. Without one the subject keeps
+ * the level of the registry. Schemas never change once registered, so they are cached by ID.
+ */
+
+const (
+	contentTypeSchemaRegistry = "application/vnd.schemaregistry.v1+json"
+	defaultHTTPTimeout        = 10 * time.Second
+)
+
+type httpSchemaRegistry struct {
+	baseURL       string
+	client        *http.Client
+	compatibility Compatibility // Level set on the subjects, empty to keep the level of the registry
+
+	mu         sync.RWMutex
+	cache      map[int]string  // Schema by ID
+	configured map[string]bool // Subjects whose compatibility level was set
+}
+
+func NewHTTPSchemaRegistry(baseURL string, compatibility Compatibility) SchemaRegistry {
+	return &httpSchemaRegistry{
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		client:        &http.Client{Timeout: defaultHTTPTimeout},
+		compatibility: compatibility,
+		cache:         make(map[int]string),
+		configured:    make(map[string]bool),
+	}
+}
+
+// registryError is the error body of the registry.
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (r *httpSchemaRegistry) Register(ctx context.Context, subject string, schema string) (int, error) {
+	if err := r.configure(ctx, subject); err != nil {
+		return 0, err
+	}
+
+	request := map[string]string{"schema": schema}
+
+	// Check the compatibility first, a subject without versions accepts any schema
+	var compatibility struct {
+		IsCompatible bool     `json:"is_compatible"`
+		Messages     []string `json:"messages"`
+	}
+	status, err := r.do(ctx, http.MethodPost, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest", request, &compatibility)
+	if err != nil && status != http.StatusNotFound {
+		return 0, err
+	}
+	if err == nil && !compatibility.IsCompatible {
+		return 0, fmt.Errorf("%w: %s", ErrIncompatibleSchema, strings.Join(compatibility.Messages, "; "))
+	}
+
+	var registered struct {
+		ID int `json:"id"`
+	}
+	status, err = r.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", request, &registered)
+	if err != nil {
+		if status == http.StatusConflict {
+			return 0, fmt.Errorf("%w: %v", ErrIncompatibleSchema, err)
+		}
+		return 0, err
+	}
+
+	r.store(registered.ID, schema)
+	return registered.ID, nil
+}
+
+func (r *httpSchemaRegistry) GetByID(ctx context.Context, id int) (string, error) {
+	r.mu.RLock()
+	schema, cached := r.cache[id]
+	r.mu.RUnlock()
+	if cached {
+		return schema, nil
+	}
+
+	var response struct {
+		Schema string `json:"schema"`
+	}
+	status, err := r.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &response)
+	if err != nil {
+		if status == http.StatusNotFound {
+			return "", fmt.Errorf("%w: %d", ErrSchemaNotFound, id)
+		}
+		return "", err
+	}
+
+	r.store(id, response.Schema)
+	return response.Schema, nil
+}
+
+func (r *httpSchemaRegistry) GetLatest(ctx context.Context, subject string) (int, string, error) {
+	var response struct {
+		ID     int    `json:"id"`
+		Schema string `json:"schema"`
+	}
+	status, err := r.do(ctx, http.MethodGet, "/subjects/"+url.PathEscape(subject)+"/versions/latest", nil, &response)
+	if err != nil {
+		if status == http.StatusNotFound {
+			return 0, "", fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+		}
+		return 0, "", err
+	}
+
+	r.store(response.ID, response.Schema)
+	return response.ID, response.Schema, nil
+}
+
+// configure sets the compatibility level of the subject, once.
+func (r *httpSchemaRegistry) configure(ctx context.Context, subject string) error {
+	if r.compatibility == "" {
+		return nil
+	}
+
+	r.mu.RLock()
+	configured := r.configured[subject]
+	r.mu.RUnlock()
+	if configured {
+		return nil
+	}
+
+	var response struct {
+		Compatibility string `json:"compatibility"`
+	}
+	request := map[string]string{"compatibility": string(r.compatibility)}
+	if _, err := r.do(ctx, http.MethodPut, "/config/"+url.PathEscape(subject), request, &response); err != nil {
+		return fmt.Errorf("failed to set compatibility of subject %s: %w", subject, err)
+	}
+
+	r.mu.Lock()
+	r.configured[subject] = true
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *httpSchemaRegistry) store(id int, schema string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cache[id] = schema
+}
+
+// do sends the request and decodes the response into out. It returns the status code,
+// and an error for any status other than 200 OK.
+func (r *httpSchemaRegistry) do(ctx context.Context, method, path string, body interface{}, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal schema registry request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create schema registry request: %w", err)
+	}
+	req.Header.Set("Accept", contentTypeSchemaRegistry)
+	if body != nil {
+		req.Header.Set("Content-Type", contentTypeSchemaRegistry)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call schema registry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to read schema registry response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var regErr registryError
+		if json.Unmarshal(data, &regErr) == nil && regErr.Message != "" {
+			return resp.StatusCode, fmt.Errorf("schema registry returned %d: %s", resp.StatusCode, regErr.Message)
+		}
+		return resp.StatusCode, fmt.Errorf("schema registry returned %d", resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to unmarshal schema registry response: %w", err)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,165 @@
+package schema_registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/**
+ * memorySchemaRegistry keeps the schemas in memory, guarded by a Mutex.
+ * With a path, it is a file registry: the schemas are loaded from the JSON file when it is created,
+ * and the file is written again after every new registration, so it works offline across restarts.
+ */
+
+type memorySchemaRegistry struct {
+	mu            sync.Mutex
+	compatibility Compatibility
+	path          string
+
+	state registryState
+}
+
+// registryState is what the file registry writes to its file.
+type registryState struct {
+	Schemas  map[int]string   `json:"schemas"`  // Schema by ID
+	Subjects map[string][]int `json:"subjects"` // IDs of the versions of each subject, oldest first
+	NextID   int              `json:"next_id"`
+}
+
+func NewMemorySchemaRegistry(compatibility Compatibility) SchemaRegistry {
+	return &memorySchemaRegistry{
+		compatibility: compatibility,
+		state:         newRegistryState(),
+	}
+}
+
+// NewFileSchemaRegistry returns a registry persisted to the JSON file at path, which is created if it does not exist.
+func NewFileSchemaRegistry(path string, compatibility Compatibility) (SchemaRegistry, error) {
+	r := &memorySchemaRegistry{
+		compatibility: compatibility,
+		path:          path,
+		state:         newRegistryState(),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read schema registry file %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &r.state); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schema registry file %s: %w", path, err)
+		}
+	}
+
+	return r, nil
+}
+
+func newRegistryState() registryState {
+	return registryState{
+		Schemas:  make(map[int]string),
+		Subjects: make(map[string][]int),
+		NextID:   1,
+	}
+}
+
+func (r *memorySchemaRegistry) Register(ctx context.Context, subject string, schema string) (int, error) {
+	if _, err := Parse(schema); err != nil {
+		return 0, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions := r.state.Subjects[subject]
+	for _, id := range versions {
+		if sameSchema(r.state.Schemas[id], schema) {
+			return id, nil
+		}
+	}
+
+	if len(versions) > 0 {
+		if err := CheckCompatibility(r.compatibility, schema, r.state.Schemas[versions[len(versions)-1]]); err != nil {
+			return 0, err
+		}
+	}
+
+	// The same schema keeps its ID when it is registered under another subject
+	id, isNew := 0, false
+	for existingID, existing := range r.state.Schemas {
+		if sameSchema(existing, schema) {
+			id = existingID
+			break
+		}
+	}
+	if id == 0 {
+		id, isNew = r.state.NextID, true
+		r.state.NextID++
+		r.state.Schemas[id] = schema
+	}
+	r.state.Subjects[subject] = append(versions, id)
+
+	if err := r.save(); err != nil {
+		// Undo the registration, so memory does not diverge from the file
+		r.state.Subjects[subject] = versions
+		if isNew {
+			delete(r.state.Schemas, id)
+			r.state.NextID--
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *memorySchemaRegistry) GetByID(ctx context.Context, id int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	schema, exists := r.state.Schemas[id]
+	if !exists {
+		return "", fmt.Errorf("%w: %d", ErrSchemaNotFound, id)
+	}
+
+	return schema, nil
+}
+
+func (r *memorySchemaRegistry) GetLatest(ctx context.Context, subject string) (int, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions := r.state.Subjects[subject]
+	if len(versions) == 0 {
+		return 0, "", fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+
+	id := versions[len(versions)-1]
+	return id, r.state.Schemas[id], nil
+}
+
+// save writes the registry to its file, if it has one. The lock must be held.
+func (r *memorySchemaRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal schema registry: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for schema registry file %s: %w", r.path, err)
+	}
+
+	// Write to a temporary file first, so a crash never leaves a half written file
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write schema registry file %s: %w", r.path, err)
+	}
+
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,112 @@
+package schema_registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hamba/avro/v2"
+)
+
+/**
+ * SchemaRegistry stores Avro schemas by subject and hands out the IDs used in the Confluent wire format.
+ * Every subject has a list of versions. Registering a schema that is already a version of the subject
+ * returns its ID, a new schema must be compatible with the latest version, according to the compatibility level.
+ * The HTTP implementation talks to a Confluent compatible registry, the memory and file implementations work offline.
+ */
+
+// Compatibility is the compatibility level checked when a new schema version is registered.
+type Compatibility string
+
+const (
+	// CompatibilityBackward allows consumers using the new schema to read data written with the latest one
+	CompatibilityBackward Compatibility = "BACKWARD"
+	// CompatibilityForward allows consumers using the latest schema to read data written with the new one
+	CompatibilityForward Compatibility = "FORWARD"
+	// CompatibilityFull is both backward and forward
+	CompatibilityFull Compatibility = "FULL"
+	// CompatibilityNone registers any schema
+	CompatibilityNone Compatibility = "NONE"
+)
+
+var (
+	// ErrSubjectNotFound is returned when no schema is registered under the subject
+	ErrSubjectNotFound = errors.New("subject not found")
+	// ErrSchemaNotFound is returned when no schema is registered with the ID
+	ErrSchemaNotFound = errors.New("schema not found")
+	// ErrIncompatibleSchema is returned when a new schema is not compatible with the latest version of the subject
+	ErrIncompatibleSchema = errors.New("incompatible schema")
+)
+
+type SchemaRegistry interface {
+	// Register registers the schema under the subject and returns its ID.
+	Register(ctx context.Context, subject string, schema string) (int, error)
+	// GetByID returns the schema with the ID.
+	GetByID(ctx context.Context, id int) (string, error)
+	// GetLatest returns the ID and the schema of the latest version of the subject.
+	GetLatest(ctx context.Context, subject string) (int, string, error)
+}
+
+// ParseCompatibility parses the compatibility level, defaulting to CompatibilityBackward if it is empty.
+func ParseCompatibility(level string) (Compatibility, error) {
+	switch Compatibility(level) {
+	case "":
+		return CompatibilityBackward, nil
+	case CompatibilityBackward, CompatibilityForward, CompatibilityFull, CompatibilityNone:
+		return Compatibility(level), nil
+	default:
+		return "", fmt.Errorf("unknown compatibility level: %s", level)
+	}
+}
+
+// Parse parses the Avro schema. Each schema gets its own cache of named types,
+// so two versions of the same record do not clash.
+func Parse(schema string) (avro.Schema, error) {
+	return avro.ParseWithCache(schema, "", &avro.SchemaCache{})
+}
+
+// CheckCompatibility checks that the new schema is compatible with the latest one at the given level.
+func CheckCompatibility(level Compatibility, newSchema, latestSchema string) error {
+	if level == CompatibilityNone {
+		return nil
+	}
+
+	newParsed, err := Parse(newSchema)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	latestParsed, err := Parse(latestSchema)
+	if err != nil {
+		return fmt.Errorf("invalid latest schema: %w", err)
+	}
+
+	compatibility := avro.NewSchemaCompatibility()
+	if level == CompatibilityBackward || level == CompatibilityFull {
+		// The new schema reads data written with the latest one
+		if err := compatibility.Compatible(newParsed, latestParsed); err != nil {
+			return fmt.Errorf("%w: not backward compatible: %v", ErrIncompatibleSchema, err)
+		}
+	}
+	if level == CompatibilityForward || level == CompatibilityFull {
+		// The latest schema reads data written with the new one
+		if err := compatibility.Compatible(latestParsed, newParsed); err != nil {
+			return fmt.Errorf("%w: not forward compatible: %v", ErrIncompatibleSchema, err)
+		}
+	}
+
+	return nil
+}
+
+// sameSchema reports whether the two schemas are the same, ignoring formatting.
+func sameSchema(a, b string) bool {
+	aParsed, err := Parse(a)
+	if err != nil {
+		return false
+	}
+	bParsed, err := Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return aParsed.Fingerprint() == bParsed.Fingerprint()
+}
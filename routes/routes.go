@@ -5,15 +5,39 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/yoanesber/go-kafka-messaging-demo/internal/handler"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/outbox"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
 	"github.com/yoanesber/go-kafka-messaging-demo/internal/service"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/metrics"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/auth"
 	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/headers"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/idempotency"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/logging"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/requestid"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/tracing"
 )
 
-func SetupRouter() *gin.Engine {
-	// Create a new Gin router instance
-	r := gin.Default()
+// SetupRouter sets up the routes. outboxRelay is nil when the outbox is not used.
+func SetupRouter(messageService service.MessageService, outboxRelay *outbox.Relay, idempotencyRepository repository.IdempotencyRepository) *gin.Engine {
+	// Create a new Gin router instance, requests are logged by the access log instead of gin's logger
+	r := gin.New()
 
-	// Set up middleware for the router
+	// Set up the middleware every request goes through.
+	// Recovery comes last, so a panic is traced, counted and logged as the 500 it is answered with
+	r.Use(
+		requestid.RequestID(),
+		tracing.Requests(),
+		metrics.Requests(),
+		logging.AccessLog(logging.LoadConfig()),
+		gin.Recovery(),
+	)
+
+	// Expose the metrics in the Prometheus format.
+	// It is registered before the API middleware, as scrapers send no Origin header
+	// and the handler compresses the response itself
+	r.GET("/metrics", metrics.Handler())
+
+	// Set up the middleware of the API, the routes registered from here on go through it
 	r.Use(
 		headers.SecurityHeaders(),
 		headers.CorsHeaders(),
@@ -24,12 +48,23 @@ func SetupRouter() *gin.Engine {
 	// Set up the API group
 	api := r.Group("/api")
 	{
-		// Set the service and handler for the message API
-		s := service.NewMessageService()
-		h := handler.NewMessageHandler(s)
+		// Set the handler for the message API
+		h := handler.NewMessageHandler(messageService)
 
 		// Define the routes for the API
-		api.POST("/send-message", h.SendMessage)
+		api.POST("/send-message", idempotency.IdempotencyKey(idempotencyRepository), h.SendMessage)
+		api.POST("/messages/batch", idempotency.IdempotencyKey(idempotencyRepository), h.SendMessages)
+		api.GET("/messages/:id", h.GetMessage)
+
+		// Define the admin routes to inspect and control the outbox relay, they require the admin token
+		if outboxRelay != nil {
+			oh := handler.NewOutboxHandler(outboxRelay)
+
+			admin := api.Group("/admin", auth.AdminToken())
+			admin.GET("/outbox", oh.GetStatus)
+			admin.POST("/outbox/pause", oh.Pause)
+			admin.POST("/outbox/resume", oh.Resume)
+		}
 	}
 
 	// This handler will be called when no other route matches the request
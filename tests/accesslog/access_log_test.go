@@ -0,0 +1,205 @@
+package accesslog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/logging"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/requestid"
+)
+
+// captureJSON sets up the loggers with the JSON format and returns the buffer they write to.
+func captureJSON(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	t.Setenv("LOG_FORMAT", "json")
+	logger.Exit()
+	t.Cleanup(logger.Exit)
+
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	return &buf
+}
+
+// entries decodes the JSON log lines written to buf.
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var result []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %q: %v", line, err)
+		}
+		result = append(result, entry)
+	}
+
+	return result
+}
+
+func newRouter(config logging.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(requestid.RequestID(), logging.AccessLog(config))
+	r.POST("/api/send-message", func(c *gin.Context) {
+		var body map[string]interface{}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		logging.SetMessageIDs(c, "msg-1")
+		c.JSON(http.StatusOK, gin.H{"message": "Message sent successfully", "id": "msg-1"})
+	})
+	r.POST("/api/messages/batch", func(c *gin.Context) {
+		logging.SetMessageIDs(c, "msg-1", "msg-2")
+		c.JSON(http.StatusOK, gin.H{"message": "Batch sent"})
+	})
+	r.GET("/metrics", func(c *gin.Context) {
+		c.String(http.StatusOK, "metrics")
+	})
+
+	return r
+}
+
+func TestAccessLogFields(t *testing.T) {
+	buf := captureJSON(t)
+	r := newRouter(logging.Config{})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/send-message", strings.NewReader(`{"message":"hello"}`))
+	req.Header.Set(requestid.HeaderRequestID, "req-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	got := entries(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(got))
+	}
+
+	entry := got[0]
+	for key, want := range map[string]interface{}{
+		"method":              http.MethodPost,
+		"path":                "/api/send-message",
+		"route":               "/api/send-message",
+		"status":              float64(http.StatusOK),
+		"response_size":       float64(w.Body.Len()),
+		logger.FieldRequestID: "req-1",
+		logger.FieldMessageID: "msg-1",
+	} {
+		if entry[key] != want {
+			t.Errorf("expected %s %v, got %v", key, want, entry[key])
+		}
+	}
+	for _, key := range []string{"latency_ms", "client_ip"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("expected %s to be logged", key)
+		}
+	}
+	if _, ok := entry["body"]; ok {
+		t.Error("expected the body not to be logged by default")
+	}
+}
+
+func TestAccessLogBatchMessageIDs(t *testing.T) {
+	buf := captureJSON(t)
+	r := newRouter(logging.Config{})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/messages/batch", nil))
+
+	ids, _ := entries(t, buf)[0]["message_ids"].([]interface{})
+	if len(ids) != 2 || ids[0] != "msg-1" || ids[1] != "msg-2" {
+		t.Fatalf("expected the IDs of the batch, got %v", ids)
+	}
+}
+
+func TestAccessLogRedactsBody(t *testing.T) {
+	buf := captureJSON(t)
+	r := newRouter(logging.Config{LogBody: true, RedactFields: []string{"message"}, MaxBodySize: 1024})
+
+	body := `{"sender_id":"alice","message":"secret","attachments":[{"name":"a.png","message":"nested secret"}]}`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/send-message", strings.NewReader(body)))
+
+	// The handler still gets the whole body
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	logged, _ := entries(t, buf)[0]["body"].(string)
+	if strings.Contains(logged, "secret") {
+		t.Errorf("expected the message content to be masked, got %s", logged)
+	}
+	if !strings.Contains(logged, `"sender_id":"alice"`) || strings.Count(logged, "[REDACTED]") != 2 {
+		t.Errorf("expected only the message fields to be masked, got %s", logged)
+	}
+}
+
+func TestAccessLogOmitsNonJSONBody(t *testing.T) {
+	buf := captureJSON(t)
+	r := newRouter(logging.Config{LogBody: true, RedactFields: []string{"message"}, MaxBodySize: 1024})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/send-message", strings.NewReader("message=secret")))
+
+	entry := entries(t, buf)[0]
+	if _, ok := entry["body"]; ok {
+		t.Errorf("expected a body that cannot be masked not to be logged, got %v", entry["body"])
+	}
+	if entry["level"] != "warning" {
+		t.Errorf("expected a 400 to be logged at warning level, got %v", entry["level"])
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	buf := captureJSON(t)
+	r := newRouter(logging.Config{SampleRates: map[string]float64{"/api/send-message": 0}})
+
+	for i := 0; i < 10; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/send-message", strings.NewReader(`{}`)))
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected the sampled out route not to be logged, got %s", buf.String())
+	}
+
+	// Failed requests are logged whatever the rate
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/send-message", strings.NewReader("invalid")))
+	if got := entries(t, buf); len(got) != 1 || got[0]["status"] != float64(http.StatusBadRequest) {
+		t.Fatalf("expected the failed request to be logged, got %v", got)
+	}
+
+	// Other routes are not sampled
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := entries(t, buf); len(got) != 2 {
+		t.Fatalf("expected the request to another route to be logged, got %d entries", len(got))
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("ACCESS_LOG_BODY", "true")
+	t.Setenv("ACCESS_LOG_REDACT_FIELDS", "message, attachments")
+	t.Setenv("ACCESS_LOG_SAMPLE_RATES", "/metrics=0.1,/api/send-message=2,/api/messages/:id=0.5")
+	logger.SetOutput(&bytes.Buffer{})
+	t.Cleanup(logger.Exit)
+
+	config := logging.LoadConfig()
+	if !config.LogBody {
+		t.Error("expected the body to be logged")
+	}
+	if len(config.RedactFields) != 2 || config.RedactFields[1] != "attachments" {
+		t.Errorf("unexpected redacted fields %v", config.RedactFields)
+	}
+	if len(config.SampleRates) != 2 || config.SampleRates["/metrics"] != 0.1 || config.SampleRates["/api/messages/:id"] != 0.5 {
+		t.Errorf("expected the valid sample rates only, got %v", config.SampleRates)
+	}
+}
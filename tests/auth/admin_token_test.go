@@ -0,0 +1,75 @@
+package auth_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/auth"
+)
+
+// TestMain discards the log output, so the tests do not write to the logs directory
+func TestMain(m *testing.M) {
+	logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.POST("/api/admin/outbox/pause", auth.AdminToken(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	return r
+}
+
+func pause(r *gin.Engine, authorization string) int {
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/outbox/pause", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	r := newRouter()
+
+	cases := []struct {
+		authorization string
+		want          int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Basic secret", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusForbidden},
+		{"Bearer secret", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		if got := pause(r, c.authorization); got != c.want {
+			t.Errorf("expected %d for %q, got %d", c.want, c.authorization, got)
+		}
+	}
+}
+
+func TestAdminTokenNotSet(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "")
+	r := newRouter()
+
+	// Without a configured token the admin routes are closed, even to an empty bearer token
+	for _, authorization := range []string{"Bearer ", "Bearer secret"} {
+		if got := pause(r, authorization); got != http.StatusForbidden {
+			t.Errorf("expected 403 for %q, got %d", authorization, got)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package avro_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/handler"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/schema"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+	schemaregistry "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/schema-registry"
+)
+
+const subject = "messaging-value"
+
+// messageV0 is the message schema before the status history was added.
+const messageV0 = `{
+  "type": "record",
+  "name": "Message",
+  "namespace": "com.github.yoanesber.messaging",
+  "fields": [
+    { "name": "id", "type": "string" },
+    { "name": "sender_id", "type": "string" },
+    { "name": "receiver_id", "type": "string" },
+    { "name": "message", "type": "string" },
+    { "name": "timestamp", "type": { "type": "long", "logicalType": "timestamp-micros" } },
+    { "name": "status", "type": "string" }
+  ]
+}`
+
+// withField returns the message schema v0 with an extra field.
+func withField(field string) string {
+	return strings.Replace(messageV0, `{ "name": "status", "type": "string" }`, `{ "name": "status", "type": "string" }, `+field, 1)
+}
+
+func newMessage() entity.Message {
+	now := time.Date(2025, 6, 22, 16, 2, 12, 0, time.UTC)
+	return entity.Message{
+		ID:         "f38d7d4d-5da0-4188-a314-9b94f85c090c",
+		SenderID:   "a2f3cbe1-0e4e-4b3b-bb7e-8ff9b6d4a124",
+		ReceiverID: "f4a1e8d7-22d7-4b3a-b6d1-c9ea2ff6a9b3",
+		Message:    "Hello, how are you doing today?",
+		Timestamp:  now,
+		Status:     entity.MessageStatusSent,
+		StatusHistory: []entity.MessageStatusHistory{
+			{Status: entity.MessageStatusPending, Timestamp: now},
+			{Status: entity.MessageStatusSent, Timestamp: now.Add(time.Second)},
+		},
+		ConversationID: "7a1c5e0e-4f8e-4c1e-9d55-2f0b1a3c6d7e",
+		Attachments: []entity.MessageAttachment{
+			{Name: "photo.png", URL: "https://example.com/files/photo.png", ContentType: "image/png", Size: 204800},
+		},
+	}
+}
+
+func register(t *testing.T, registry schemaregistry.SchemaRegistry, schema string) int {
+	t.Helper()
+
+	id, err := registry.Register(context.Background(), subject, schema)
+	if err != nil {
+		t.Fatalf("failed to register schema: %v", err)
+	}
+	return id
+}
+
+func TestAvroCodecRoundTrip(t *testing.T) {
+	registry := schemaregistry.NewMemorySchemaRegistry(schemaregistry.CompatibilityBackward)
+	id := register(t, registry, schema.MessageAvro)
+	codec := kafkautil.NewAvroCodec(registry, subject)
+
+	want := newMessage()
+	data, err := codec.Marshal(want)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	// Confluent wire format: magic byte, then the schema ID
+	if data[0] != 0 || int(binary.BigEndian.Uint32(data[1:5])) != id {
+		t.Fatalf("expected magic byte 0 and schema ID %d, got % x", id, data[:5])
+	}
+
+	var got entity.Message
+	if err := codec.Unmarshal(data, &got); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	if got.ID != want.ID || got.SenderID != want.SenderID || got.ReceiverID != want.ReceiverID ||
+		got.Message != want.Message || got.Status != want.Status || !got.Timestamp.Equal(want.Timestamp) {
+		t.Fatalf("message mismatch:\nwant %+v\ngot  %+v", want, got)
+	}
+	if len(got.StatusHistory) != 2 || got.StatusHistory[1].Status != entity.MessageStatusSent {
+		t.Fatalf("unexpected status history: %+v", got.StatusHistory)
+	}
+	if got.ConversationID != want.ConversationID || len(got.Attachments) != 1 || got.Attachments[0] != want.Attachments[0] {
+		t.Fatalf("unexpected conversation: %s %+v", got.ConversationID, got.Attachments)
+	}
+}
+
+func TestAvroCodecReadsOlderSchemaVersion(t *testing.T) {
+	registry := schemaregistry.NewMemorySchemaRegistry(schemaregistry.CompatibilityBackward)
+	register(t, registry, messageV0)
+
+	// Written by a producer that still uses v0
+	oldData, err := kafkautil.NewAvroCodec(registry, subject).Marshal(newMessage())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	// v1 adds the status history with a default, so it is backward compatible
+	register(t, registry, schema.MessageAvro)
+
+	var got entity.Message
+	if err := kafkautil.NewAvroCodec(registry, subject).Unmarshal(oldData, &got); err != nil {
+		t.Fatalf("failed to unmarshal data written with the older schema: %v", err)
+	}
+	if got.ID != newMessage().ID || len(got.StatusHistory) != 0 {
+		t.Fatalf("unexpected message: %+v", got)
+	}
+}
+
+func TestAvroCodecWithoutSchema(t *testing.T) {
+	codec := kafkautil.NewAvroCodec(schemaregistry.NewMemorySchemaRegistry(schemaregistry.CompatibilityBackward), subject)
+	if _, err := codec.Marshal(newMessage()); !errors.Is(err, schemaregistry.ErrSubjectNotFound) {
+		t.Fatalf("expected ErrSubjectNotFound, got %v", err)
+	}
+
+	var got entity.Message
+	if err := codec.Unmarshal([]byte(`{"id":"1"}`), &got); err == nil {
+		t.Fatal("expected an error for a value that is not in the wire format")
+	}
+}
+
+func TestRegisterCompatibility(t *testing.T) {
+	fieldWithDefault := `{ "name": "channel", "type": "string", "default": "chat" }`
+	fieldWithoutDefault := `{ "name": "channel", "type": "string" }`
+
+	tests := []struct {
+		name          string
+		compatibility schemaregistry.Compatibility
+		first, second string
+		compatible    bool
+	}{
+		{"backward, field added with default", schemaregistry.CompatibilityBackward, messageV0, withField(fieldWithDefault), true},
+		{"backward, field added without default", schemaregistry.CompatibilityBackward, messageV0, withField(fieldWithoutDefault), false},
+		{"forward, field added without default", schemaregistry.CompatibilityForward, messageV0, withField(fieldWithoutDefault), true},
+		{"forward, field removed without default", schemaregistry.CompatibilityForward, withField(fieldWithoutDefault), messageV0, false},
+		{"full, field added without default", schemaregistry.CompatibilityFull, messageV0, withField(fieldWithoutDefault), false},
+		{"full, field added with default", schemaregistry.CompatibilityFull, messageV0, withField(fieldWithDefault), true},
+		{"none, field added without default", schemaregistry.CompatibilityNone, messageV0, withField(fieldWithoutDefault), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := schemaregistry.NewMemorySchemaRegistry(tt.compatibility)
+			register(t, registry, tt.first)
+
+			_, err := registry.Register(context.Background(), subject, tt.second)
+			if tt.compatible && err != nil {
+				t.Fatalf("expected the schema to be registered, got %v", err)
+			}
+			if !tt.compatible && !errors.Is(err, schemaregistry.ErrIncompatibleSchema) {
+				t.Fatalf("expected ErrIncompatibleSchema, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRegisterSameSchemaReturnsSameID(t *testing.T) {
+	registry := schemaregistry.NewMemorySchemaRegistry(schemaregistry.CompatibilityBackward)
+	first := register(t, registry, messageV0)
+
+	// Formatting does not matter
+	second := register(t, registry, strings.Join(strings.Fields(messageV0), " "))
+	if first != second {
+		t.Fatalf("expected the same ID, got %d and %d", first, second)
+	}
+
+	// The same schema under another subject keeps its ID
+	other, err := registry.Register(context.Background(), "audit-value", messageV0)
+	if err != nil {
+		t.Fatalf("failed to register schema: %v", err)
+	}
+	if other != first {
+		t.Fatalf("expected ID %d under another subject, got %d", first, other)
+	}
+}
+
+func TestFileSchemaRegistryPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema-registry.json")
+
+	registry, err := schemaregistry.NewFileSchemaRegistry(path, schemaregistry.CompatibilityBackward)
+	if err != nil {
+		t.Fatalf("failed to create registry: %v", err)
+	}
+	id := register(t, registry, schema.MessageAvro)
+
+	// A new registry on the same file sees the schema
+	reopened, err := schemaregistry.NewFileSchemaRegistry(path, schemaregistry.CompatibilityBackward)
+	if err != nil {
+		t.Fatalf("failed to reopen registry: %v", err)
+	}
+
+	latestID, latest, err := reopened.GetLatest(context.Background(), subject)
+	if err != nil {
+		t.Fatalf("failed to get latest schema: %v", err)
+	}
+	if latestID != id || latest != schema.MessageAvro {
+		t.Fatalf("expected schema %d, got %d", id, latestID)
+	}
+
+	// And keeps checking the compatibility against it
+	if _, err := reopened.Register(context.Background(), subject, withField(`{ "name": "channel", "type": "string" }`)); !errors.Is(err, schemaregistry.ErrIncompatibleSchema) {
+		t.Fatalf("expected ErrIncompatibleSchema, got %v", err)
+	}
+}
+
+// newRegistryServer returns a fake Confluent schema registry backed by a memory registry.
+// Subjects are BACKWARD compatible unless their level is set with PUT /config/{subject}, levels holds the set ones.
+func newRegistryServer(t *testing.T) (*httptest.Server, map[string]schemaregistry.Compatibility) {
+	t.Helper()
+
+	backend := schemaregistry.NewMemorySchemaRegistry(schemaregistry.CompatibilityNone)
+	var mu sync.Mutex
+	levels := make(map[string]schemaregistry.Compatibility)
+	levelOf := func(subject string) schemaregistry.Compatibility {
+		mu.Lock()
+		defer mu.Unlock()
+		if level, ok := levels[subject]; ok {
+			return level
+		}
+		return schemaregistry.CompatibilityBackward
+	}
+	writeJSON := func(w http.ResponseWriter, status int, body interface{}) {
+		w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+	notFound := func(w http.ResponseWriter, err error) {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error_code": 40401, "message": err.Error()})
+	}
+	readSchema := func(r *http.Request) string {
+		var body struct {
+			Schema string `json:"schema"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		return body.Schema
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /compatibility/subjects/{subject}/versions/latest", func(w http.ResponseWriter, r *http.Request) {
+		_, latest, err := backend.GetLatest(r.Context(), r.PathValue("subject"))
+		if err != nil {
+			notFound(w, err)
+			return
+		}
+
+		err = schemaregistry.CheckCompatibility(levelOf(r.PathValue("subject")), readSchema(r), latest)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"is_compatible": err == nil})
+	})
+	mux.HandleFunc("PUT /config/{subject}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Compatibility schemaregistry.Compatibility `json:"compatibility"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		mu.Lock()
+		levels[r.PathValue("subject")] = body.Compatibility
+		mu.Unlock()
+		writeJSON(w, http.StatusOK, body)
+	})
+	mux.HandleFunc("POST /subjects/{subject}/versions", func(w http.ResponseWriter, r *http.Request) {
+		schema, subject := readSchema(r), r.PathValue("subject")
+		if _, latest, err := backend.GetLatest(r.Context(), subject); err == nil {
+			if err := schemaregistry.CheckCompatibility(levelOf(subject), schema, latest); err != nil {
+				writeJSON(w, http.StatusConflict, map[string]interface{}{"error_code": 409, "message": err.Error()})
+				return
+			}
+		}
+
+		id, err := backend.Register(r.Context(), subject, schema)
+		if err != nil {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error_code": 409, "message": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": id})
+	})
+	mux.HandleFunc("GET /subjects/{subject}/versions/latest", func(w http.ResponseWriter, r *http.Request) {
+		id, latest, err := backend.GetLatest(r.Context(), r.PathValue("subject"))
+		if err != nil {
+			notFound(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"subject": r.PathValue("subject"), "id": id, "schema": latest})
+	})
+	mux.HandleFunc("GET /schemas/ids/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		schema, err := backend.GetByID(r.Context(), id)
+		if err != nil {
+			notFound(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"schema": schema})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, levels
+}
+
+func TestHTTPSchemaRegistry(t *testing.T) {
+	server, levels := newRegistryServer(t)
+	registry := schemaregistry.NewHTTPSchemaRegistry(server.URL, "")
+	ctx := context.Background()
+
+	if _, _, err := registry.GetLatest(ctx, subject); !errors.Is(err, schemaregistry.ErrSubjectNotFound) {
+		t.Fatalf("expected ErrSubjectNotFound, got %v", err)
+	}
+
+	id := register(t, registry, messageV0)
+
+	schema, err := registry.GetByID(ctx, id)
+	if err != nil || schema != messageV0 {
+		t.Fatalf("expected schema %d, got %q, %v", id, schema, err)
+	}
+
+	if _, err := registry.GetByID(ctx, id+100); !errors.Is(err, schemaregistry.ErrSchemaNotFound) {
+		t.Fatalf("expected ErrSchemaNotFound, got %v", err)
+	}
+
+	if _, err := registry.Register(ctx, subject, withField(`{ "name": "channel", "type": "string" }`)); !errors.Is(err, schemaregistry.ErrIncompatibleSchema) {
+		t.Fatalf("expected ErrIncompatibleSchema, got %v", err)
+	}
+	if len(levels) != 0 {
+		t.Fatalf("expected the subject to keep the level of the registry, got %v", levels)
+	}
+
+	// The codec works the same with the HTTP registry
+	codec := kafkautil.NewAvroCodec(registry, subject)
+	data, err := codec.Marshal(newMessage())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	var got entity.Message
+	if err := codec.Unmarshal(data, &got); err != nil || got.ID != newMessage().ID {
+		t.Fatalf("failed to round trip through the HTTP registry: %+v, %v", got, err)
+	}
+}
+
+func TestHTTPSchemaRegistrySetsCompatibility(t *testing.T) {
+	server, levels := newRegistryServer(t)
+	registry := schemaregistry.NewHTTPSchemaRegistry(server.URL, schemaregistry.CompatibilityNone)
+	ctx := context.Background()
+
+	register(t, registry, messageV0)
+	if levels[subject] != schemaregistry.CompatibilityNone {
+		t.Fatalf("expected the level to be set on the subject, got %v", levels)
+	}
+
+	// The registry no longer rejects a schema that is not backward compatible
+	if _, err := registry.Register(ctx, subject, withField(`{ "name": "channel", "type": "string" }`)); err != nil {
+		t.Fatalf("expected the schema to be registered with NONE, got %v", err)
+	}
+}
+
+func TestDispatchAvroEvent(t *testing.T) {
+	registry := schemaregistry.NewMemorySchemaRegistry(schemaregistry.CompatibilityBackward)
+	register(t, registry, schema.MessageAvro)
+	kafkautil.SetSchemaRegistry(registry)
+	t.Cleanup(func() { kafkautil.SetSchemaRegistry(nil) })
+
+	want := newMessage()
+	value, err := kafkautil.NewAvroCodec(registry, subject).Marshal(want)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	// The Avro value is the payload alone, the event type is in the headers
+	msg := kafka.Message{
+		Topic: "messaging",
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: kafkautil.HeaderContentType, Value: []byte(kafkautil.ContentTypeAvro)},
+			{Key: kafkautil.HeaderEventType, Value: []byte(entity.EventTypeSendingMessage)},
+			{Key: kafkautil.HeaderSchemaVersion, Value: []byte("1")},
+		},
+	}
+
+	var got *entity.Message
+	r := handler.NewRegistry(handler.FallbackDLQ)
+	r.Register(handler.AnyTopic, entity.EventTypeSendingMessage, handler.AnyVersion,
+		handler.TypedHandler(func(ctx context.Context, worker string, message *entity.Message) error {
+			got = message
+			return nil
+		}))
+
+	if err := r.Dispatch(context.Background(), "Worker-0", msg); err != nil {
+		t.Fatalf("failed to dispatch: %v", err)
+	}
+	if got == nil || got.ID != want.ID || len(got.StatusHistory) != len(want.StatusHistory) {
+		t.Fatalf("unexpected payload: %+v", got)
+	}
+}
@@ -0,0 +1,195 @@
+package codec_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/handler"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/pb"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+func newMessage() entity.Message {
+	now := time.Date(2025, 6, 22, 16, 2, 12, 0, time.UTC)
+	return entity.Message{
+		ID:         "f38d7d4d-5da0-4188-a314-9b94f85c090c",
+		SenderID:   "a2f3cbe1-0e4e-4b3b-bb7e-8ff9b6d4a124",
+		ReceiverID: "f4a1e8d7-22d7-4b3a-b6d1-c9ea2ff6a9b3",
+		Message:    "Hello, how are you doing today?",
+		Timestamp:  now,
+		Status:     entity.MessageStatusSent,
+		StatusHistory: []entity.MessageStatusHistory{
+			{Status: entity.MessageStatusPending, Timestamp: now},
+			{Status: entity.MessageStatusSent, Timestamp: now.Add(time.Second)},
+		},
+		ConversationID: "7a1c5e0e-4f8e-4c1e-9d55-2f0b1a3c6d7e",
+		Attachments: []entity.MessageAttachment{
+			{Name: "photo.png", URL: "https://example.com/files/photo.png", ContentType: "image/png", Size: 204800},
+		},
+	}
+}
+
+func assertMessageEqual(t *testing.T, want, got entity.Message) {
+	t.Helper()
+
+	if got.ID != want.ID || got.SenderID != want.SenderID || got.ReceiverID != want.ReceiverID ||
+		got.Message != want.Message || got.Status != want.Status || !got.Timestamp.Equal(want.Timestamp) {
+		t.Fatalf("message mismatch:\nwant %+v\ngot  %+v", want, got)
+	}
+
+	if len(got.StatusHistory) != len(want.StatusHistory) {
+		t.Fatalf("expected %d status history entries, got %d", len(want.StatusHistory), len(got.StatusHistory))
+	}
+	for i := range want.StatusHistory {
+		if got.StatusHistory[i].Status != want.StatusHistory[i].Status || !got.StatusHistory[i].Timestamp.Equal(want.StatusHistory[i].Timestamp) {
+			t.Fatalf("status history %d mismatch: want %+v, got %+v", i, want.StatusHistory[i], got.StatusHistory[i])
+		}
+	}
+
+	if got.ConversationID != want.ConversationID || !slices.Equal(got.Attachments, want.Attachments) {
+		t.Fatalf("conversation mismatch:\nwant %s %+v\ngot  %s %+v", want.ConversationID, want.Attachments, got.ConversationID, got.Attachments)
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codecs := map[string]kafkautil.Codec{
+		"json":     kafkautil.JSONCodec,
+		"protobuf": kafkautil.ProtobufCodec,
+	}
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			want := newMessage()
+
+			data, err := codec.Marshal(want)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+
+			var got entity.Message
+			if err := codec.Unmarshal(data, &got); err != nil {
+				t.Fatalf("failed to unmarshal: %v", err)
+			}
+
+			assertMessageEqual(t, want, got)
+		})
+	}
+}
+
+func TestProtobufIsSmallerThanJSON(t *testing.T) {
+	message := newMessage()
+
+	jsonData, err := kafkautil.JSONCodec.Marshal(message)
+	if err != nil {
+		t.Fatalf("failed to marshal JSON: %v", err)
+	}
+	protoData, err := kafkautil.ProtobufCodec.Marshal(message)
+	if err != nil {
+		t.Fatalf("failed to marshal protobuf: %v", err)
+	}
+
+	if len(protoData) >= len(jsonData) {
+		t.Fatalf("expected protobuf (%d bytes) to be smaller than JSON (%d bytes)", len(protoData), len(jsonData))
+	}
+}
+
+func TestProtobufCodecRejectsUnsupportedTypes(t *testing.T) {
+	if _, err := kafkautil.ProtobufCodec.Marshal(map[string]string{"id": "1"}); err == nil {
+		t.Fatal("expected an error when marshaling a type without a protobuf form")
+	}
+}
+
+func TestCodecForContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        kafkautil.Codec
+	}{
+		{"", kafkautil.JSONCodec},
+		{"application/json", kafkautil.JSONCodec},
+		{"application/json; charset=utf-8", kafkautil.JSONCodec},
+		{"application/x-protobuf", kafkautil.ProtobufCodec},
+	}
+
+	for _, tt := range tests {
+		codec, err := kafkautil.CodecForContentType(tt.contentType)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.contentType, err)
+		}
+		if codec != tt.want {
+			t.Fatalf("expected %s codec for %q, got %s", tt.want.ContentType(), tt.contentType, codec.ContentType())
+		}
+	}
+
+	if _, err := kafkautil.CodecForContentType("text/plain"); err == nil {
+		t.Fatal("expected an error for an unsupported content type")
+	}
+}
+
+// dispatch runs the message through a registry with a sending-message handler and returns the decoded payload.
+func dispatch(t *testing.T, msg kafka.Message) entity.Message {
+	t.Helper()
+
+	var got *entity.Message
+	registry := handler.NewRegistry(handler.FallbackDLQ)
+	registry.Register(handler.AnyTopic, entity.EventTypeSendingMessage, handler.AnyVersion,
+		handler.TypedHandler(func(ctx context.Context, worker string, message *entity.Message) error {
+			got = message
+			return nil
+		}))
+
+	if err := registry.Dispatch(context.Background(), "Worker-0", msg); err != nil {
+		t.Fatalf("failed to dispatch: %v", err)
+	}
+	if got == nil {
+		t.Fatal("handler was not called")
+	}
+
+	return *got
+}
+
+func TestDispatchJSONEvent(t *testing.T) {
+	want := newMessage()
+	event := entity.MessageEvent{EventType: entity.EventTypeSendingMessage, Version: entity.MessageEventVersion, Payload: want}
+
+	// JSON is the codec of topics without one configured
+	msg, err := kafkautil.NewMessage(context.Background(), "messaging", "key", event)
+	if err != nil {
+		t.Fatalf("failed to build message: %v", err)
+	}
+
+	assertMessageEqual(t, want, dispatch(t, msg))
+}
+
+func TestDispatchProtobufEvent(t *testing.T) {
+	want := newMessage()
+
+	payload, err := kafkautil.ProtobufCodec.Marshal(want)
+	if err != nil {
+		t.Fatalf("failed to marshal payload: %v", err)
+	}
+	value, err := proto.Marshal(&pb.EventEnvelope{EventType: entity.EventTypeSendingMessage, Version: entity.MessageEventVersion, Payload: payload})
+	if err != nil {
+		t.Fatalf("failed to marshal envelope: %v", err)
+	}
+
+	// Routed on the envelope, then decoded with the codec of the content type
+	msg := kafka.Message{
+		Topic:   "messaging",
+		Value:   value,
+		Headers: []kafka.Header{{Key: kafkautil.HeaderContentType, Value: []byte(kafkautil.ContentTypeProtobuf)}},
+	}
+	assertMessageEqual(t, want, dispatch(t, msg))
+
+	// Routed on the headers, then decoded with the codec of the content type
+	msg.Headers = append(msg.Headers,
+		kafka.Header{Key: kafkautil.HeaderEventType, Value: []byte(entity.EventTypeSendingMessage)},
+		kafka.Header{Key: kafkautil.HeaderSchemaVersion, Value: []byte("1")},
+	)
+	assertMessageEqual(t, want, dispatch(t, msg))
+}
@@ -0,0 +1,123 @@
+package codec_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+// headersWithKey returns the values of the headers of the message with the key, in order.
+func headersWithKey(msg kafka.Message, key string) []string {
+	var values []string
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			values = append(values, string(h.Value))
+		}
+	}
+	return values
+}
+
+func TestCallerHeaderReplacesStandardHeader(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{kafkautil.HeaderContentType, "application/vnd.custom+json"},
+		{kafkautil.HeaderProducer, "other-service"},
+		{kafkautil.HeaderCorrelationID, "caller-correlation-id"},
+		{kafkautil.HeaderTimestamp, "2025-06-22T16:02:12Z"},
+		{kafkautil.HeaderEventType, "renamed-event"},
+		{kafkautil.HeaderSchemaVersion, "7"},
+	}
+
+	event := entity.MessageEvent{EventType: entity.EventTypeSendingMessage, Version: entity.MessageEventVersion, Payload: newMessage()}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			msg, err := kafkautil.NewMessage(context.Background(), "messaging", "key", event, kafka.Header{Key: tt.key, Value: []byte(tt.value)})
+			if err != nil {
+				t.Fatalf("failed to build message: %v", err)
+			}
+
+			if got := headersWithKey(msg, tt.key); len(got) != 1 || got[0] != tt.value {
+				t.Fatalf("expected only the caller's %s header %q, got %v", tt.key, tt.value, got)
+			}
+
+			// The other standard headers are kept
+			for _, key := range []string{kafkautil.HeaderContentType, kafkautil.HeaderProducer, kafkautil.HeaderCorrelationID, kafkautil.HeaderTimestamp, kafkautil.HeaderEventType, kafkautil.HeaderSchemaVersion} {
+				if got := headersWithKey(msg, key); len(got) != 1 {
+					t.Errorf("expected one %s header, got %v", key, got)
+				}
+			}
+		})
+	}
+}
+
+func TestEventTypeFromHeaders(t *testing.T) {
+	ceType := kafkautil.CloudEventType(entity.EventTypeSendingMessage)
+
+	tests := []struct {
+		name        string
+		headers     []kafka.Header
+		wantType    string
+		wantVersion int
+		wantOK      bool
+	}{
+		{
+			name: "event headers",
+			headers: []kafka.Header{
+				{Key: kafkautil.HeaderEventType, Value: []byte(entity.EventTypeSendingMessage)},
+				{Key: kafkautil.HeaderSchemaVersion, Value: []byte("2")},
+			},
+			wantType: entity.EventTypeSendingMessage, wantVersion: 2, wantOK: true,
+		},
+		{
+			name: "cloud event headers",
+			headers: []kafka.Header{
+				{Key: kafkautil.HeaderCloudEventsType, Value: []byte(ceType)},
+				{Key: kafkautil.HeaderCloudEventsSchemaVersion, Value: []byte("1")},
+			},
+			wantType: entity.EventTypeSendingMessage, wantVersion: 1, wantOK: true,
+		},
+		{
+			name: "event headers win over cloud event headers",
+			headers: []kafka.Header{
+				{Key: kafkautil.HeaderCloudEventsType, Value: []byte(kafkautil.CloudEventType("other-event"))},
+				{Key: kafkautil.HeaderCloudEventsSchemaVersion, Value: []byte("1")},
+				{Key: kafkautil.HeaderEventType, Value: []byte(entity.EventTypeSendingMessage)},
+				{Key: kafkautil.HeaderSchemaVersion, Value: []byte("2")},
+			},
+			wantType: entity.EventTypeSendingMessage, wantVersion: 2, wantOK: true,
+		},
+		{
+			name: "empty event type falls back to the cloud event type",
+			headers: []kafka.Header{
+				{Key: kafkautil.HeaderEventType, Value: []byte("")},
+				{Key: kafkautil.HeaderCloudEventsType, Value: []byte(ceType)},
+				{Key: kafkautil.HeaderCloudEventsSchemaVersion, Value: []byte("2")},
+			},
+			wantType: entity.EventTypeSendingMessage, wantVersion: 2, wantOK: true,
+		},
+		{
+			name:     "cloud event without schema version",
+			headers:  []kafka.Header{{Key: kafkautil.HeaderCloudEventsType, Value: []byte(ceType)}},
+			wantType: entity.EventTypeSendingMessage, wantVersion: 0, wantOK: true,
+		},
+		{
+			name:    "no event headers",
+			headers: []kafka.Header{{Key: kafkautil.HeaderContentType, Value: []byte(kafkautil.ContentTypeJSON)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventType, version, ok := kafkautil.EventType(kafka.Message{Headers: tt.headers})
+			if eventType != tt.wantType || version != tt.wantVersion || ok != tt.wantOK {
+				t.Fatalf("expected (%q, %d, %v), got (%q, %d, %v)", tt.wantType, tt.wantVersion, tt.wantOK, eventType, version, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package dedup_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/middleware"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+// TestMain discards the log output, so the tests do not write to the logs directory
+func TestMain(m *testing.M) {
+	logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newHandler returns a handler behind the Dedup middleware, which fails while failures is positive,
+// and counts its calls.
+func newHandler(processed repository.ProcessedEventRepository, calls *int, failures int) kafkautil.HandlerFunc {
+	return kafkautil.Chain(func(ctx context.Context, worker string, msg kafka.Message) error {
+		*calls++
+		if failures > 0 {
+			failures--
+			return errors.New("store unavailable")
+		}
+		return nil
+	}, middleware.Dedup(processed, time.Hour))
+}
+
+func TestRedeliveredEventSkipsHandler(t *testing.T) {
+	calls := 0
+	handler := newHandler(repository.NewMemoryProcessedEventRepository(10), &calls, 0)
+
+	msg := kafka.Message{Topic: "messaging", Headers: []kafka.Header{kafkautil.EventIDHeader("event-1")}}
+	for i := 0; i < 2; i++ {
+		if err := handler(context.Background(), "Worker-0", msg); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected the redelivered event to skip the handler, got %d calls", calls)
+	}
+
+	// Another event is handled
+	other := kafka.Message{Topic: "messaging", Headers: []kafka.Header{kafkautil.EventIDHeader("event-2")}}
+	if err := handler(context.Background(), "Worker-0", other); err != nil || calls != 2 {
+		t.Fatalf("expected another event to be handled, got %d calls and error %v", calls, err)
+	}
+}
+
+func TestFailedEventIsNotMarkedProcessed(t *testing.T) {
+	processed := repository.NewMemoryProcessedEventRepository(10)
+	calls := 0
+	handler := newHandler(processed, &calls, 1)
+
+	msg := kafka.Message{Topic: "messaging", Headers: []kafka.Header{kafkautil.EventIDHeader("event-1")}}
+	if err := handler(context.Background(), "Worker-0", msg); err == nil {
+		t.Fatal("expected the first delivery to fail")
+	}
+	if done, _ := processed.IsProcessed(context.Background(), "event-1"); done {
+		t.Fatal("expected the failed event not to be marked processed")
+	}
+
+	// The retry is handled, and only then the event is remembered
+	if err := handler(context.Background(), "Worker-0", msg); err != nil || calls != 2 {
+		t.Fatalf("expected the retry to be handled, got %d calls and error %v", calls, err)
+	}
+	if err := handler(context.Background(), "Worker-0", msg); err != nil || calls != 2 {
+		t.Fatalf("expected the event to be dropped once handled, got %d calls and error %v", calls, err)
+	}
+}
+
+func TestEventIDFallsBackToPayloadID(t *testing.T) {
+	processed := repository.NewMemoryProcessedEventRepository(10)
+	calls := 0
+	handler := newHandler(processed, &calls, 0)
+
+	// Published before the x-event-id header existed, the message ID is only in the payload
+	msg := kafka.Message{
+		Topic: "messaging",
+		Value: []byte(`{"event_type":"sending-message","version":2,"payload":{"id":"msg-1","message":"hello"}}`),
+	}
+	for i := 0; i < 2; i++ {
+		if err := handler(context.Background(), "Worker-0", msg); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected the redelivered event to skip the handler, got %d calls", calls)
+	}
+	if done, _ := processed.IsProcessed(context.Background(), "msg-1"); !done {
+		t.Fatal("expected the event to be remembered by the ID in its payload")
+	}
+}
@@ -0,0 +1,225 @@
+package idempotency_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/idempotency"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/requestid"
+	httputil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/http-util"
+)
+
+// TestMain discards the log output, so the tests do not write to the logs directory
+func TestMain(m *testing.M) {
+	logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newRouter returns a router whose handler replies with the next status of statuses, and counts its calls.
+func newRouter(store idempotency.Store, calls *int, statuses ...int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.POST("/api/send-message", idempotency.IdempotencyKey(store), func(c *gin.Context) {
+		status := statuses[min(*calls, len(statuses)-1)]
+		*calls++
+		c.JSON(status, gin.H{"call": *calls})
+	})
+
+	return r
+}
+
+func send(r *gin.Engine, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/send-message", strings.NewReader(`{"message":"hello"}`))
+	req.Header.Set(idempotency.HeaderIdempotencyKey, key)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestServerErrorsReleaseTheKey(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		calls := 0
+		r := newRouter(repository.NewMemoryIdempotencyRepository(), &calls, status, http.StatusOK)
+
+		send(r, "key-1")
+		w := send(r, "key-1")
+
+		if calls != 2 || w.Code != http.StatusOK || w.Header().Get(idempotency.HeaderReplayed) != "" {
+			t.Errorf("expected the retry after a %d to run the handler again, got %d calls and status %d", status, calls, w.Code)
+		}
+	}
+}
+
+func TestClientErrorsAreReplayed(t *testing.T) {
+	calls := 0
+	r := newRouter(repository.NewMemoryIdempotencyRepository(), &calls, http.StatusBadRequest, http.StatusOK)
+
+	send(r, "key-1")
+	w := send(r, "key-1")
+
+	if calls != 1 || w.Code != http.StatusBadRequest || w.Header().Get(idempotency.HeaderReplayed) != "true" {
+		t.Errorf("expected the 400 to be replayed, got %d calls and status %d", calls, w.Code)
+	}
+}
+
+func TestAbandonedKeyIsReusableAfterLease(t *testing.T) {
+	store := repository.NewMemoryIdempotencyRepository()
+	now := time.Now()
+
+	// A request reserved the key and never completed, e.g. the process stopped
+	abandoned := &entity.IdempotencyRecord{Key: "key-1", RequestHash: "other", CreatedAt: now, ExpiresAt: now.Add(time.Hour), LeaseExpiresAt: now.Add(50 * time.Millisecond)}
+	if _, err := store.Reserve(context.Background(), abandoned); err != nil {
+		t.Fatalf("failed to reserve key: %v", err)
+	}
+
+	calls := 0
+	r := newRouter(store, &calls, http.StatusOK)
+	if w := send(r, "key-1"); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while the lease holds, got %d", w.Code)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if w := send(r, "key-1"); w.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected the key to be reusable once the lease expired, got status %d and %d calls", w.Code, calls)
+	}
+}
+
+// failingStore fails to store the responses, and records the released keys.
+type failingStore struct {
+	idempotency.Store
+	released []string
+}
+
+func (s *failingStore) Complete(ctx context.Context, key string, token string, statusCode int, responseBody []byte) error {
+	return errors.New("store unavailable")
+}
+
+func (s *failingStore) Delete(ctx context.Context, key string, token string) error {
+	s.released = append(s.released, key)
+	return s.Store.Delete(ctx, key, token)
+}
+
+func TestFailedCompleteReleasesTheKey(t *testing.T) {
+	store := &failingStore{Store: repository.NewMemoryIdempotencyRepository()}
+	calls := 0
+	r := newRouter(store, &calls, http.StatusOK)
+
+	send(r, "key-1")
+	if len(store.released) != 1 || store.released[0] != "key-1" {
+		t.Fatalf("expected the key to be released, got %v", store.released)
+	}
+
+	// The retry is not stuck with a 409 until the key expires
+	if w := send(r, "key-1"); w.Code != http.StatusOK || calls != 2 {
+		t.Fatalf("expected the retry to run the handler again, got status %d and %d calls", w.Code, calls)
+	}
+}
+
+func TestReplayCarriesTheRetryRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	calls := 0
+	r := gin.New()
+	r.Use(requestid.RequestID())
+	r.POST("/api/send-message", idempotency.IdempotencyKey(repository.NewMemoryIdempotencyRepository()), func(c *gin.Context) {
+		calls++
+		httputil.Created(c, "Message sent successfully", gin.H{"id": "msg-1"})
+	})
+
+	var responses []httputil.HttpResponse
+	for _, requestID := range []string{"req-1", "req-2"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/send-message", strings.NewReader(`{"message":"hello"}`))
+		req.Header.Set(idempotency.HeaderIdempotencyKey, "key-1")
+		req.Header.Set(requestid.HeaderRequestID, requestID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response httputil.HttpResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.RequestID != requestID || w.Header().Get(requestid.HeaderRequestID) != requestID {
+			t.Fatalf("expected the request ID %s in the body and the header, got %q and %q", requestID, response.RequestID, w.Header().Get(requestid.HeaderRequestID))
+		}
+		responses = append(responses, response)
+	}
+
+	// The rest of the response is the stored one
+	data, _ := responses[1].Data.(map[string]interface{})
+	if calls != 1 || responses[1].Status != http.StatusCreated || data["id"] != "msg-1" {
+		t.Fatalf("expected the stored response to be replayed, got %d calls and %+v", calls, responses[1])
+	}
+}
+
+// sendAsync sends the request in the background and returns the channel its response is sent to.
+func sendAsync(r *gin.Engine, key string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- send(r, key) }()
+	return done
+}
+
+func TestLeaseExpiredDuringRequest(t *testing.T) {
+	for _, firstStatus := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(firstStatus), func(t *testing.T) {
+			t.Setenv("IDEMPOTENCY_LEASE_MS", "20")
+			gin.SetMode(gin.TestMode)
+
+			// The first request blocks until unblock is closed, the others reply at once
+			var mu sync.Mutex
+			calls := 0
+			started, unblock := make(chan struct{}), make(chan struct{})
+			r := gin.New()
+			r.POST("/api/send-message", idempotency.IdempotencyKey(repository.NewMemoryIdempotencyRepository()), func(c *gin.Context) {
+				mu.Lock()
+				calls++
+				call := calls
+				mu.Unlock()
+
+				if call == 1 {
+					close(started)
+					<-unblock
+					c.JSON(firstStatus, gin.H{"call": call})
+					return
+				}
+				c.JSON(http.StatusOK, gin.H{"call": call})
+			})
+
+			first := sendAsync(r, "key-1")
+			<-started
+			time.Sleep(30 * time.Millisecond)
+
+			// The lease of the first request expired, so the retry takes the key over
+			if w := send(r, "key-1"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"call":2`) {
+				t.Fatalf("expected the retry to run the handler, got %d %s", w.Code, w.Body.String())
+			}
+
+			// The first request completes late, it must neither replace nor release the retry's response
+			close(unblock)
+			<-first
+
+			w := send(r, "key-1")
+			if w.Header().Get(idempotency.HeaderReplayed) != "true" || !strings.Contains(w.Body.String(), `"call":2`) {
+				t.Fatalf("expected the retry's response to be replayed, got %d %s", w.Code, w.Body.String())
+			}
+			if calls != 2 {
+				t.Fatalf("expected the handler to run twice, got %d calls", calls)
+			}
+		})
+	}
+}
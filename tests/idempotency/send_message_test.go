@@ -0,0 +1,84 @@
+package idempotency_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/handler"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/service"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/idempotency"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/requestid"
+)
+
+func TestSendMessageReplayCarriesTheRetryRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// In outbox mode the message is only saved, so no broker is needed
+	messageService := service.NewMessageService(repository.NewMemoryMessageRepository(), service.PublishModeOutbox)
+	messageHandler := handler.NewMessageHandler(messageService)
+
+	r := gin.New()
+	r.Use(requestid.RequestID())
+	store := repository.NewMemoryIdempotencyRepository()
+	r.POST("/api/send-message", idempotency.IdempotencyKey(store), messageHandler.SendMessage)
+	r.POST("/api/messages/batch", idempotency.IdempotencyKey(store), messageHandler.SendMessages)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{
+			name: "send message",
+			path: "/api/send-message",
+			body: `{"sender_id":"a2f3cbe1-0e4e-4b3b-bb7e-8ff9b6d4a124","receiver_id":"f4a1e8d7-22d7-4b3a-b6d1-c9ea2ff6a9b3","message":"hello"}`,
+		},
+		{
+			name: "send messages",
+			path: "/api/messages/batch",
+			body: `[{"sender_id":"a2f3cbe1-0e4e-4b3b-bb7e-8ff9b6d4a124","receiver_id":"f4a1e8d7-22d7-4b3a-b6d1-c9ea2ff6a9b3","message":"hello"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var responses []map[string]interface{}
+			for _, requestID := range []string{"req-1", "req-2"} {
+				req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set(idempotency.HeaderIdempotencyKey, tt.name)
+				req.Header.Set(requestid.HeaderRequestID, requestID)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				if w.Code != http.StatusAccepted {
+					t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+				}
+
+				var response map[string]interface{}
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if response["request_id"] != requestID || w.Header().Get(requestid.HeaderRequestID) != requestID {
+					t.Fatalf("expected the request ID %s in the body and the header, got %v and %q", requestID, response["request_id"], w.Header().Get(requestid.HeaderRequestID))
+				}
+				responses = append(responses, response)
+			}
+
+			// The rest of the response is the stored one
+			delete(responses[0], "request_id")
+			delete(responses[1], "request_id")
+			first, _ := json.Marshal(responses[0])
+			replayed, _ := json.Marshal(responses[1])
+			if string(first) != string(replayed) {
+				t.Fatalf("expected the stored response to be replayed, got %s and %s", first, replayed)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package keyed_test
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+const topic = "messaging"
+
+func message(partition int, offset int64) kafka.Message {
+	return kafka.Message{Topic: topic, Partition: partition, Offset: offset}
+}
+
+// track tracks the offsets of the partition and returns their generation.
+func track(tracker *kafkautil.OffsetTracker, partition int, offsets ...int64) int {
+	generation := 0
+	for _, offset := range offsets {
+		generation = tracker.Track(message(partition, offset))
+	}
+	return generation
+}
+
+func assertCommit(t *testing.T, commit kafka.Message, ok bool, partition int, offset int64) {
+	t.Helper()
+
+	if !ok {
+		t.Fatalf("expected offset %d of partition %d to be committable", offset, partition)
+	}
+	if commit.Topic != topic || commit.Partition != partition || commit.Offset != offset {
+		t.Fatalf("expected to commit %s/%d@%d, got %s/%d@%d", topic, partition, offset, commit.Topic, commit.Partition, commit.Offset)
+	}
+}
+
+func TestOffsetTrackerCommitsInOrder(t *testing.T) {
+	tracker := kafkautil.NewOffsetTracker()
+	generation := track(tracker, 0, 10, 11, 12, 13)
+
+	// Later messages complete first, nothing can be committed while 10 is in flight
+	for _, offset := range []int64{12, 11} {
+		if commit, ok := tracker.Done(message(0, offset), generation); ok {
+			t.Fatalf("expected nothing to be committable while 10 is in flight, got %d", commit.Offset)
+		}
+	}
+
+	// Completing the lowest offset commits up to the highest contiguous completed one
+	commit, ok := tracker.Done(message(0, 10), generation)
+	assertCommit(t, commit, ok, 0, 12)
+
+	commit, ok = tracker.Done(message(0, 13), generation)
+	assertCommit(t, commit, ok, 0, 13)
+}
+
+func TestOffsetTrackerPartitionsAreIndependent(t *testing.T) {
+	tracker := kafkautil.NewOffsetTracker()
+	gen0 := track(tracker, 0, 5, 6)
+	gen1 := track(tracker, 1, 100)
+
+	// Partition 0 is held back by offset 5, partition 1 is not
+	if _, ok := tracker.Done(message(0, 6), gen0); ok {
+		t.Fatal("expected partition 0 to be held back by offset 5")
+	}
+	commit, ok := tracker.Done(message(1, 100), gen1)
+	assertCommit(t, commit, ok, 1, 100)
+}
+
+func TestOffsetTrackerUnknownPartition(t *testing.T) {
+	tracker := kafkautil.NewOffsetTracker()
+
+	if _, ok := tracker.Done(message(3, 1), 0); ok {
+		t.Fatal("expected a message that was not tracked not to be committable")
+	}
+}
+
+func TestOffsetTrackerResetsAfterRebalance(t *testing.T) {
+	tracker := kafkautil.NewOffsetTracker()
+	before := track(tracker, 0, 10, 11, 12)
+
+	// After a rebalance the partition is fetched again from its committed offset
+	after := track(tracker, 0, 10, 11)
+	if after == before {
+		t.Fatal("expected a new generation once the partition is fetched again from an earlier offset")
+	}
+
+	// Messages of the previous generation complete without committing anything,
+	// even for offsets that are in flight again in the new generation
+	for _, offset := range []int64{10, 11, 12} {
+		if commit, ok := tracker.Done(message(0, offset), before); ok {
+			t.Fatalf("expected the previous generation not to commit, got %d", commit.Offset)
+		}
+	}
+	if _, ok := tracker.Done(message(0, 11), after); ok {
+		t.Fatal("expected the new generation to be held back by offset 10")
+	}
+
+	commit, ok := tracker.Done(message(0, 10), after)
+	assertCommit(t, commit, ok, 0, 11)
+}
@@ -0,0 +1,169 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/middleware"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+// captureJSON sets up the loggers with the JSON format and returns the buffer they write to.
+func captureJSON(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_LEVEL", "debug")
+	logger.Exit()
+	t.Cleanup(logger.Exit)
+
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	return &buf
+}
+
+// entries decodes the JSON log lines written to buf.
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var result []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %q: %v", line, err)
+		}
+		result = append(result, entry)
+	}
+
+	return result
+}
+
+func TestGetLevel(t *testing.T) {
+	cases := map[string]logrus.Level{
+		"":        logrus.InfoLevel,
+		"debug":   logrus.DebugLevel,
+		"WARN":    logrus.WarnLevel,
+		"error":   logrus.ErrorLevel,
+		"verbose": logrus.InfoLevel,
+	}
+
+	for value, want := range cases {
+		if got := logger.GetLevel(value); got != want {
+			t.Errorf("GetLevel(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestGetFormatter(t *testing.T) {
+	if _, ok := logger.GetFormatter("JSON").(*logrus.JSONFormatter); !ok {
+		t.Error("expected the JSON formatter for JSON")
+	}
+	if _, ok := logger.GetFormatter("").(*logrus.TextFormatter); !ok {
+		t.Error("expected the text formatter by default")
+	}
+}
+
+func TestLevelFromEnv(t *testing.T) {
+	buf := captureJSON(t)
+	t.Setenv("LOG_LEVEL", "warn")
+	logger.Exit()
+	logger.SetOutput(buf)
+
+	logger.Info("not logged", nil)
+	logger.Warn("logged", nil)
+
+	got := entries(t, buf)
+	if len(got) != 1 || got[0]["msg"] != "logged" || got[0]["level"] != "warning" {
+		t.Fatalf("expected only the warning to be logged, got %v", got)
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	buf := captureJSON(t)
+
+	ctx := logger.WithFields(context.Background(), logrus.Fields{logger.FieldRequestID: "req-1"})
+	ctx = logger.WithFields(ctx, logrus.Fields{logger.FieldMessageID: "msg-1"})
+
+	logger.InfoContext(ctx, "Sending message", logrus.Fields{"sender_id": "alice"})
+
+	got := entries(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(got))
+	}
+	for key, want := range map[string]string{
+		logger.FieldRequestID: "req-1",
+		logger.FieldMessageID: "msg-1",
+		"sender_id":           "alice",
+	} {
+		if got[0][key] != want {
+			t.Errorf("expected %s %q, got %v", key, want, got[0][key])
+		}
+	}
+}
+
+func TestTraceIDs(t *testing.T) {
+	buf := captureJSON(t)
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	logger.ErrorContext(ctx, "Failed", nil)
+
+	got := entries(t, buf)
+	if got[0][logger.FieldTraceID] != traceID.String() || got[0][logger.FieldSpanID] != spanID.String() {
+		t.Fatalf("expected the trace and span IDs, got %v", got[0])
+	}
+}
+
+func TestLoggingMiddlewareAddsMessageFields(t *testing.T) {
+	buf := captureJSON(t)
+
+	msg := kafka.Message{
+		Topic:     "messaging",
+		Partition: 2,
+		Offset:    42,
+		Headers:   []kafka.Header{{Key: kafkautil.HeaderEventID, Value: []byte("event-1")}},
+	}
+
+	handler := kafkautil.Chain(func(ctx context.Context, worker string, msg kafka.Message) error {
+		logger.InfoContext(ctx, "Reading message", nil)
+		return errors.New("boom")
+	}, middleware.Logging())
+
+	if err := handler(context.Background(), "worker-1", msg); err == nil {
+		t.Fatal("expected the handler error")
+	}
+
+	got := entries(t, buf)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(got))
+	}
+	for _, entry := range got {
+		if entry[logger.FieldWorker] != "worker-1" || entry[logger.FieldTopic] != "messaging" ||
+			entry[logger.FieldPartition] != float64(2) || entry[logger.FieldOffset] != float64(42) ||
+			entry[logger.FieldMessageID] != "event-1" {
+			t.Errorf("expected the message fields, got %v", entry)
+		}
+	}
+	if got[1]["level"] != "error" || got[1][logger.FieldError] != "boom" {
+		t.Errorf("expected the failure at error level, got %v", got[1])
+	}
+}
@@ -0,0 +1,262 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/outbox"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	consumer "github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/middleware"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/metrics"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+// value returns the value of the metric with the labels, 0 if it was not recorded.
+// Histograms return their sample count.
+func value(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if matches(metric, labels) {
+				switch {
+				case metric.GetCounter() != nil:
+					return metric.GetCounter().GetValue()
+				case metric.GetGauge() != nil:
+					return metric.GetGauge().GetValue()
+				case metric.GetHistogram() != nil:
+					return float64(metric.GetHistogram().GetSampleCount())
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+func matches(metric *dto.Metric, labels map[string]string) bool {
+	found := 0
+	for _, pair := range metric.GetLabel() {
+		if want, exists := labels[pair.GetName()]; exists {
+			if pair.GetValue() != want {
+				return false
+			}
+			found++
+		}
+	}
+
+	return found == len(labels)
+}
+
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(metrics.Requests())
+	r.GET("/metrics", metrics.Handler())
+	r.GET("/api/messages/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+	})
+
+	return r
+}
+
+func TestHTTPRequestsPerRoute(t *testing.T) {
+	r := newRouter()
+	labels := map[string]string{"method": "GET", "route": "/api/messages/:id", "status": "200"}
+	before := value(t, "messaging_http_requests_total", labels)
+
+	for _, path := range []string{"/api/messages/1", "/api/messages/2", "/unknown"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Requests are labelled with the route pattern, not the path
+	if got := value(t, "messaging_http_requests_total", labels) - before; got != 2 {
+		t.Fatalf("expected 2 requests for the route, got %v", got)
+	}
+	if got := value(t, "messaging_http_requests_total", map[string]string{"route": "unmatched", "status": "404"}); got < 1 {
+		t.Fatalf("expected the unknown path to be counted as unmatched, got %v", got)
+	}
+	if got := value(t, "messaging_http_request_duration_seconds", map[string]string{"route": "/api/messages/:id"}); got < 2 {
+		t.Fatalf("expected the request durations to be observed, got %v", got)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	r := newRouter()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/messages/1", nil))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	body, _ := io.ReadAll(w.Body)
+	for _, name := range []string{"messaging_http_requests_total", "go_goroutines"} {
+		if !strings.Contains(string(body), name) {
+			t.Fatalf("expected %s in the metrics", name)
+		}
+	}
+}
+
+func TestConsumerMetrics(t *testing.T) {
+	handlerErr := errors.New("handler failed")
+	handler := kafkautil.Chain(func(ctx context.Context, worker string, msg kafka.Message) error {
+		if string(msg.Value) == "fail" {
+			return handlerErr
+		}
+		return nil
+	}, middleware.Timing(metrics.ObserveHandled))
+
+	labels := map[string]string{"worker": "Worker-7", "topic": "metrics-test"}
+	for _, value := range []string{"ok", "ok", "fail"} {
+		handler(context.Background(), "Worker-7", kafka.Message{Topic: "metrics-test", Value: []byte(value)})
+	}
+
+	if got := value(t, "messaging_consumer_messages_consumed_total", labels); got != 3 {
+		t.Fatalf("expected 3 consumed messages, got %v", got)
+	}
+	if got := value(t, "messaging_consumer_handler_errors_total", labels); got != 1 {
+		t.Fatalf("expected 1 handler error, got %v", got)
+	}
+	if got := value(t, "messaging_consumer_handling_duration_seconds", labels); got != 3 {
+		t.Fatalf("expected 3 handling durations, got %v", got)
+	}
+}
+
+func TestConsumerHandlerTimesRecoveredPanics(t *testing.T) {
+	logger.SetOutput(io.Discard)
+
+	handler := consumer.Handler(func(ctx context.Context, worker string, msg kafka.Message) error {
+		panic("handler bug")
+	}, time.Second)
+
+	err := handler(context.Background(), "Worker-8", kafka.Message{Topic: "metrics-test", Value: []byte(`{}`)})
+	if err == nil || !strings.Contains(err.Error(), "handler bug") {
+		t.Fatalf("expected the panic to be returned as an error, got %v", err)
+	}
+
+	// Timing wraps Recovery, so the recovered panic is measured as a failed message
+	labels := map[string]string{"worker": "Worker-8", "topic": "metrics-test"}
+	if got := value(t, "messaging_consumer_messages_consumed_total", labels); got != 1 {
+		t.Fatalf("expected 1 consumed message, got %v", got)
+	}
+	if got := value(t, "messaging_consumer_handler_errors_total", labels); got != 1 {
+		t.Fatalf("expected 1 handler error, got %v", got)
+	}
+}
+
+func TestDuplicatesDroppedMetric(t *testing.T) {
+	logger.SetOutput(io.Discard)
+	metrics.DuplicatesDropped(middleware.DuplicatesDropped)
+
+	calls := 0
+	handler := kafkautil.Chain(func(ctx context.Context, worker string, msg kafka.Message) error {
+		calls++
+		return nil
+	}, middleware.Dedup(repository.NewMemoryProcessedEventRepository(10), time.Hour))
+
+	msg := kafka.Message{Topic: "metrics-test", Headers: []kafka.Header{kafkautil.EventIDHeader("event-1")}}
+	for i := 0; i < 3; i++ {
+		if err := handler(context.Background(), "Worker-9", msg); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected the handler to be called once, got %d", calls)
+	}
+	if got := value(t, "messaging_consumer_duplicates_dropped_total", nil); got != 2 {
+		t.Fatalf("expected 2 dropped duplicates, got %v", got)
+	}
+}
+
+func TestConsumerLagPerPartition(t *testing.T) {
+	metrics.ObserveLag(kafka.Message{Topic: "metrics-test", Partition: 0, Offset: 41, HighWaterMark: 50})
+	metrics.ObserveLag(kafka.Message{Topic: "metrics-test", Partition: 1, Offset: 9, HighWaterMark: 10})
+
+	// Messages without a high watermark do not change the lag
+	metrics.ObserveLag(kafka.Message{Topic: "metrics-test", Partition: 0, Offset: 45})
+
+	if got := value(t, "messaging_consumer_lag", map[string]string{"topic": "metrics-test", "partition": "0"}); got != 8 {
+		t.Fatalf("expected a lag of 8 on partition 0, got %v", got)
+	}
+	if got := value(t, "messaging_consumer_lag", map[string]string{"topic": "metrics-test", "partition": "1"}); got != 0 {
+		t.Fatalf("expected no lag on partition 1, got %v", got)
+	}
+}
+
+// failingWriter fails the first writes.
+type failingWriter struct {
+	failNext int
+}
+
+func (w *failingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.failNext > 0 {
+		w.failNext--
+		return errors.New("broker unavailable")
+	}
+	return nil
+}
+
+func TestProducerMetrics(t *testing.T) {
+	messageRepository := repository.NewMemoryMessageRepository()
+	outboxRepository, err := repository.NewOutboxRepository(messageRepository)
+	if err != nil {
+		t.Fatalf("failed to create outbox repository: %v", err)
+	}
+
+	writer := &failingWriter{failNext: 1}
+	relay := outbox.NewRelay(outboxRepository, messageRepository, func(topic string) (outbox.Writer, error) {
+		return writer, nil
+	}, outbox.RelayConfig{BatchSize: 10, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	message := &entity.Message{ID: "m1", SenderID: "sender", ReceiverID: "receiver", Message: "hello", Timestamp: time.Now()}
+	entry := &entity.OutboxEntry{MessageID: "m1", Topic: "metrics-producer", Key: "m1", Value: []byte(`{}`), Status: entity.OutboxStatusPending, CreatedAt: time.Now()}
+	if err := messageRepository.SaveWithOutbox(context.Background(), message, entry); err != nil {
+		t.Fatalf("failed to save message with outbox: %v", err)
+	}
+
+	// The first attempt fails, the retry succeeds
+	if _, err := relay.PublishPending(context.Background()); err == nil {
+		t.Fatal("expected the first attempt to fail")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if published, err := relay.PublishPending(context.Background()); err != nil || published != 1 {
+		t.Fatalf("expected the retry to publish the entry, got %d, %v", published, err)
+	}
+
+	labels := map[string]string{"topic": "metrics-producer"}
+	if got := value(t, "messaging_producer_publish_attempts_total", labels); got != 2 {
+		t.Fatalf("expected 2 attempts, got %v", got)
+	}
+	if got := value(t, "messaging_producer_publish_retries_total", labels); got != 1 {
+		t.Fatalf("expected 1 retry, got %v", got)
+	}
+	if got := value(t, "messaging_producer_publish_failures_total", labels); got != 1 {
+		t.Fatalf("expected 1 failure, got %v", got)
+	}
+}
@@ -0,0 +1,363 @@
+package outbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/outbox"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+)
+
+// TestMain discards the log output, so the tests do not write to the logs directory
+func TestMain(m *testing.M) {
+	logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// memoryWriter records the messages written to it, and fails the next writes while failNext is positive.
+// Messages whose key is in failKeys always fail with its error.
+type memoryWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	failNext int
+	failKeys map[string]error
+}
+
+func (w *memoryWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failNext > 0 {
+		w.failNext--
+		return errors.New("broker unavailable")
+	}
+	for _, msg := range msgs {
+		if err, fails := w.failKeys[string(msg.Key)]; fails {
+			return kafka.WriteErrors{err}
+		}
+	}
+
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *memoryWriter) keys() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	keys := make([]string, 0, len(w.messages))
+	for _, msg := range w.messages {
+		keys = append(keys, string(msg.Key))
+	}
+	return keys
+}
+
+func newRelay(t *testing.T, writer *memoryWriter, config outbox.RelayConfig) (*outbox.Relay, repository.MessageRepository) {
+	t.Helper()
+
+	messageRepository := repository.NewMemoryMessageRepository()
+	outboxRepository, err := repository.NewOutboxRepository(messageRepository)
+	if err != nil {
+		t.Fatalf("failed to create outbox repository: %v", err)
+	}
+
+	getWriter := func(topic string) (outbox.Writer, error) {
+		return writer, nil
+	}
+
+	return outbox.NewRelay(outboxRepository, messageRepository, getWriter, config), messageRepository
+}
+
+func saveWithOutbox(t *testing.T, messageRepository repository.MessageRepository, id string) {
+	t.Helper()
+
+	message := &entity.Message{
+		ID:         id,
+		SenderID:   "sender",
+		ReceiverID: "receiver",
+		Message:    "hello",
+		Timestamp:  time.Now(),
+		Status:     entity.MessageStatusPending,
+	}
+	entry := &entity.OutboxEntry{
+		MessageID: id,
+		Topic:     "messaging",
+		Key:       id,
+		Value:     []byte(`{"id":"` + id + `"}`),
+		Headers:   entity.OutboxHeaders{{Key: "event-id", Value: []byte(id)}},
+		Status:    entity.OutboxStatusPending,
+		CreatedAt: time.Now(),
+	}
+
+	if err := messageRepository.SaveWithOutbox(context.Background(), message, entry); err != nil {
+		t.Fatalf("failed to save message %s with outbox: %v", id, err)
+	}
+}
+
+func TestRelayPublishesInOrderAndMarksDone(t *testing.T) {
+	writer := &memoryWriter{}
+	relay, messageRepository := newRelay(t, writer, outbox.RelayConfig{BatchSize: 10, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	ctx := context.Background()
+
+	for _, id := range []string{"m1", "m2", "m3"} {
+		saveWithOutbox(t, messageRepository, id)
+	}
+
+	published, err := relay.PublishPending(ctx)
+	if err != nil {
+		t.Fatalf("PublishPending returned error: %v", err)
+	}
+	if published != 3 {
+		t.Fatalf("expected 3 published entries, got %d", published)
+	}
+
+	if got := writer.keys(); len(got) != 3 || got[0] != "m1" || got[1] != "m2" || got[2] != "m3" {
+		t.Fatalf("expected entries in order [m1 m2 m3], got %v", got)
+	}
+
+	for _, id := range []string{"m1", "m2", "m3"} {
+		message, err := messageRepository.FindByID(ctx, id)
+		if err != nil {
+			t.Fatalf("failed to find message %s: %v", id, err)
+		}
+		if message.Status != entity.MessageStatusSent {
+			t.Errorf("expected message %s to be %s, got %s", id, entity.MessageStatusSent, message.Status)
+		}
+	}
+
+	status, err := relay.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status returned error: %v", err)
+	}
+	if status.Pending != 0 || status.Published != 3 {
+		t.Errorf("expected 0 pending and 3 published, got %d pending and %d published", status.Pending, status.Published)
+	}
+
+	// Published entries are not published again
+	if published, err := relay.PublishPending(ctx); err != nil || published != 0 {
+		t.Errorf("expected nothing to publish, got %d published and error %v", published, err)
+	}
+}
+
+func TestRelayRetriesWithBackoffAndKeepsOrder(t *testing.T) {
+	writer := &memoryWriter{failNext: 1}
+	relay, messageRepository := newRelay(t, writer, outbox.RelayConfig{BatchSize: 10, BaseBackoff: 50 * time.Millisecond, MaxBackoff: time.Second})
+	ctx := context.Background()
+
+	saveWithOutbox(t, messageRepository, "m1")
+	saveWithOutbox(t, messageRepository, "m2")
+
+	// The first entry fails, and the second must not overtake it
+	published, err := relay.PublishPending(ctx)
+	if err == nil {
+		t.Fatal("expected PublishPending to return the publish error")
+	}
+	if published != 0 || len(writer.keys()) != 0 {
+		t.Fatalf("expected nothing published after the failure, got %v", writer.keys())
+	}
+
+	status, err := relay.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status returned error: %v", err)
+	}
+	if status.Pending != 2 || status.Failures != 1 || status.LastError == "" {
+		t.Errorf("expected 2 pending and 1 failure with an error, got %+v", status)
+	}
+
+	// The failed entry is not retried before its backoff has passed
+	if published, err := relay.PublishPending(ctx); err != nil || published != 0 {
+		t.Fatalf("expected nothing published during backoff, got %d published and error %v", published, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	published, err = relay.PublishPending(ctx)
+	if err != nil {
+		t.Fatalf("PublishPending returned error: %v", err)
+	}
+	if got := writer.keys(); published != 2 || len(got) != 2 || got[0] != "m1" || got[1] != "m2" {
+		t.Fatalf("expected entries in order [m1 m2] after the retry, got %v", got)
+	}
+}
+
+func TestRelayPauseAndResume(t *testing.T) {
+	writer := &memoryWriter{}
+	relay, messageRepository := newRelay(t, writer, outbox.RelayConfig{BatchSize: 10, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	ctx := context.Background()
+
+	saveWithOutbox(t, messageRepository, "m1")
+
+	relay.Pause()
+	if published, err := relay.PublishPending(ctx); err != nil || published != 0 {
+		t.Fatalf("expected nothing published while paused, got %d published and error %v", published, err)
+	}
+
+	status, err := relay.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status returned error: %v", err)
+	}
+	if !status.Paused || status.Pending != 1 {
+		t.Errorf("expected paused relay with 1 pending entry, got %+v", status)
+	}
+
+	relay.Resume()
+	if published, err := relay.PublishPending(ctx); err != nil || published != 1 {
+		t.Fatalf("expected 1 published after resume, got %d published and error %v", published, err)
+	}
+}
+
+func TestRelayRunStopsOnContextCancel(t *testing.T) {
+	writer := &memoryWriter{}
+	relay, messageRepository := newRelay(t, writer, outbox.RelayConfig{PollInterval: 5 * time.Millisecond, BatchSize: 10, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	saveWithOutbox(t, messageRepository, "m1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.After(time.Second)
+	for len(writer.keys()) == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("expected the relay to publish the pending entry")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return after the context was cancelled")
+	}
+}
+
+func TestRelayKeepsHeaderOrder(t *testing.T) {
+	writer := &memoryWriter{}
+	relay, messageRepository := newRelay(t, writer, outbox.RelayConfig{BatchSize: 10, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	headers := entity.OutboxHeaders{
+		{Key: "x-b", Value: []byte("1")},
+		{Key: "x-a", Value: []byte("2")},
+		{Key: "x-b", Value: []byte("3")},
+	}
+	message := &entity.Message{ID: "m1", SenderID: "sender", ReceiverID: "receiver", Message: "hello", Timestamp: time.Now(), Status: entity.MessageStatusPending}
+	entry := &entity.OutboxEntry{MessageID: "m1", Topic: "messaging", Key: "m1", Value: []byte(`{}`), Headers: headers, Status: entity.OutboxStatusPending, CreatedAt: time.Now()}
+	if err := messageRepository.SaveWithOutbox(context.Background(), message, entry); err != nil {
+		t.Fatalf("failed to save message with outbox: %v", err)
+	}
+
+	if _, err := relay.PublishPending(context.Background()); err != nil {
+		t.Fatalf("PublishPending returned error: %v", err)
+	}
+
+	// Repeated keys are kept, in the order they were saved
+	got := writer.messages[0].Headers
+	if len(got) < len(headers) {
+		t.Fatalf("expected the %d saved headers, got %v", len(headers), got)
+	}
+	for i, h := range headers {
+		if got[i].Key != h.Key || string(got[i].Value) != string(h.Value) {
+			t.Fatalf("expected header %d to be %s=%s, got %v", i, h.Key, h.Value, got)
+		}
+	}
+}
+
+func TestOutboxHeadersReadLegacyObject(t *testing.T) {
+	var entry entity.OutboxEntry
+	if err := json.Unmarshal([]byte(`{"id":1,"headers":{"event-id":"m1","content-type":"application/json"}}`), &entry); err != nil {
+		t.Fatalf("failed to decode entry: %v", err)
+	}
+
+	want := entity.OutboxHeaders{{Key: "content-type", Value: []byte("application/json")}, {Key: "event-id", Value: []byte("m1")}}
+	if len(entry.Headers) != len(want) {
+		t.Fatalf("expected %v, got %v", want, entry.Headers)
+	}
+	for i := range want {
+		if entry.Headers[i].Key != want[i].Key || string(entry.Headers[i].Value) != string(want[i].Value) {
+			t.Fatalf("expected the legacy headers sorted by key, got %v", entry.Headers)
+		}
+	}
+}
+
+func TestRelayGivesUpOnPermanentFailures(t *testing.T) {
+	writer := &memoryWriter{failKeys: map[string]error{"m1": kafka.MessageSizeTooLarge}}
+	relay, messageRepository := newRelay(t, writer, outbox.RelayConfig{BatchSize: 10, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxAttempts: 3})
+	ctx := context.Background()
+
+	saveWithOutbox(t, messageRepository, "m1")
+	saveWithOutbox(t, messageRepository, "m2")
+
+	// The entry holds back the one behind it until its attempts are used up
+	for attempt := 1; attempt < 3; attempt++ {
+		if _, err := relay.PublishPending(ctx); err == nil {
+			t.Fatalf("expected attempt %d to fail", attempt)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	status, err := relay.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status returned error: %v", err)
+	}
+	if status.Stuck == nil || status.Stuck.MessageID != "m1" || status.Stuck.Attempts != 2 || status.Stuck.LastError == "" {
+		t.Fatalf("expected the stuck entry in the status, got %+v", status.Stuck)
+	}
+	if len(writer.keys()) != 0 {
+		t.Fatalf("expected nothing published behind the stuck entry, got %v", writer.keys())
+	}
+
+	// The last attempt gives up on the entry, and the next poll publishes the one behind it
+	if _, err := relay.PublishPending(ctx); err == nil {
+		t.Fatal("expected the last attempt to fail")
+	}
+	if published, err := relay.PublishPending(ctx); err != nil || published != 1 {
+		t.Fatalf("expected the entry behind the dead one to be published, got %d published and error %v", published, err)
+	}
+
+	status, _ = relay.Status(ctx)
+	if status.Stuck != nil || status.Pending != 0 || status.Dead != 1 || len(status.DeadEntries) != 1 || status.DeadEntries[0].MessageID != "m1" {
+		t.Fatalf("expected the dead entry in the status, got %+v", status)
+	}
+	if status.DeadEntries[0].Status != entity.OutboxStatusDead || status.DeadEntries[0].Attempts != 3 {
+		t.Errorf("expected the entry dead after 3 attempts, got %+v", status.DeadEntries[0])
+	}
+
+	if message, _ := messageRepository.FindByID(ctx, "m1"); message.Status != entity.MessageStatusFailed {
+		t.Errorf("expected the message of the dead entry to be failed, got %s", message.Status)
+	}
+}
+
+func TestRelayKeepsRetryingTransientFailures(t *testing.T) {
+	writer := &memoryWriter{failKeys: map[string]error{"m1": kafka.LeaderNotAvailable}}
+	relay, messageRepository := newRelay(t, writer, outbox.RelayConfig{BatchSize: 10, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxAttempts: 2})
+	ctx := context.Background()
+
+	saveWithOutbox(t, messageRepository, "m1")
+
+	for attempt := 1; attempt <= 4; attempt++ {
+		if _, err := relay.PublishPending(ctx); err == nil {
+			t.Fatalf("expected attempt %d to fail", attempt)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	status, _ := relay.Status(ctx)
+	if status.Dead != 0 || status.Pending != 1 || status.Stuck == nil || status.Stuck.Attempts != 4 {
+		t.Fatalf("expected the entry to stay pending while the broker is unavailable, got %+v", status)
+	}
+}
@@ -0,0 +1,136 @@
+package publish_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/service"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+// asyncWriter queues the messages written to it until flush reports their delivery, like an asynchronous writer.
+type asyncWriter struct {
+	mu         sync.Mutex
+	completion async.CompletionFunc
+	queued     []kafka.Message
+	queueErr   error
+}
+
+func (w *asyncWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.queueErr != nil {
+		return w.queueErr
+	}
+	w.queued = append(w.queued, msgs...)
+	return nil
+}
+
+// flush reports the delivery of the queued messages, failed with err if it is set.
+func (w *asyncWriter) flush(err error) {
+	w.mu.Lock()
+	msgs := w.queued
+	w.queued = nil
+	w.mu.Unlock()
+
+	w.completion(msgs, err)
+}
+
+func newAsyncService(t *testing.T, writer *asyncWriter) (service.MessageService, repository.MessageRepository) {
+	t.Helper()
+
+	kafkautil.SetAsyncWriterProvider(func(topic string, completion async.CompletionFunc) (kafkautil.Writer, error) {
+		writer.completion = completion
+		return writer, nil
+	})
+	t.Cleanup(func() { kafkautil.SetAsyncWriterProvider(nil) })
+
+	messageRepository := repository.NewMemoryMessageRepository()
+	messageService := service.NewMessageService(messageRepository, service.PublishModeAsync)
+	kafkautil.OnDelivery(messageService.HandleDelivery)
+	t.Cleanup(func() { kafkautil.OnDelivery(nil) })
+
+	return messageService, messageRepository
+}
+
+func statusOf(t *testing.T, messageRepository repository.MessageRepository, id string) string {
+	t.Helper()
+
+	stored, err := messageRepository.FindByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("failed to find message %s: %v", id, err)
+	}
+	return stored.Status
+}
+
+func TestAsyncDeliveryUpdatesStatus(t *testing.T) {
+	cases := []struct {
+		name        string
+		deliveryErr error
+		want        string
+	}{
+		{"delivered", nil, entity.MessageStatusSent},
+		{"failed", errors.New("broker unavailable"), entity.MessageStatusFailed},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			writer := &asyncWriter{}
+			messageService, messageRepository := newAsyncService(t, writer)
+
+			message := &entity.Message{SenderID: "alice", ReceiverID: "bob", Message: "hello"}
+			if err := messageService.SendMessage(context.Background(), message); err != nil {
+				t.Fatalf("failed to send message: %v", err)
+			}
+
+			// The message stays pending until the writer reports its delivery
+			if message.Status != entity.MessageStatusPending || statusOf(t, messageRepository, message.ID) != entity.MessageStatusPending {
+				t.Fatalf("expected the message to be pending, got %s", message.Status)
+			}
+
+			writer.flush(c.deliveryErr)
+			if got := statusOf(t, messageRepository, message.ID); got != c.want {
+				t.Fatalf("expected status %s after the delivery, got %s", c.want, got)
+			}
+		})
+	}
+}
+
+func TestAsyncBatchDeliveryUpdatesEachMessage(t *testing.T) {
+	writer := &asyncWriter{}
+	messageService, messageRepository := newAsyncService(t, writer)
+
+	results, err := messageService.SendMessages(context.Background(), newBatch())
+	if err != nil {
+		t.Fatalf("failed to send batch: %v", err)
+	}
+
+	writer.flush(nil)
+	for _, result := range results {
+		if got := statusOf(t, messageRepository, result.ID); got != entity.MessageStatusSent {
+			t.Errorf("expected message %s to be sent, got %s", result.ID, got)
+		}
+	}
+}
+
+func TestAsyncQueueFailureFailsMessages(t *testing.T) {
+	writer := &asyncWriter{queueErr: errors.New("writer closed")}
+	messageService, messageRepository := newAsyncService(t, writer)
+
+	message := &entity.Message{SenderID: "alice", ReceiverID: "bob", Message: "hello"}
+	if err := messageService.SendMessage(context.Background(), message); err == nil {
+		t.Fatal("expected the send to fail")
+	}
+
+	if got := statusOf(t, messageRepository, message.ID); got != entity.MessageStatusFailed {
+		t.Fatalf("expected the message that could not be queued to be failed, got %s", got)
+	}
+}
@@ -0,0 +1,191 @@
+package publish_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/service"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+// TestMain discards the log output, so the tests do not write to the logs directory
+func TestMain(m *testing.M) {
+	logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// memoryWriter records the messages written to it. Each call fails the messages whose key has an error
+// in the next entry of failures, the other messages of the call are written.
+type memoryWriter struct {
+	mu       sync.Mutex
+	written  []string
+	calls    [][]string
+	failures []map[string]error
+}
+
+func (w *memoryWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var failures map[string]error
+	if len(w.failures) > 0 {
+		failures, w.failures = w.failures[0], w.failures[1:]
+	}
+
+	keys := make([]string, 0, len(msgs))
+	writeErrs := make(kafka.WriteErrors, len(msgs))
+	for i, msg := range msgs {
+		keys = append(keys, string(msg.Key))
+		if err, failed := failures[string(msg.Key)]; failed {
+			writeErrs[i] = err
+			continue
+		}
+		w.written = append(w.written, string(msg.Key))
+	}
+	w.calls = append(w.calls, keys)
+
+	if writeErrs.Count() > 0 {
+		return writeErrs
+	}
+	return nil
+}
+
+func useWriter(t *testing.T, writer *memoryWriter) {
+	t.Helper()
+
+	kafkautil.SetWriterProvider(func(topic string) (kafkautil.Writer, error) {
+		return writer, nil
+	})
+	t.Cleanup(func() { kafkautil.SetWriterProvider(nil) })
+}
+
+func messages(keys ...string) []kafka.Message {
+	msgs := make([]kafka.Message, 0, len(keys))
+	for _, key := range keys {
+		msgs = append(msgs, kafka.Message{Key: []byte(key), Value: []byte(`{}`)})
+	}
+	return msgs
+}
+
+func TestPublishRetriesOnlyFailedMessages(t *testing.T) {
+	writer := &memoryWriter{failures: []map[string]error{{"b": kafka.LeaderNotAvailable}}}
+	useWriter(t, writer)
+
+	if err := kafkautil.PublishMessages("messaging", messages("a", "b", "c")...); err != nil {
+		t.Fatalf("expected the retry to publish the failed message, got %v", err)
+	}
+
+	if len(writer.calls) != 2 || len(writer.calls[1]) != 1 || writer.calls[1][0] != "b" {
+		t.Fatalf("expected only the failed message to be written again, got calls %v", writer.calls)
+	}
+	if len(writer.written) != 3 {
+		t.Fatalf("expected each message to be written once, got %v", writer.written)
+	}
+}
+
+func TestPublishReportsLastErrorOfEachMessage(t *testing.T) {
+	writer := &memoryWriter{failures: []map[string]error{
+		{"a": kafka.MessageSizeTooLarge, "b": kafka.LeaderNotAvailable},
+		{"b": kafka.LeaderNotAvailable},
+	}}
+	useWriter(t, writer)
+
+	err := kafkautil.PublishMessages("messaging", messages("a", "b", "c")...)
+
+	// The non-retryable failure is not written again, the retryable one succeeds on the third attempt
+	var writeErrs kafka.WriteErrors
+	if !errors.As(err, &writeErrs) || len(writeErrs) != 3 {
+		t.Fatalf("expected an error per message, got %v", err)
+	}
+	if !errors.Is(writeErrs[0], kafka.MessageSizeTooLarge) || writeErrs[1] != nil || writeErrs[2] != nil {
+		t.Fatalf("expected only the first message to fail, got %v", writeErrs)
+	}
+	if kafkautil.IsRetryable(err) {
+		t.Fatal("expected the error not to be retryable")
+	}
+	if len(writer.calls) != 3 {
+		t.Fatalf("expected 3 attempts, got %v", writer.calls)
+	}
+}
+
+func newBatch() []entity.Message {
+	return []entity.Message{
+		{SenderID: "alice", ReceiverID: "bob", Message: "first"},
+		{SenderID: "alice", ReceiverID: "carol", Message: "second"},
+		{SenderID: "alice", ReceiverID: "dave", Message: "third"},
+	}
+}
+
+func TestBatchStatusesAfterRetries(t *testing.T) {
+	batch := newBatch()
+	writer := &memoryWriter{failures: []map[string]error{
+		{
+			entity.DefaultConversationID("alice", "carol"): kafka.LeaderNotAvailable,
+			entity.DefaultConversationID("alice", "dave"):  kafka.MessageSizeTooLarge,
+		},
+	}}
+	useWriter(t, writer)
+
+	messageRepository := repository.NewMemoryMessageRepository()
+	results, err := service.NewMessageService(messageRepository, service.PublishModeSync).SendMessages(context.Background(), batch)
+	if err == nil {
+		t.Fatal("expected the batch to report the failed message")
+	}
+
+	// The message that succeeded on the retry is sent, only the permanent failure is failed
+	want := []string{entity.MessageStatusSent, entity.MessageStatusSent, entity.MessageStatusFailed}
+	for i, result := range results {
+		stored, _ := messageRepository.FindByID(context.Background(), result.ID)
+		if result.Status != want[i] || stored == nil || stored.Status != want[i] {
+			t.Errorf("expected message %d to be %s, got result %s", i, want[i], result.Status)
+		}
+	}
+}
+
+// failingRepository fails to save the message with the given receiver.
+type failingRepository struct {
+	repository.MessageRepository
+	failReceiver string
+}
+
+func (r *failingRepository) Save(ctx context.Context, message *entity.Message) error {
+	if message.ReceiverID == r.failReceiver {
+		return errors.New("store unavailable")
+	}
+	return r.MessageRepository.Save(ctx, message)
+}
+
+func TestBatchSaveFailureFailsSavedMessages(t *testing.T) {
+	batch := newBatch()
+	writer := &memoryWriter{}
+	useWriter(t, writer)
+
+	messageRepository := &failingRepository{MessageRepository: repository.NewMemoryMessageRepository(), failReceiver: "carol"}
+	results, err := service.NewMessageService(messageRepository, service.PublishModeSync).SendMessages(context.Background(), batch)
+	if err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+
+	// The message saved before the failure is not left pending, and nothing is published
+	stored, _ := messageRepository.FindByID(context.Background(), results[0].ID)
+	if stored == nil || stored.Status != entity.MessageStatusFailed {
+		t.Fatalf("expected the saved message to be failed, got %+v", stored)
+	}
+	for i, result := range results {
+		if result.Status != entity.MessageStatusFailed {
+			t.Errorf("expected message %d to be failed, got %s", i, result.Status)
+		}
+	}
+	if len(writer.calls) != 0 {
+		t.Fatalf("expected nothing to be published, got %v", writer.calls)
+	}
+}
@@ -0,0 +1,71 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+)
+
+// idempotencyRepositories returns a memory and a bolt idempotency repository, by name.
+func idempotencyRepositories(t *testing.T) map[string]repository.IdempotencyRepository {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "idempotency.db"), 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("failed to open bolt database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	boltRepository, err := repository.NewBoltIdempotencyRepository(db)
+	if err != nil {
+		t.Fatalf("failed to create bolt repository: %v", err)
+	}
+
+	return map[string]repository.IdempotencyRepository{
+		"memory": repository.NewMemoryIdempotencyRepository(),
+		"bolt":   boltRepository,
+	}
+}
+
+func TestIdempotencyKeyHeldByToken(t *testing.T) {
+	for name, repo := range idempotencyRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+
+			// The first reservation expired, and a retry reserved the key again
+			first := &entity.IdempotencyRecord{Key: "key-1", Token: "first", RequestHash: "hash", CreatedAt: now, ExpiresAt: now.Add(time.Hour), LeaseExpiresAt: now.Add(-time.Second)}
+			if _, err := repo.Reserve(ctx, first); err != nil {
+				t.Fatalf("failed to reserve key: %v", err)
+			}
+			retry := &entity.IdempotencyRecord{Key: "key-1", Token: "retry", RequestHash: "hash", CreatedAt: now, ExpiresAt: now.Add(time.Hour), LeaseExpiresAt: now.Add(time.Hour)}
+			if existing, err := repo.Reserve(ctx, retry); err != nil || existing != nil {
+				t.Fatalf("expected the retry to reserve the key, got %+v, %v", existing, err)
+			}
+
+			// The first request no longer holds the key
+			if err := repo.Complete(ctx, "key-1", "first", 200, []byte(`{"call":1}`)); !errors.Is(err, repository.ErrIdempotencyKeyNotHeld) {
+				t.Fatalf("expected ErrIdempotencyKeyNotHeld, got %v", err)
+			}
+			if err := repo.Delete(ctx, "key-1", "first"); err != nil {
+				t.Fatalf("failed to delete key: %v", err)
+			}
+
+			if err := repo.Complete(ctx, "key-1", "retry", 200, []byte(`{"call":2}`)); err != nil {
+				t.Fatalf("expected the retry to complete the key, got %v", err)
+			}
+
+			stored, err := repo.Reserve(ctx, &entity.IdempotencyRecord{Key: "key-1", Token: "third", CreatedAt: now, ExpiresAt: now.Add(time.Hour), LeaseExpiresAt: now.Add(time.Hour)})
+			if err != nil || stored == nil || string(stored.ResponseBody) != `{"call":2}` {
+				t.Fatalf("expected the retry's response to be kept, got %+v, %v", stored, err)
+			}
+		})
+	}
+}
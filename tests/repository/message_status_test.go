@@ -0,0 +1,135 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+)
+
+// repositories returns a memory and a bolt message repository, by name.
+func repositories(t *testing.T) map[string]repository.MessageRepository {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "messages.db"), 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("failed to open bolt database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	boltRepository, err := repository.NewBoltMessageRepository(db)
+	if err != nil {
+		t.Fatalf("failed to create bolt repository: %v", err)
+	}
+
+	return map[string]repository.MessageRepository{
+		"memory": repository.NewMemoryMessageRepository(),
+		"bolt":   boltRepository,
+	}
+}
+
+func statuses(message *entity.Message) []string {
+	result := make([]string, 0, len(message.StatusHistory))
+	for _, h := range message.StatusHistory {
+		result = append(result, h.Status)
+	}
+	return result
+}
+
+func TestCanTransitionStatus(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{entity.MessageStatusPending, entity.MessageStatusSent, true},
+		{entity.MessageStatusPending, entity.MessageStatusFailed, true},
+		{entity.MessageStatusPending, entity.MessageStatusDelivered, true},
+		{entity.MessageStatusSent, entity.MessageStatusDelivered, true},
+		{entity.MessageStatusFailed, entity.MessageStatusDelivered, true},
+		{entity.MessageStatusSent, entity.MessageStatusSent, true},
+		{entity.MessageStatusSent, entity.MessageStatusPending, false},
+		{entity.MessageStatusSent, entity.MessageStatusFailed, false},
+		{entity.MessageStatusFailed, entity.MessageStatusSent, false},
+		{entity.MessageStatusDelivered, entity.MessageStatusSent, false},
+		{entity.MessageStatusDelivered, entity.MessageStatusFailed, false},
+	}
+
+	for _, c := range cases {
+		if got := entity.CanTransitionStatus(c.from, c.to); got != c.want {
+			t.Errorf("CanTransitionStatus(%s, %s) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestLateSentDoesNotRegressDelivered(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			message := &entity.Message{ID: "msg-1", SenderID: "alice", ReceiverID: "bob", Message: "hello", Status: entity.MessageStatusPending}
+			if err := repo.Save(ctx, message); err != nil {
+				t.Fatalf("failed to save message: %v", err)
+			}
+
+			// The consumer marks the message delivered before the producer marks it sent
+			if err := repo.UpdateStatus(ctx, message.ID, entity.MessageStatusDelivered); err != nil {
+				t.Fatalf("failed to update status: %v", err)
+			}
+			for _, late := range []string{entity.MessageStatusSent, entity.MessageStatusFailed, entity.MessageStatusPending} {
+				if err := repo.UpdateStatus(ctx, message.ID, late); err != nil {
+					t.Fatalf("expected the late %s update to be ignored, got %v", late, err)
+				}
+			}
+
+			stored, err := repo.FindByID(ctx, message.ID)
+			if err != nil {
+				t.Fatalf("failed to find message: %v", err)
+			}
+			if stored.Status != entity.MessageStatusDelivered {
+				t.Errorf("expected status delivered, got %s", stored.Status)
+			}
+			if got := statuses(stored); len(got) != 2 || got[1] != entity.MessageStatusDelivered {
+				t.Errorf("expected history [pending delivered], got %v", got)
+			}
+		})
+	}
+}
+
+func TestStatusMovesForward(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			message := &entity.Message{ID: "msg-1", SenderID: "alice", ReceiverID: "bob", Message: "hello", Status: entity.MessageStatusPending}
+			if err := repo.Save(ctx, message); err != nil {
+				t.Fatalf("failed to save message: %v", err)
+			}
+
+			for _, status := range []string{entity.MessageStatusSent, entity.MessageStatusSent, entity.MessageStatusDelivered} {
+				if err := repo.UpdateStatus(ctx, message.ID, status); err != nil {
+					t.Fatalf("failed to update status to %s: %v", status, err)
+				}
+			}
+
+			stored, _ := repo.FindByID(ctx, message.ID)
+			want := []string{entity.MessageStatusPending, entity.MessageStatusSent, entity.MessageStatusDelivered}
+			if got := statuses(stored); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+				t.Errorf("expected history %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusNotFound(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := repo.UpdateStatus(context.Background(), "missing", entity.MessageStatusSent); !errors.Is(err, repository.ErrMessageNotFound) {
+				t.Errorf("expected ErrMessageNotFound, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+)
+
+func TestDeleteDoneKeepsRecentAndPendingEntries(t *testing.T) {
+	for name, messageRepository := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			outboxRepository, err := repository.NewOutboxRepository(messageRepository)
+			if err != nil {
+				t.Fatalf("failed to create outbox repository: %v", err)
+			}
+
+			for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+				message := &entity.Message{ID: id, SenderID: "alice", ReceiverID: "bob", Message: "hello", Status: entity.MessageStatusPending}
+				entry := &entity.OutboxEntry{MessageID: id, Topic: "messaging", Key: id, Status: entity.OutboxStatusPending, CreatedAt: time.Now()}
+				if err := messageRepository.SaveWithOutbox(ctx, message, entry); err != nil {
+					t.Fatalf("failed to save message %s with outbox: %v", id, err)
+				}
+			}
+
+			// Publish the first two entries, the third one stays pending
+			pending, _ := outboxRepository.FindPending(ctx, 2)
+			for _, entry := range pending {
+				if err := outboxRepository.MarkDone(ctx, entry.ID); err != nil {
+					t.Fatalf("failed to mark entry %d done: %v", entry.ID, err)
+				}
+			}
+
+			// Entries published within the retention are kept
+			if deleted, err := outboxRepository.DeleteDone(ctx, time.Now().Add(-time.Hour)); err != nil || deleted != 0 {
+				t.Fatalf("expected no entry to be deleted, got %d, %v", deleted, err)
+			}
+
+			if deleted, err := outboxRepository.DeleteDone(ctx, time.Now().Add(time.Second)); err != nil || deleted != 2 {
+				t.Fatalf("expected the 2 published entries to be deleted, got %d, %v", deleted, err)
+			}
+			if deleted, _ := outboxRepository.DeleteDone(ctx, time.Now().Add(time.Second)); deleted != 0 {
+				t.Fatalf("expected the published entries to be gone, got %d more", deleted)
+			}
+
+			if count, _ := outboxRepository.CountPending(ctx); count != 1 {
+				t.Fatalf("expected the pending entry to be kept, got %d pending", count)
+			}
+		})
+	}
+}
+
+func TestMarkDeadMovesEntryOutOfPending(t *testing.T) {
+	for name, messageRepository := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			outboxRepository, err := repository.NewOutboxRepository(messageRepository)
+			if err != nil {
+				t.Fatalf("failed to create outbox repository: %v", err)
+			}
+
+			for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+				message := &entity.Message{ID: id, SenderID: "alice", ReceiverID: "bob", Message: "hello", Status: entity.MessageStatusPending}
+				entry := &entity.OutboxEntry{MessageID: id, Topic: "messaging", Key: id, Status: entity.OutboxStatusPending, CreatedAt: time.Now()}
+				if err := messageRepository.SaveWithOutbox(ctx, message, entry); err != nil {
+					t.Fatalf("failed to save message %s with outbox: %v", id, err)
+				}
+			}
+
+			pending, _ := outboxRepository.FindPending(ctx, 2)
+			for _, entry := range pending {
+				if err := outboxRepository.MarkDead(ctx, entry.ID, "message too large"); err != nil {
+					t.Fatalf("failed to mark entry %d dead: %v", entry.ID, err)
+				}
+			}
+
+			if count, _ := outboxRepository.CountPending(ctx); count != 1 {
+				t.Fatalf("expected 1 pending entry, got %d", count)
+			}
+			if count, _ := outboxRepository.CountDead(ctx); count != 2 {
+				t.Fatalf("expected 2 dead entries, got %d", count)
+			}
+
+			// The last written dead entries come first
+			dead, err := outboxRepository.FindDead(ctx, 1)
+			if err != nil || len(dead) != 1 || dead[0].MessageID != "msg-2" {
+				t.Fatalf("expected the last dead entry, got %+v, %v", dead, err)
+			}
+			if dead[0].Status != entity.OutboxStatusDead || dead[0].Attempts != 1 || dead[0].LastError != "message too large" {
+				t.Errorf("unexpected dead entry %+v", dead[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package requestid_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/middleware"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/requestid"
+	httputil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/http-util"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+const topic = "messaging"
+
+// newRouter returns a router whose handler publishes a message with the request context,
+// the message is sent to published.
+func newRouter(published chan<- kafka.Message) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(requestid.RequestID())
+	r.POST("/api/send-message", func(c *gin.Context) {
+		msg, err := kafkautil.NewMessage(c.Request.Context(), topic, "key", map[string]string{"message": "hello"})
+		if err != nil {
+			httputil.InternalServerError(c, "Failed to build message", err.Error())
+			return
+		}
+
+		published <- msg
+		httputil.Success(c, "Message sent successfully", nil)
+	})
+
+	return r
+}
+
+func send(r *gin.Engine, requestID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/send-message", strings.NewReader(`{}`))
+	if requestID != "" {
+		req.Header.Set(requestid.HeaderRequestID, requestID)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequestIDFromHeader(t *testing.T) {
+	logger.SetOutput(&bytes.Buffer{})
+	published := make(chan kafka.Message, 1)
+
+	w := send(newRouter(published), "req-42")
+
+	if got := w.Header().Get(requestid.HeaderRequestID); got != "req-42" {
+		t.Errorf("expected the X-Request-ID response header req-42, got %q", got)
+	}
+
+	var response httputil.HttpResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.RequestID != "req-42" {
+		t.Errorf("expected the request ID in the response body, got %q", response.RequestID)
+	}
+
+	msg := <-published
+	if got, _ := kafkautil.MessageCorrelationID(msg); got != "req-42" {
+		t.Errorf("expected the request ID as the correlation ID of the event, got %q", got)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	logger.SetOutput(&bytes.Buffer{})
+	published := make(chan kafka.Message, 3)
+	r := newRouter(published)
+
+	for _, sent := range []string{"", "bad id\nlevel=error", strings.Repeat("a", 129)} {
+		w := send(r, sent)
+
+		requestID := w.Header().Get(requestid.HeaderRequestID)
+		if _, err := uuid.Parse(requestID); err != nil {
+			t.Errorf("expected a generated request ID for %q, got %q", sent, requestID)
+		}
+
+		msg := <-published
+		if got, _ := kafkautil.MessageCorrelationID(msg); got != requestID {
+			t.Errorf("expected the generated request ID as the correlation ID, got %q", got)
+		}
+	}
+}
+
+func TestErrorResponseCarriesRequestID(t *testing.T) {
+	logger.SetOutput(&bytes.Buffer{})
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(requestid.RequestID())
+	r.GET("/api/messages/:id", func(c *gin.Context) {
+		httputil.NotFound(c, "Message not found", "No message found with ID "+c.Param("id"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/messages/missing", nil)
+	req.Header.Set(requestid.HeaderRequestID, "req-404")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response httputil.HttpResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Status != http.StatusNotFound || response.RequestID != "req-404" {
+		t.Errorf("expected a 404 with the request ID, got %+v", response)
+	}
+}
+
+func TestConsumerRestoresRequestID(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	logger.Exit()
+	t.Cleanup(logger.Exit)
+
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+
+	ctx := kafkautil.WithCorrelationID(context.Background(), "req-42")
+	msg, err := kafkautil.NewMessage(ctx, topic, "key", map[string]string{"message": "hello"})
+	if err != nil {
+		t.Fatalf("failed to build message: %v", err)
+	}
+
+	var handlerCorrelationID string
+	handler := kafkautil.Chain(func(ctx context.Context, worker string, msg kafka.Message) error {
+		handlerCorrelationID, _ = kafkautil.CorrelationID(ctx)
+		logger.InfoContext(ctx, "Reading message", nil)
+		return nil
+	}, middleware.Correlation(), middleware.Logging())
+
+	if err := handler(context.Background(), "Worker-0", msg); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+
+	if handlerCorrelationID != "req-42" {
+		t.Errorf("expected the correlation ID in the handler context, got %q", handlerCorrelationID)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %q: %v", line, err)
+		}
+		if entry[logger.FieldRequestID] != "req-42" {
+			t.Errorf("expected the request ID in the log line, got %v", entry)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+// recordingWriter records the messages written to it, by topic.
+type recordingWriter struct {
+	topic   string
+	written map[string][]kafka.Message
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.written[w.topic] = append(w.written[w.topic], msgs...)
+	return nil
+}
+
+func headerValues(msg kafka.Message) map[string]string {
+	values := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		values[h.Key] = string(h.Value)
+	}
+	return values
+}
+
+func TestPublishDeadLetterHeaders(t *testing.T) {
+	t.Setenv("KAFKA_DLQ_TOPIC", "messaging.failed")
+	initKafka(t, "5s")
+
+	written := map[string][]kafka.Message{}
+	kafkautil.SetWriterProvider(func(topic string) (kafkautil.Writer, error) {
+		return &recordingWriter{topic: topic, written: written}, nil
+	})
+	t.Cleanup(func() { kafkautil.SetWriterProvider(nil) })
+
+	msg := kafka.Message{
+		Topic:     "messaging",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("conversation-1"),
+		Value:     []byte(`{"event_type":"sending-message"}`),
+		Headers:   []kafka.Header{{Key: kafkautil.HeaderEventType, Value: []byte("sending-message")}},
+	}
+
+	before := time.Now()
+	if err := kafkautil.PublishDeadLetter("Worker-1", msg, errors.New("invalid payload")); err != nil {
+		t.Fatalf("failed to publish dead letter: %v", err)
+	}
+
+	dead := written["messaging.failed"]
+	if len(dead) != 1 {
+		t.Fatalf("expected 1 message on the dead-letter topic, got %v", written)
+	}
+
+	// The key, the value and the original headers pass through unchanged
+	if string(dead[0].Key) != string(msg.Key) || string(dead[0].Value) != string(msg.Value) {
+		t.Errorf("expected the key and value unchanged, got %q and %q", dead[0].Key, dead[0].Value)
+	}
+	if dead[0].Headers[0].Key != kafkautil.HeaderEventType || string(dead[0].Headers[0].Value) != "sending-message" {
+		t.Errorf("expected the original headers first, got %v", dead[0].Headers)
+	}
+
+	headers := headerValues(dead[0])
+	for key, want := range map[string]string{
+		kafkautil.HeaderDLQOriginalTopic:     "messaging",
+		kafkautil.HeaderDLQOriginalPartition: "3",
+		kafkautil.HeaderDLQOriginalOffset:    "42",
+		kafkautil.HeaderDLQError:             "invalid payload",
+		kafkautil.HeaderDLQWorkerID:          "Worker-1",
+	} {
+		if headers[key] != want {
+			t.Errorf("expected header %s %q, got %q", key, want, headers[key])
+		}
+	}
+
+	failedAt, err := time.Parse(time.RFC3339Nano, headers[kafkautil.HeaderDLQFailedAt])
+	if err != nil || failedAt.Before(before.Truncate(time.Second)) || failedAt.After(time.Now()) {
+		t.Errorf("expected the failure time in %s, got %q", kafkautil.HeaderDLQFailedAt, headers[kafkautil.HeaderDLQFailedAt])
+	}
+	if len(dead[0].Headers) != len(msg.Headers)+6 {
+		t.Errorf("expected the original headers and the 6 failure headers, got %v", dead[0].Headers)
+	}
+}
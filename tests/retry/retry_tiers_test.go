@@ -0,0 +1,58 @@
+package retry_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/config/async"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+)
+
+func initKafka(t *testing.T, retryDelays string) {
+	t.Helper()
+
+	logger.SetOutput(io.Discard)
+	t.Setenv("KAFKA_BROKERS", "localhost:9092")
+	t.Setenv("KAFKA_TOPICS", "messaging")
+	t.Setenv("KAFKA_RETRY_DELAYS", retryDelays)
+	t.Setenv("KAFKA_COMMIT_MODE", async.CommitModeAuto)
+
+	if !async.InitKafka() {
+		t.Fatal("failed to initialize Kafka")
+	}
+	t.Cleanup(async.CloseKafka)
+}
+
+func TestRetryTiersOrderedByDelay(t *testing.T) {
+	initKafka(t, "10m,5s,1m")
+
+	tiers := async.GetKafkaRetryTiers("messaging")
+	want := []async.RetryTier{
+		{Topic: "messaging.retry.5s", Delay: 5 * time.Second},
+		{Topic: "messaging.retry.1m", Delay: time.Minute},
+		{Topic: "messaging.retry.10m", Delay: 10 * time.Minute},
+	}
+
+	if len(tiers) != len(want) {
+		t.Fatalf("expected %d tiers, got %v", len(want), tiers)
+	}
+	for i := range want {
+		if tiers[i] != want[i] {
+			t.Errorf("expected tier %d to be %v, got %v", i, want[i], tiers[i])
+		}
+	}
+}
+
+func TestRetryTopicsCommitManually(t *testing.T) {
+	initKafka(t, "5s,1m")
+
+	if mode := async.GetKafkaCommitMode("messaging"); mode != async.CommitModeAuto {
+		t.Errorf("expected the topic to keep KAFKA_COMMIT_MODE, got %s", mode)
+	}
+	for _, tier := range async.GetKafkaRetryTiers("messaging") {
+		if mode := async.GetKafkaCommitMode(tier.Topic); mode != async.CommitModeManual {
+			t.Errorf("expected retry topic %s to be committed manually, got %s", tier.Topic, mode)
+		}
+	}
+}
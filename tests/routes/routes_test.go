@@ -0,0 +1,125 @@
+package routes_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/middleware/requestid"
+	"github.com/yoanesber/go-kafka-messaging-demo/routes"
+)
+
+// TestMain discards the log output, so the tests do not write to the logs directory
+func TestMain(m *testing.M) {
+	logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	return routes.SetupRouter(nil, nil, repository.NewMemoryIdempotencyRepository())
+}
+
+func TestMetricsWithoutOrigin(t *testing.T) {
+	r := newRouter()
+
+	// Scrapers send no Origin header
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+		t.Fatalf("expected an uncompressed response, got %s", encoding)
+	}
+	if !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Fatal("expected the metrics in the response")
+	}
+}
+
+func TestMetricsCompressedOnce(t *testing.T) {
+	r := newRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip response with status 200, got %d %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("failed to read the gzip response: %v", err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decompress the response: %v", err)
+	}
+	if !strings.Contains(string(body), "go_goroutines") {
+		t.Fatal("expected the metrics once decompressed")
+	}
+}
+
+func TestAPIRequiresOrigin(t *testing.T) {
+	r := newRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/messages/1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected the API to still require an Origin header, got %d", w.Code)
+	}
+}
+
+func TestPanicIsLoggedAndCounted(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("FRONTEND_URL", "http://localhost:3000")
+	logger.Exit()
+	t.Cleanup(func() {
+		logger.Exit()
+		logger.SetOutput(io.Discard)
+	})
+
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+
+	r := newRouter()
+	r.GET("/api/panic", func(c *gin.Context) {
+		panic("handler failed")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/panic", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set(requestid.HeaderRequestID, "req-500")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", w.Code)
+	}
+
+	// The access log sees the status the panic was answered with
+	var entry map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &entry); err != nil {
+		t.Fatalf("expected one JSON access log line, got %q: %v", buf.String(), err)
+	}
+	if entry["status"] != float64(http.StatusInternalServerError) || entry[logger.FieldRequestID] != "req-500" || entry["level"] != "error" {
+		t.Fatalf("expected the panic to be logged as a 500, got %v", entry)
+	}
+
+	// So do the metrics
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `messaging_http_requests_total{method="GET",route="/api/panic",status="500"} 1`) {
+		t.Fatal("expected the panic to be counted as a 500")
+	}
+}
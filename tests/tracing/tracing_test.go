@@ -0,0 +1,252 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/outbox"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/repository"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/middleware"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/logger"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/tracing"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+const (
+	topic = "messaging"
+
+	// traceparent of a caller, version 00, sampled
+	callerTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	callerTraceparent = "00-" + callerTraceID + "-00f067aa0ba902b7-01"
+)
+
+// TestMain discards the log output, so the tests do not write to the logs directory
+func TestMain(m *testing.M) {
+	logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func newExporter(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := tracing.SetExporter(exporter, false)
+	t.Cleanup(func() { shutdown(context.Background()) })
+
+	return exporter
+}
+
+func spanNamed(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	t.Fatalf("span %s was not exported, got %d spans", name, len(exporter.GetSpans()))
+	return tracetest.SpanStub{}
+}
+
+// publishFromRequest serves a request whose handler builds the message the way PublishMessage does,
+// and returns that message.
+func publishFromRequest(t *testing.T, header http.Header) kafka.Message {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var msg kafka.Message
+	r := gin.New()
+	r.Use(tracing.Requests())
+	r.POST("/api/send-message", func(c *gin.Context) {
+		ctx, span := tracing.StartPublish(c.Request.Context(), topic, 1)
+		defer span.End()
+
+		var err error
+		msg, err = kafkautil.NewMessage(ctx, topic, "key", map[string]string{"message": "hello"})
+		if err != nil {
+			t.Errorf("failed to build message: %v", err)
+		}
+		c.Status(http.StatusAccepted)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/send-message", nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	return msg
+}
+
+func consume(msg kafka.Message, handle kafkautil.HandlerFunc) error {
+	msg.Topic = topic
+	return kafkautil.Chain(handle, middleware.Tracing())(context.Background(), "Worker-0", msg)
+}
+
+func TestTraceCoversRequestPublishAndConsume(t *testing.T) {
+	exporter := newExporter(t)
+
+	msg := publishFromRequest(t, http.Header{"Traceparent": {callerTraceparent}})
+
+	var handlerSpan trace.SpanContext
+	if err := consume(msg, func(ctx context.Context, worker string, msg kafka.Message) error {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to consume: %v", err)
+	}
+
+	server := spanNamed(t, exporter, "POST /api/send-message")
+	publish := spanNamed(t, exporter, "publish "+topic)
+	process := spanNamed(t, exporter, "process "+topic)
+
+	// One trace, continued from the caller
+	for _, span := range []tracetest.SpanStub{server, publish, process} {
+		if span.SpanContext.TraceID().String() != callerTraceID {
+			t.Fatalf("expected span %s in trace %s, got %s", span.Name, callerTraceID, span.SpanContext.TraceID())
+		}
+	}
+
+	if publish.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Fatal("expected the publish span to be a child of the request span")
+	}
+	if process.Parent.SpanID() != publish.SpanContext.SpanID() || !process.Parent.IsRemote() {
+		t.Fatal("expected the process span to be a child of the publish span, from the message headers")
+	}
+	if handlerSpan.SpanID() != process.SpanContext.SpanID() {
+		t.Fatal("expected the handler to get the process span in its context")
+	}
+	if server.SpanKind != trace.SpanKindServer || publish.SpanKind != trace.SpanKindProducer || process.SpanKind != trace.SpanKindConsumer {
+		t.Fatalf("unexpected span kinds: %s, %s, %s", server.SpanKind, publish.SpanKind, process.SpanKind)
+	}
+}
+
+func TestRequestWithoutTraceparentStartsTrace(t *testing.T) {
+	exporter := newExporter(t)
+
+	msg := publishFromRequest(t, nil)
+
+	server := spanNamed(t, exporter, "POST /api/send-message")
+	if server.Parent.IsValid() {
+		t.Fatal("expected the request span to be a root span")
+	}
+
+	carrier := tracing.HeaderCarrier{Headers: &msg.Headers}
+	if carrier.Get("traceparent") == "" {
+		t.Fatal("expected the message to carry a traceparent header")
+	}
+}
+
+func TestFailedHandlerMarksSpan(t *testing.T) {
+	exporter := newExporter(t)
+
+	msg := publishFromRequest(t, nil)
+	handleErr := errors.New("message store unavailable")
+	if err := consume(msg, func(ctx context.Context, worker string, msg kafka.Message) error {
+		return handleErr
+	}); !errors.Is(err, handleErr) {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+
+	process := spanNamed(t, exporter, "process "+topic)
+	if process.Status.Code != codes.Error || len(process.Events) == 0 {
+		t.Fatalf("expected the span to record the error, got %+v", process.Status)
+	}
+}
+
+func TestPublishMessageFailureMarksSpan(t *testing.T) {
+	exporter := newExporter(t)
+
+	// Kafka is not initialized, so there is no writer for the topic
+	if err := kafkautil.PublishMessage(context.Background(), topic, "key", map[string]string{"message": "hello"}); err == nil {
+		t.Fatal("expected the publish to fail")
+	}
+
+	publish := spanNamed(t, exporter, "publish "+topic)
+	if publish.Status.Code != codes.Error {
+		t.Fatalf("expected the publish span to be failed, got %+v", publish.Status)
+	}
+}
+
+func TestHeaderCarrierReplacesTraceparent(t *testing.T) {
+	headers := []kafka.Header{{Key: "traceparent", Value: []byte(callerTraceparent)}, {Key: "x-event-id", Value: []byte("1")}}
+	carrier := tracing.HeaderCarrier{Headers: &headers}
+
+	carrier.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if len(headers) != 2 || carrier.Get("traceparent") != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Fatalf("expected the traceparent to be replaced, got %v", headers)
+	}
+}
+
+// recordingWriter records the messages written to it.
+type recordingWriter struct {
+	messages []kafka.Message
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func TestOutboxRelayContinuesRequestTrace(t *testing.T) {
+	exporter := newExporter(t)
+
+	// The entry is saved while handling the request, with the trace context of the request
+	msg := publishFromRequest(t, http.Header{"Traceparent": {callerTraceparent}})
+	headers := make(entity.OutboxHeaders, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, entity.OutboxHeader{Key: h.Key, Value: h.Value})
+	}
+
+	messageRepository := repository.NewMemoryMessageRepository()
+	outboxRepository, err := repository.NewOutboxRepository(messageRepository)
+	if err != nil {
+		t.Fatalf("failed to create outbox repository: %v", err)
+	}
+	message := &entity.Message{ID: "m1", SenderID: "sender", ReceiverID: "receiver", Message: "hello", Timestamp: time.Now()}
+	entry := &entity.OutboxEntry{MessageID: "m1", Topic: topic, Key: "m1", Value: msg.Value, Headers: headers, Status: entity.OutboxStatusPending, CreatedAt: time.Now()}
+	if err := messageRepository.SaveWithOutbox(context.Background(), message, entry); err != nil {
+		t.Fatalf("failed to save message with outbox: %v", err)
+	}
+
+	writer := &recordingWriter{}
+	relay := outbox.NewRelay(outboxRepository, messageRepository, func(topic string) (outbox.Writer, error) {
+		return writer, nil
+	}, outbox.RelayConfig{BatchSize: 10, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	if _, err := relay.PublishPending(context.Background()); err != nil || len(writer.messages) != 1 {
+		t.Fatalf("failed to publish the outbox entry: %v", err)
+	}
+
+	if err := consume(writer.messages[0], func(ctx context.Context, worker string, msg kafka.Message) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to consume: %v", err)
+	}
+
+	// Request, publish by the handler, publish by the relay, process
+	spans := exporter.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans, got %d", len(spans))
+	}
+	relayPublish, process := spans[2], spans[3]
+	if relayPublish.SpanContext.TraceID().String() != callerTraceID || process.SpanContext.TraceID().String() != callerTraceID {
+		t.Fatal("expected the relay and the consumer to continue the trace of the request")
+	}
+	if process.Parent.SpanID() != relayPublish.SpanContext.SpanID() {
+		t.Fatal("expected the process span to be a child of the relay publish span")
+	}
+}
@@ -0,0 +1,3 @@
+
+sending-message
+$f38d7d4d-5da0-4188-a314-9b94f85c090c$a2f3cbe1-0e4e-4b3b-bb7e-8ff9b6d4a124$f4a1e8d7-22d7-4b3a-b6d1-c9ea2ff6a9b3"Hello, how are you doing today?*2pending
\ No newline at end of file
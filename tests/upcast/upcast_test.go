@@ -0,0 +1,235 @@
+package upcast_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/entity"
+	"github.com/yoanesber/go-kafka-messaging-demo/internal/service"
+	"github.com/yoanesber/go-kafka-messaging-demo/pkg/kafka/handler"
+	kafkautil "github.com/yoanesber/go-kafka-messaging-demo/pkg/util/kafka-util"
+)
+
+const (
+	messageID  = "f38d7d4d-5da0-4188-a314-9b94f85c090c"
+	senderID   = "a2f3cbe1-0e4e-4b3b-bb7e-8ff9b6d4a124"
+	receiverID = "f4a1e8d7-22d7-4b3a-b6d1-c9ea2ff6a9b3"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", name, err)
+	}
+	return data
+}
+
+func eventHeaders(version int) []kafka.Header {
+	return []kafka.Header{
+		{Key: kafkautil.HeaderEventType, Value: []byte(entity.EventTypeSendingMessage)},
+		{Key: kafkautil.HeaderSchemaVersion, Value: []byte(strconv.Itoa(version))},
+		{Key: kafkautil.HeaderContentType, Value: []byte(kafkautil.ContentTypeJSON)},
+	}
+}
+
+// newRegistry returns a registry with the message upcasters, whose handler records what it receives.
+func newRegistry(got *entity.Message, gotVersion *int) *handler.Registry {
+	r := handler.NewRegistry(handler.FallbackDLQ)
+	handler.RegisterMessageUpcasters(r)
+	r.Register(handler.AnyTopic, entity.EventTypeSendingMessage, handler.AnyVersion, func(ctx context.Context, worker string, event handler.Event) error {
+		*gotVersion = event.Version
+		return handler.TypedHandler(func(ctx context.Context, worker string, message *entity.Message) error {
+			*got = *message
+			return nil
+		})(ctx, worker, event)
+	})
+
+	return r
+}
+
+func TestDispatchHistoricalVersions(t *testing.T) {
+	defaultConversationID := entity.DefaultConversationID(senderID, receiverID)
+
+	tests := []struct {
+		fixture            string
+		headers            []kafka.Header
+		wantConversationID string
+		wantAttachments    int
+		wantHistory        int
+	}{
+		// Version 1 envelope published before the version field existed
+		{fixture: "sending-message-v1-unversioned.json", wantConversationID: defaultConversationID},
+		// Version 1 envelope, routed on the event headers
+		{fixture: "sending-message-v1.json", headers: eventHeaders(1), wantConversationID: defaultConversationID, wantHistory: 1},
+		// Version 1 binary CloudEvent, the value is the payload alone
+		{fixture: "sending-message-v1-binary.json", headers: []kafka.Header{
+			{Key: kafkautil.HeaderCloudEventsSpecVersion, Value: []byte("1.0")},
+			{Key: kafkautil.HeaderCloudEventsID, Value: []byte(messageID)},
+			{Key: kafkautil.HeaderCloudEventsType, Value: []byte(kafkautil.CloudEventType(entity.EventTypeSendingMessage))},
+			{Key: kafkautil.HeaderCloudEventsSchemaVersion, Value: []byte("1")},
+			{Key: kafkautil.HeaderContentType, Value: []byte(kafkautil.ContentTypeJSON)},
+		}, wantConversationID: defaultConversationID},
+		// Version 1 structured CloudEvent
+		{fixture: "sending-message-v1-cloudevent.json", headers: []kafka.Header{
+			{Key: kafkautil.HeaderContentType, Value: []byte(kafkautil.ContentTypeCloudEventsJSON)},
+		}, wantConversationID: defaultConversationID},
+		// Version 2 envelope, the current version
+		{fixture: "sending-message-v2.json", wantConversationID: "7a1c5e0e-4f8e-4c1e-9d55-2f0b1a3c6d7e", wantAttachments: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			var got entity.Message
+			var gotVersion int
+			r := newRegistry(&got, &gotVersion)
+
+			msg := kafka.Message{Topic: "messaging", Value: readFixture(t, tt.fixture), Headers: tt.headers}
+			if err := r.Dispatch(context.Background(), "Worker-0", msg); err != nil {
+				t.Fatalf("failed to dispatch: %v", err)
+			}
+
+			if gotVersion != entity.MessageEventVersion {
+				t.Fatalf("expected the handler to get version %d, got %d", entity.MessageEventVersion, gotVersion)
+			}
+			if got.ID != messageID || got.SenderID != senderID || got.ReceiverID != receiverID ||
+				got.Message != "Hello, how are you doing today?" || got.Status != entity.MessageStatusPending ||
+				!got.Timestamp.Equal(time.Date(2025, 6, 22, 16, 2, 12, 0, time.UTC)) {
+				t.Fatalf("unexpected message: %+v", got)
+			}
+			if got.ConversationID != tt.wantConversationID {
+				t.Fatalf("expected conversation ID %s, got %s", tt.wantConversationID, got.ConversationID)
+			}
+			if len(got.Attachments) != tt.wantAttachments || len(got.StatusHistory) != tt.wantHistory {
+				t.Fatalf("expected %d attachments and %d status history entries, got %+v", tt.wantAttachments, tt.wantHistory, got)
+			}
+		})
+	}
+}
+
+// recordingService records the message read by the messaging handler.
+type recordingService struct {
+	service.MessageService
+	read *entity.Message
+}
+
+func (s *recordingService) ReadMessage(ctx context.Context, worker string, message *entity.Message) error {
+	s.read = message
+	return nil
+}
+
+func TestDispatchProtobufVersion1(t *testing.T) {
+	messageService := &recordingService{}
+	r := handler.NewRegistry(handler.FallbackDLQ)
+	handler.NewMessagingHandler(messageService).Register(r)
+
+	// Version 1 protobuf envelope, the payload has no conversation ID and is not upcasted
+	msg := kafka.Message{
+		Topic:   "messaging",
+		Value:   readFixture(t, "sending-message-v1.pb"),
+		Headers: []kafka.Header{{Key: kafkautil.HeaderContentType, Value: []byte(kafkautil.ContentTypeProtobuf)}},
+	}
+	if err := r.Dispatch(context.Background(), "Worker-0", msg); err != nil {
+		t.Fatalf("failed to dispatch: %v", err)
+	}
+
+	got := messageService.read
+	if got == nil || got.ID != messageID || got.SenderID != senderID || got.ReceiverID != receiverID ||
+		got.Message != "Hello, how are you doing today?" {
+		t.Fatalf("unexpected message: %+v", got)
+	}
+	if want := entity.DefaultConversationID(senderID, receiverID); got.ConversationID != want {
+		t.Fatalf("expected conversation ID %s, got %s", want, got.ConversationID)
+	}
+	if got.Attachments == nil || len(got.Attachments) != 0 {
+		t.Fatalf("expected no attachments, got %+v", got.Attachments)
+	}
+}
+
+func TestDefaultConversationIDIsSymmetric(t *testing.T) {
+	if entity.DefaultConversationID(senderID, receiverID) != entity.DefaultConversationID(receiverID, senderID) {
+		t.Fatal("expected the same conversation ID in both directions")
+	}
+	if entity.DefaultConversationID(senderID, receiverID) == entity.DefaultConversationID(senderID, messageID) {
+		t.Fatal("expected different conversations to have different IDs")
+	}
+}
+
+func TestUpcasterChain(t *testing.T) {
+	r := handler.NewRegistry(handler.FallbackDLQ)
+	r.RegisterUpcaster("renamed-field", 1, func(payload map[string]interface{}) (map[string]interface{}, error) {
+		payload["text"] = payload["body"]
+		delete(payload, "body")
+		return payload, nil
+	})
+	r.RegisterUpcaster("renamed-field", 2, func(payload map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"content": payload}, nil
+	})
+
+	type v3 struct {
+		Content struct {
+			Text string `json:"text"`
+			Size int64  `json:"size"`
+		} `json:"content"`
+	}
+
+	var got v3
+	var gotVersion int
+	r.Register(handler.AnyTopic, "renamed-field", 3, func(ctx context.Context, worker string, event handler.Event) error {
+		gotVersion = event.Version
+		return handler.TypedHandler(func(ctx context.Context, worker string, payload *v3) error {
+			got = *payload
+			return nil
+		})(ctx, worker, event)
+	})
+
+	msg := kafka.Message{
+		Value: []byte(`{"event_type":"renamed-field","version":1,"payload":{"body":"hi","size":9007199254740993}}`),
+	}
+	if err := r.Dispatch(context.Background(), "Worker-0", msg); err != nil {
+		t.Fatalf("failed to dispatch: %v", err)
+	}
+
+	if gotVersion != 3 || got.Content.Text != "hi" {
+		t.Fatalf("expected the payload upcasted to version 3, got version %d: %+v", gotVersion, got)
+	}
+	// Numbers keep their precision through the upcasters
+	if got.Content.Size != 9007199254740993 {
+		t.Fatalf("expected size 9007199254740993, got %d", got.Content.Size)
+	}
+
+	// Events already at a later version are not upcasted
+	msg.Value = []byte(`{"event_type":"renamed-field","version":3,"payload":{"content":{"text":"hello"}}}`)
+	if err := r.Dispatch(context.Background(), "Worker-0", msg); err != nil {
+		t.Fatalf("failed to dispatch: %v", err)
+	}
+	if gotVersion != 3 || got.Content.Text != "hello" {
+		t.Fatalf("unexpected payload: %+v", got)
+	}
+}
+
+func TestUpcasterErrorIsNotRetryable(t *testing.T) {
+	var got entity.Message
+	var gotVersion int
+	r := newRegistry(&got, &gotVersion)
+
+	msg := kafka.Message{Value: []byte(`{"event_type":"sending-message","payload":{"id":"` + messageID + `","message":"no sender"}}`)}
+	err := r.Dispatch(context.Background(), "Worker-0", msg)
+	if err == nil {
+		t.Fatal("expected the dispatch to fail")
+	}
+
+	if kafkautil.IsRetryable(err) {
+		t.Fatalf("expected a non-retryable error, got %v", err)
+	}
+	if got.ID != "" {
+		t.Fatal("expected the handler not to be called")
+	}
+}